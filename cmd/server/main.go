@@ -3,10 +3,22 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"go-chat-live/internal/attachment"
+	"go-chat-live/internal/chat"
 	"go-chat-live/internal/database"
+	"go-chat-live/internal/logging"
+	"go-chat-live/internal/metrics"
+	"go-chat-live/internal/proxy"
+	"go-chat-live/internal/room"
 	"go-chat-live/internal/user"
 
 	"github.com/gin-gonic/gin"
@@ -15,39 +27,93 @@ import (
 
 // main initializes and starts the REST API server with database connection,
 // CORS middleware, and user management routes.
+// On SIGINT/SIGTERM it drains in-flight requests and closes the database before exiting.
 func main() {
 	loadEnvironmentVariables()
+	configureServices()
 	setupDatabase()
+	broker := setupEventBroker()
 
 	router := setupRouter()
 	setupRoutes(router)
 
-	startServer(router)
+	server := newServer(router)
+	go startServer(server)
+
+	metricsServer := newMetricsServer()
+	go startMetricsServer(metricsServer)
+
+	waitForShutdown(server, metricsServer, broker)
 }
 
-// loadEnvironmentVariables loads configuration from .env file
+// loadEnvironmentVariables loads configuration from .env file and sets up
+// logging with the level it configures
 func loadEnvironmentVariables() {
 	err := godotenv.Load("../../.env")
+	logging.Setup()
 	if err != nil {
-		log.Println("Warning: could not load .env file, using system variables")
+		slog.Warn("could not load .env file, using system variables")
+	}
+}
+
+// configureServices builds the configuration the packages read from the
+// environment; it must run after loadEnvironmentVariables so .env is seen
+func configureServices() {
+	if err := attachment.Configure(); err != nil {
+		logging.Fatal("invalid attachment configuration", logging.Err(err))
 	}
+	user.Configure()
 }
 
 // setupDatabase initializes database connection and runs migrations
 func setupDatabase() {
 	database.ConnectDB()
-	database.DB.AutoMigrate(&user.User{})
+	database.DB.AutoMigrate(&user.User{}, &user.RefreshToken{}, &user.RevokedToken{}, &user.RevokedSession{}, &user.ActionToken{},
+		&room.Room{}, &room.Member{}, &chat.StoredMessage{}, &chat.MessageEdit{}, &chat.Reaction{}, &chat.ReadMarker{},
+		&chat.Conversation{}, &chat.DirectMessage{}, &chat.NotificationPayload{}, &attachment.Attachment{})
+	if err := chat.MigrateSearchIndex(); err != nil {
+		logging.Fatal("could not create message search index", logging.Err(err))
+	}
 }
 
-// setupRouter creates Gin router with CORS middleware
+// setupEventBroker publishes message edits and deletions made through the API to
+// the wsserver instances when CHAT_BROKER=postgres. With the default in-memory
+// broker the servers run in separate processes, so open clients only see those
+// changes when they reload the history.
+func setupEventBroker() chat.Broker {
+	switch name := os.Getenv("CHAT_BROKER"); name {
+	case "", "memory":
+		slog.Warn("CHAT_BROKER=memory: message edits and deletions made through the API will not reach WebSocket clients; use postgres to deliver them")
+		return nil
+	case "postgres":
+	default:
+		logging.Fatal("unknown CHAT_BROKER, expected memory or postgres", "chat_broker", name)
+	}
+
+	broker, err := chat.NewPostgresBroker()
+	if err != nil {
+		logging.Fatal("could not start postgres broker", logging.Err(err))
+	}
+	chat.SetEventBroker(broker)
+	return broker
+}
+
+// setupRouter creates Gin router with request logging, metrics and CORS middleware
 func setupRouter() *gin.Engine {
-	r := gin.Default()
+	r := gin.New()
+	// Only listed proxies may set X-Forwarded-For; otherwise any client could
+	// pick the IP the login rate limit is keyed on
+	if err := r.SetTrustedProxies(proxy.FromEnv()); err != nil {
+		logging.Fatal("invalid TRUSTED_PROXIES", logging.Err(err))
+	}
+	r.Use(logging.GinMiddleware(), gin.Recovery(), metrics.GinMiddleware())
 
 	// CORS middleware for cross-origin requests
 	r.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID")
+		c.Header("Access-Control-Expose-Headers", "X-Request-ID")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -60,23 +126,129 @@ func setupRouter() *gin.Engine {
 	return r
 }
 
-// setupRoutes defines all API endpoints for user management
+// setupRoutes defines all API endpoints for user management, rooms and chat history
 func setupRoutes(r *gin.Engine) {
 	r.POST("/users", user.CreateUser)
 	r.POST("/login", user.LoginUser)
-	r.GET("/users", user.ListUsers)
-	r.GET("/users/:id", user.GetUserById)
-	r.PUT("/users/:id", user.UpdateUser)
-	r.DELETE("/users/:id", user.DeleteUser)
+	r.POST("/auth/refresh", user.RefreshUserToken)
+	r.POST("/auth/logout", user.AuthMiddleware(), user.LogoutUser)
+	r.POST("/auth/verify-email", user.VerifyUserEmail)
+	r.POST("/auth/verify-email/resend", user.AuthMiddleware(), user.ResendVerificationEmail)
+	r.POST("/auth/forgot-password", user.ForgotPassword)
+	r.POST("/auth/reset-password", user.ResetUserPassword)
+
+	users := r.Group("/users", user.AuthMiddleware())
+	users.GET("", user.ListUsers)
+	users.GET("/:id", user.GetUserById)
+	users.PUT("/:id", user.UpdateUser)
+	users.DELETE("/:id", user.DeleteUser)
+	users.PUT("/:id/role", user.RequireRole(user.RoleAdmin), user.SetUserRole)
+	users.POST("/me/password", user.ChangeUserPassword)
+
+	rooms := r.Group("/rooms", user.AuthMiddleware())
+	rooms.POST("", room.CreateRoom)
+	rooms.GET("", room.ListRooms)
+	rooms.GET("/:id", room.GetRoom)
+	rooms.PUT("/:id", room.UpdateRoom)
+	rooms.DELETE("/:id", room.DeleteRoom)
+	rooms.POST("/:id/join", room.JoinRoom)
+	rooms.POST("/:id/leave", room.LeaveRoom)
+	rooms.POST("/:id/members", room.AddRoomMember)
+	rooms.GET("/:id/messages", chat.ListRoomMessages)
+	rooms.PUT("/:id/messages/:messageId", chat.EditRoomMessage)
+	rooms.DELETE("/:id/messages/:messageId", chat.DeleteRoomMessage)
+	rooms.GET("/:id/messages/:messageId/edits", chat.ListMessageEdits)
+	rooms.POST("/:id/attachments", attachment.UploadAttachment)
+
+	// Downloads are authorized by the signature of the URL returned with the attachment
+	r.GET("/attachments/:id", attachment.DownloadAttachment)
+	r.GET("/attachments/:id/thumbnail", attachment.DownloadThumbnail)
+
+	messages := r.Group("/messages", user.AuthMiddleware())
+	messages.GET("/:id/thread", chat.GetThread)
+
+	conversations := r.Group("/conversations", user.AuthMiddleware())
+	conversations.GET("", chat.ListConversations)
+	conversations.GET("/:userId/messages", chat.ListConversationMessages)
+
+	search := r.Group("/search", user.AuthMiddleware())
+	search.GET("/messages", chat.SearchRoomMessages)
+
+	me := r.Group("/me", user.AuthMiddleware())
+	me.GET("/unread", chat.ListUnread)
 }
 
-// startServer starts the HTTP server on configured port
-func startServer(r *gin.Engine) {
+// newServer creates the HTTP server on configured port
+func newServer(r *gin.Engine) *http.Server {
 	port := os.Getenv("REST_PORT")
 	if port == "" {
 		port = "8080"
 	}
+	return &http.Server{Addr: ":" + port, Handler: r}
+}
+
+// startServer starts the HTTP server and blocks until it is shut down
+func startServer(server *http.Server) {
+	slog.Info("REST API server starting", "addr", server.Addr)
+	err := server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logging.Fatal("REST API server error", logging.Err(err))
+	}
+}
+
+// newMetricsServer creates the server exposing /metrics on METRICS_ADDR, an
+// internal address kept off the public listener
+func newMetricsServer() *http.Server {
+	addr := os.Getenv("METRICS_ADDR")
+	if addr == "" {
+		addr = "127.0.0.1:9090"
+	}
+	return metrics.NewServer(addr)
+}
 
-	log.Printf("REST API server starting on port %s", port)
-	r.Run(":" + port)
+// startMetricsServer starts the metrics server and blocks until it is shut down
+func startMetricsServer(server *http.Server) {
+	slog.Info("metrics server starting", "addr", server.Addr)
+	err := server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logging.Fatal("metrics server error", logging.Err(err))
+	}
+}
+
+// waitForShutdown blocks until SIGINT/SIGTERM, then stops accepting connections,
+// waits for in-flight requests and closes the database pool within SHUTDOWN_TIMEOUT
+func waitForShutdown(server, metricsServer *http.Server, broker chat.Broker) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+
+	slog.Info("shutting down REST API server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout())
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("HTTP server shutdown error", logging.Err(err))
+	}
+	if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+		slog.Error("metrics server shutdown error", logging.Err(err))
+	}
+	if broker != nil {
+		if err := broker.Close(); err != nil {
+			slog.Error("broker close error", logging.Err(err))
+		}
+	}
+	if err := database.CloseDB(); err != nil {
+		slog.Error("database close error", logging.Err(err))
+	}
+
+	slog.Info("REST API server stopped")
+}
+
+// shutdownTimeout reads the graceful shutdown drain timeout from environment variables
+func shutdownTimeout() time.Duration {
+	timeout, err := time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT"))
+	if err != nil || timeout <= 0 {
+		return 15 * time.Second
+	}
+	return timeout
 }
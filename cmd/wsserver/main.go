@@ -4,12 +4,22 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"go-chat-live/internal/attachment"
 	"go-chat-live/internal/chat"
 	"go-chat-live/internal/database"
+	"go-chat-live/internal/logging"
+	"go-chat-live/internal/metrics"
+	"go-chat-live/internal/proxy"
+	"go-chat-live/internal/room"
 	"go-chat-live/internal/user"
 
 	"github.com/joho/godotenv"
@@ -17,54 +27,175 @@ import (
 
 // main initializes and starts the WebSocket server with database connection,
 // chat hub for managing connections, and JWT-authenticated WebSocket endpoint.
+// On SIGINT/SIGTERM it drains the hub and closes the database before exiting.
 func main() {
 	loadEnvironmentVariables()
+	configureServices()
 	setupDatabase()
 
 	hub := initializeChatHub()
 	setupWebSocketEndpoint(hub)
 
-	startWebSocketServer()
+	server := newWebSocketServer()
+	go startWebSocketServer(server)
+
+	metricsServer := newMetricsServer()
+	go startMetricsServer(metricsServer)
+
+	waitForShutdown(server, metricsServer, hub)
 }
 
-// loadEnvironmentVariables loads configuration from .env file
+// loadEnvironmentVariables loads configuration from .env file and sets up
+// logging with the level it configures
 func loadEnvironmentVariables() {
 	err := godotenv.Load("../../.env")
+	logging.Setup()
 	if err != nil {
-		log.Println("Warning: could not load .env file, using system variables")
+		slog.Warn("could not load .env file, using system variables")
+	}
+}
+
+// configureServices builds the configuration the packages read from the
+// environment; it must run after loadEnvironmentVariables so .env is seen
+func configureServices() {
+	if err := attachment.Configure(); err != nil {
+		logging.Fatal("invalid attachment configuration", logging.Err(err))
 	}
 }
 
 // setupDatabase initializes database connection and runs migrations
 func setupDatabase() {
 	database.ConnectDB()
-	database.DB.AutoMigrate(&user.User{})
+	database.DB.AutoMigrate(&user.User{}, &user.RefreshToken{}, &user.RevokedToken{}, &user.RevokedSession{}, &user.ActionToken{},
+		&room.Room{}, &room.Member{}, &chat.StoredMessage{}, &chat.MessageEdit{}, &chat.Reaction{}, &chat.ReadMarker{},
+		&chat.Conversation{}, &chat.DirectMessage{}, &chat.NotificationPayload{}, &attachment.Attachment{})
+	if err := chat.MigrateSearchIndex(); err != nil {
+		logging.Fatal("could not create message search index", logging.Err(err))
+	}
 }
 
-// initializeChatHub creates and starts the chat hub in a separate goroutine
+// initializeChatHub creates and starts the chat hub in a separate goroutine.
+// CHAT_BROKER=postgres shares rooms between wsserver replicas through PostgreSQL
+// LISTEN/NOTIFY and carries the edits and deletions made through the REST API;
+// the default in-memory broker serves a single instance without them.
 func initializeChatHub() *chat.Hub {
-	hub := chat.NewHub()
+	var broker chat.Broker
+	switch name := os.Getenv("CHAT_BROKER"); name {
+	case "", "memory":
+		slog.Warn("CHAT_BROKER=memory: message edits and deletions made through the API will not reach WebSocket clients; use postgres to deliver them")
+		broker = chat.NewMemoryBroker()
+	case "postgres":
+		pgBroker, err := chat.NewPostgresBroker()
+		if err != nil {
+			logging.Fatal("could not start postgres broker", logging.Err(err))
+		}
+		broker = pgBroker
+		slog.Info("using PostgreSQL LISTEN/NOTIFY broker")
+	default:
+		logging.Fatal("unknown CHAT_BROKER, expected memory or postgres", "chat_broker", name)
+	}
+
+	trusted, err := proxy.Parse(proxy.FromEnv())
+	if err != nil {
+		logging.Fatal("invalid TRUSTED_PROXIES", logging.Err(err))
+	}
+	config := chat.LoadHubConfig()
+	config.TrustedProxies = trusted
+
+	hub := chat.NewHubWithConfig(broker, config)
+	chat.RegisterMetrics(hub)
 	go hub.Run()
 	return hub
 }
 
 // setupWebSocketEndpoint configures the /ws endpoint for WebSocket connections
+// and the presence and session endpoints backed by the hub's in-memory state
 func setupWebSocketEndpoint(hub *chat.Hub) {
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		chat.ServeWs(hub, w, r)
 	})
+	http.HandleFunc("/rooms/{id}/presence", func(w http.ResponseWriter, r *http.Request) {
+		chat.ServePresence(hub, w, r)
+	})
+	http.HandleFunc("/me/sessions", func(w http.ResponseWriter, r *http.Request) {
+		chat.ServeSessions(hub, w, r)
+	})
+	http.HandleFunc("/me/sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		chat.ServeSignOut(hub, w, r)
+	})
 }
 
-// startWebSocketServer starts the HTTP server on configured port
-func startWebSocketServer() {
+// newWebSocketServer creates the HTTP server on configured port, logging every
+// request with a request ID
+func newWebSocketServer() *http.Server {
 	port := os.Getenv("WS_PORT")
 	if port == "" {
 		port = "8081"
 	}
+	return &http.Server{Addr: ":" + port, Handler: logging.HTTPMiddleware(http.DefaultServeMux)}
+}
 
-	log.Printf("WebSocket server starting on port %s", port)
-	err := http.ListenAndServe(":"+port, nil)
-	if err != nil {
-		log.Fatal("WebSocket server error:", err)
+// startWebSocketServer starts the HTTP server and blocks until it is shut down
+func startWebSocketServer(server *http.Server) {
+	slog.Info("WebSocket server starting", "addr", server.Addr)
+	err := server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logging.Fatal("WebSocket server error", logging.Err(err))
+	}
+}
+
+// newMetricsServer creates the server exposing /metrics on METRICS_ADDR, an
+// internal address kept off the public listener
+func newMetricsServer() *http.Server {
+	addr := os.Getenv("METRICS_ADDR")
+	if addr == "" {
+		addr = "127.0.0.1:9091"
+	}
+	return metrics.NewServer(addr)
+}
+
+// startMetricsServer starts the metrics server and blocks until it is shut down
+func startMetricsServer(server *http.Server) {
+	slog.Info("metrics server starting", "addr", server.Addr)
+	err := server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logging.Fatal("metrics server error", logging.Err(err))
+	}
+}
+
+// waitForShutdown blocks until SIGINT/SIGTERM, then stops accepting connections,
+// sends a close frame to every client after flushing its queue, stops the hub
+// and closes the database pool, all within SHUTDOWN_TIMEOUT
+func waitForShutdown(server, metricsServer *http.Server, hub *chat.Hub) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+
+	slog.Info("shutting down WebSocket server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout())
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("HTTP server shutdown error", logging.Err(err))
+	}
+	if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+		slog.Error("metrics server shutdown error", logging.Err(err))
+	}
+	if err := hub.Shutdown(shutdownCtx); err != nil {
+		slog.Error("chat hub shutdown error", logging.Err(err))
+	}
+	if err := database.CloseDB(); err != nil {
+		slog.Error("database close error", logging.Err(err))
+	}
+
+	slog.Info("WebSocket server stopped")
+}
+
+// shutdownTimeout reads the graceful shutdown drain timeout from environment variables
+func shutdownTimeout() time.Duration {
+	timeout, err := time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT"))
+	if err != nil || timeout <= 0 {
+		return 15 * time.Second
 	}
+	return timeout
 }
@@ -0,0 +1,104 @@
+package attachment
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the upload limits and download URL settings.
+type Config struct {
+	MaxSize       int64         // Largest accepted upload, in bytes
+	AllowedTypes  []string      // MIME types accepted, detected from the file content
+	ThumbnailSize int           // Longest side of generated thumbnails, in pixels
+	URLTTL        time.Duration // How long signed download URLs stay valid
+	BaseURL       string        // Prefix of download URLs, e.g. https://api.example.com; relative when empty
+	URLSecret     []byte        // Key used to sign download URLs
+}
+
+// defaultAllowedTypes are accepted when ATTACHMENT_ALLOWED_TYPES is not set
+var defaultAllowedTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp", "application/pdf", "text/plain"}
+
+// ErrNoURLSecret is returned by LoadConfig when no key is configured to sign download URLs
+var ErrNoURLSecret = errors.New("ATTACHMENT_URL_SECRET or JWT_SECRET must be set to sign download URLs")
+
+// config is the configuration used by the attachment service. It holds the
+// defaults, with no URL secret, until Configure reads the environment.
+var config = defaultConfig()
+
+// defaultConfig returns the limits used when no environment variable overrides them
+func defaultConfig() Config {
+	return Config{
+		MaxSize:       10 << 20,
+		AllowedTypes:  defaultAllowedTypes,
+		ThumbnailSize: 320,
+		URLTTL:        time.Hour,
+	}
+}
+
+// Configure reads the configuration and the blob store from the environment.
+// Call it once the .env file is loaded, before serving requests.
+func Configure() error {
+	loaded, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	config = loaded
+	store = NewBlobStoreFromEnv()
+	return nil
+}
+
+// LoadConfig reads the attachment configuration from environment variables with defaults.
+// Download URLs are signed with ATTACHMENT_URL_SECRET, falling back to JWT_SECRET;
+// it fails with ErrNoURLSecret when neither is set.
+func LoadConfig() (Config, error) {
+	config := defaultConfig()
+	config.MaxSize = envInt64("ATTACHMENT_MAX_SIZE", config.MaxSize)
+	config.ThumbnailSize = int(envInt64("ATTACHMENT_THUMBNAIL_SIZE", int64(config.ThumbnailSize)))
+	config.BaseURL = strings.TrimRight(os.Getenv("ATTACHMENT_BASE_URL"), "/")
+
+	if value := os.Getenv("ATTACHMENT_ALLOWED_TYPES"); value != "" {
+		config.AllowedTypes = nil
+		for _, t := range strings.Split(value, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				config.AllowedTypes = append(config.AllowedTypes, strings.ToLower(t))
+			}
+		}
+	}
+
+	if value := os.Getenv("ATTACHMENT_URL_TTL"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			config.URLTTL = d
+		} else {
+			slog.Warn("invalid environment variable, using default", "key", "ATTACHMENT_URL_TTL", "value", value, "default", config.URLTTL.String())
+		}
+	}
+
+	secret := os.Getenv("ATTACHMENT_URL_SECRET")
+	if secret == "" {
+		secret = os.Getenv("JWT_SECRET")
+	}
+	if secret == "" {
+		return config, ErrNoURLSecret
+	}
+	config.URLSecret = []byte(secret)
+
+	return config, nil
+}
+
+// envInt64 reads a positive integer environment variable, falling back to def
+func envInt64(key string, def int64) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n <= 0 {
+		slog.Warn("invalid environment variable, using default", "key", key, "value", value, "default", def)
+		return def
+	}
+	return n
+}
@@ -0,0 +1,151 @@
+package attachment
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go-chat-live/internal/logging"
+	"go-chat-live/internal/room"
+	"go-chat-live/internal/user"
+
+	"github.com/gin-gonic/gin"
+)
+
+// multipartOverhead is the room allowed for multipart headers on top of the file size
+const multipartOverhead = 64 << 10
+
+// UploadAttachment handles multipart POST requests uploading the "file" field to
+// the room in the :id path parameter. The user must be a member of the room.
+func UploadAttachment(c *gin.Context) {
+	userID, ok := user.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthenticated"})
+		return
+	}
+
+	roomNumber, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+	roomID := strconv.Itoa(roomNumber) // Canonical ID, so "07" and "7" are the same room
+	if _, err := room.FindForMember(roomNumber, userID); err != nil {
+		if errors.Is(err, room.ErrNotMember) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, config.MaxSize+multipartOverhead)
+	header, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": ErrTooLarge.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Multipart field \"file\" is required"})
+		return
+	}
+	if header.Size > config.MaxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": ErrTooLarge.Error()})
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read file"})
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, config.MaxSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read file"})
+		return
+	}
+
+	attachment, err := Upload(userID, roomID, header.Filename, data)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrTooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		case errors.Is(err, ErrTypeNotAllowed):
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+		case errors.Is(err, ErrEmptyFile):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, attachment)
+}
+
+// DownloadAttachment handles GET requests for the file of an attachment.
+// The request is authorized by the signature of the URL, so it can be used
+// directly in links and <img> tags.
+func DownloadAttachment(c *gin.Context) {
+	serveAttachment(c, false)
+}
+
+// DownloadThumbnail handles GET requests for the thumbnail of an image attachment.
+func DownloadThumbnail(c *gin.Context) {
+	serveAttachment(c, true)
+}
+
+// serveAttachment verifies the signed URL and streams the requested variant
+func serveAttachment(c *gin.Context, thumbnail bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": ErrInvalidSignature.Error()})
+		return
+	}
+
+	attachment, reader, err := Open(uint(id), thumbnail, expires, c.Query("signature"))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidSignature):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	defer reader.Close()
+
+	contentType, disposition := attachment.ContentType, "attachment"
+	if thumbnail {
+		contentType = "image/jpeg"
+	}
+	if strings.HasPrefix(contentType, "image/") {
+		disposition = "inline"
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.FileName}))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "private, max-age="+fmt.Sprint(int(config.URLTTL.Seconds())))
+	if !thumbnail {
+		c.Header("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	}
+	c.Status(http.StatusOK)
+
+	if _, err := io.Copy(c.Writer, reader); err != nil {
+		logging.FromContext(c.Request.Context()).Warn("could not stream attachment", "attachment_id", attachment.ID, "error", err)
+	}
+}
@@ -0,0 +1,27 @@
+package attachment
+
+import "time"
+
+// Attachment is a file uploaded to a room. It is uploaded first and then
+// referenced by ID from a chat message, which links it to that message.
+type Attachment struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`             // Primary key for database
+	UserID       uint      `gorm:"index;not null" json:"userId"`     // Uploader user ID
+	RoomID       string    `gorm:"index;not null" json:"roomId"`     // Room the file was uploaded to
+	MessageID    *uint     `gorm:"index" json:"messageId,omitempty"` // Message referencing the file, nil until it is sent
+	FileName     string    `gorm:"not null" json:"fileName"`         // Original file name, without directories
+	ContentType  string    `gorm:"not null" json:"contentType"`      // MIME type detected from the content
+	Size         int64     `gorm:"not null" json:"size"`             // Size in bytes
+	Width        int       `json:"width,omitempty"`                  // Image width in pixels, for images
+	Height       int       `json:"height,omitempty"`                 // Image height in pixels, for images
+	StorageKey   string    `gorm:"not null" json:"-"`                // BlobStore key of the file
+	ThumbnailKey string    `json:"-"`                                // BlobStore key of the thumbnail, empty if none
+	CreatedAt    time.Time `json:"createdAt"`                        // Upload timestamp
+	URL          string    `gorm:"-" json:"url"`                     // Signed download URL, filled when returned to clients
+	ThumbnailURL string    `gorm:"-" json:"thumbnailUrl,omitempty"`  // Signed thumbnail URL, for images
+}
+
+// TableName overrides the default GORM table name.
+func (Attachment) TableName() string {
+	return "attachments"
+}
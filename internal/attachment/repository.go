@@ -0,0 +1,70 @@
+package attachment
+
+import (
+	"go-chat-live/internal/database"
+
+	"gorm.io/gorm"
+)
+
+// AttachmentRepository defines the interface for attachment data access operations.
+// This interface follows the Repository pattern to abstract database operations.
+type AttachmentRepository interface {
+	Create(attachment *Attachment) error                                  // Persists a new attachment
+	FindById(id uint) (*Attachment, error)                                // Finds an attachment by ID
+	FindByIds(ids []uint) ([]Attachment, error)                           // Finds the attachments with the given IDs
+	FindByMessages(messageIDs []uint) ([]Attachment, error)               // Attachments linked to the given messages, oldest first
+	LinkToMessage(tx *gorm.DB, ids []uint, messageID uint) (int64, error) // Links unlinked attachments to a message within tx, returning how many were linked
+}
+
+// attachmentRepositoryImpl implements AttachmentRepository using GORM ORM.
+type attachmentRepositoryImpl struct{}
+
+// NewAttachmentRepository creates a new instance of AttachmentRepository.
+func NewAttachmentRepository() AttachmentRepository {
+	return &attachmentRepositoryImpl{}
+}
+
+// Create inserts a new attachment into the database.
+func (r *attachmentRepositoryImpl) Create(attachment *Attachment) error {
+	return database.DB.Create(attachment).Error
+}
+
+// FindById retrieves an attachment by its ID.
+func (r *attachmentRepositoryImpl) FindById(id uint) (*Attachment, error) {
+	var attachment Attachment
+	err := database.DB.First(&attachment, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+// FindByIds retrieves the attachments with the given IDs, in ID order.
+func (r *attachmentRepositoryImpl) FindByIds(ids []uint) ([]Attachment, error) {
+	var attachments []Attachment
+	if len(ids) == 0 {
+		return attachments, nil
+	}
+	err := database.DB.Where("id IN ?", ids).Order("id ASC").Find(&attachments).Error
+	return attachments, err
+}
+
+// FindByMessages retrieves every attachment linked to the given messages, oldest first.
+func (r *attachmentRepositoryImpl) FindByMessages(messageIDs []uint) ([]Attachment, error) {
+	var attachments []Attachment
+	if len(messageIDs) == 0 {
+		return attachments, nil
+	}
+	err := database.DB.Where("message_id IN ?", messageIDs).Order("id ASC").Find(&attachments).Error
+	return attachments, err
+}
+
+// LinkToMessage sets the message of the given attachments. Attachments already
+// linked to a message are left untouched, so a file cannot be claimed twice.
+// It runs on tx, the transaction that inserts the message.
+func (r *attachmentRepositoryImpl) LinkToMessage(tx *gorm.DB, ids []uint, messageID uint) (int64, error) {
+	result := tx.Model(&Attachment{}).
+		Where("id IN ? AND message_id IS NULL", ids).
+		Update("message_id", messageID)
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,173 @@
+package attachment
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// S3Store keeps blobs in a bucket of an S3-compatible service (AWS S3, MinIO,
+// Ceph, ...). Requests use path-style URLs ({Endpoint}/{Bucket}/{key}) and are
+// signed with AWS Signature Version 4.
+type S3Store struct {
+	Endpoint  string       // Service base URL, e.g. https://s3.us-east-1.amazonaws.com or http://localhost:9000
+	Bucket    string       // Bucket name
+	Region    string       // Signing region
+	AccessKey string       // Access key ID
+	SecretKey string       // Secret access key
+	Client    *http.Client // HTTP client, http.DefaultClient when nil
+}
+
+// Put uploads data as an object.
+func (s *S3Store) Put(key string, data []byte, contentType string) error {
+	resp, err := s.do(http.MethodPut, key, data, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s.responseError("put", key, resp)
+	}
+	return nil
+}
+
+// Get downloads an object. The caller must close the returned body.
+func (s *S3Store) Get(key string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, key, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrBlobNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, s.responseError("get", key, resp)
+	}
+	return resp.Body, nil
+}
+
+// Delete removes an object. Deleting a missing object is not an error.
+func (s *S3Store) Delete(key string) error {
+	resp, err := s.do(http.MethodDelete, key, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s.responseError("delete", key, resp)
+	}
+	return nil
+}
+
+// do sends a signed request for an object of the bucket
+func (s *S3Store) do(method, key string, body []byte, contentType string) (*http.Response, error) {
+	if !validKey(key) {
+		return nil, fmt.Errorf("invalid blob key")
+	}
+
+	objectURL := strings.TrimRight(s.Endpoint, "/") + "/" + s.Bucket + "/" + key
+	req, err := http.NewRequest(method, objectURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req, body, time.Now().UTC())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// responseError builds an error from a failed S3 response, including the start of its body
+func (s *S3Store) responseError(op, key string, resp *http.Response) error {
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("s3 %s %s: status %d: %s", op, key, resp.StatusCode, strings.TrimSpace(string(detail)))
+}
+
+// sign adds the AWS Signature Version 4 headers to req. The host, payload hash
+// and date headers are signed; the payload hash covers the whole body.
+func (s *S3Store) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI(req.URL),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+// canonicalURI encodes every path segment as required by Signature Version 4
+func canonicalURI(u *url.URL) string {
+	segments := strings.Split(u.Path, "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// uriEncode percent-encodes every byte except the unreserved characters A-Z a-z 0-9 - _ . ~
+func uriEncode(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,295 @@
+package attachment
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// Errors returned by the attachment service
+var (
+	ErrEmptyFile        = errors.New("file is empty")
+	ErrTooLarge         = errors.New("file is too large")
+	ErrTypeNotAllowed   = errors.New("file type is not allowed")
+	ErrNotFound         = errors.New("attachment not found")
+	ErrTooMany          = errors.New("too many attachments")
+	ErrInvalidSignature = errors.New("invalid or expired download URL")
+)
+
+// MaxPerMessage is the largest number of attachments a single message may reference
+const MaxPerMessage = 10
+
+// Download variants signed into URLs
+const (
+	variantOriginal  = "original"
+	variantThumbnail = "thumbnail"
+)
+
+// repo is the global repository instance used by service functions
+var repo = NewAttachmentRepository()
+
+// Upload validates a file uploaded by the user to the room, stores it (and a
+// thumbnail, for images) in the BlobStore and records it. The returned
+// attachment carries signed download URLs.
+func Upload(userID uint, roomID, fileName string, data []byte) (*Attachment, error) {
+	if len(data) == 0 {
+		return nil, ErrEmptyFile
+	}
+	if int64(len(data)) > config.MaxSize {
+		return nil, ErrTooLarge
+	}
+
+	contentType, ok := detectType(data)
+	if !ok {
+		return nil, ErrTypeNotAllowed
+	}
+
+	prefix, err := newStoragePrefix()
+	if err != nil {
+		return nil, err
+	}
+
+	attachment := &Attachment{
+		UserID:      userID,
+		RoomID:      roomID,
+		FileName:    cleanFileName(fileName),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		StorageKey:  prefix + "/" + variantOriginal,
+		CreatedAt:   time.Now(),
+	}
+
+	if err := store.Put(attachment.StorageKey, data, contentType); err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(contentType, "image/") {
+		addThumbnail(attachment, prefix, data)
+	}
+
+	if err := repo.Create(attachment); err != nil {
+		store.Delete(attachment.StorageKey)
+		if attachment.ThumbnailKey != "" {
+			store.Delete(attachment.ThumbnailKey)
+		}
+		return nil, err
+	}
+
+	signURLs(attachment)
+	return attachment, nil
+}
+
+// addThumbnail records the image size and stores a thumbnail. Images that cannot
+// be decoded (e.g. WebP) are kept without one.
+func addThumbnail(attachment *Attachment, prefix string, data []byte) {
+	width, height, err := imageSize(data)
+	if err != nil {
+		return
+	}
+	attachment.Width, attachment.Height = width, height
+
+	thumb, err := makeThumbnail(data, config.ThumbnailSize)
+	if err != nil {
+		slog.Warn("could not generate thumbnail", "key", attachment.StorageKey, "error", err)
+		return
+	}
+
+	key := prefix + "/" + variantThumbnail + ".jpg"
+	if err := store.Put(key, thumb, "image/jpeg"); err != nil {
+		slog.Error("could not store thumbnail", "key", key, "error", err)
+		return
+	}
+	attachment.ThumbnailKey = key
+}
+
+// detectType sniffs the MIME type of data and reports whether it is allowed.
+// The type declared by the client is ignored so files cannot lie about their content.
+func detectType(data []byte) (string, bool) {
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil {
+		return "", false
+	}
+	for _, allowed := range config.AllowedTypes {
+		if mediaType == allowed {
+			return mediaType, true
+		}
+	}
+	return mediaType, false
+}
+
+// cleanFileName strips directories and control characters from a client file name
+func cleanFileName(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if len(name) > 255 {
+		name = name[:255]
+	}
+	if name == "" || name == "." || name == ".." {
+		return "file"
+	}
+	return name
+}
+
+// newStoragePrefix returns a random, unguessable key prefix for a new upload
+func newStoragePrefix() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "attachments/" + hex.EncodeToString(buf), nil
+}
+
+// Pending loads the attachments a user wants to reference from a new message in
+// the room. Every attachment must have been uploaded by the user to that room
+// and not yet be linked to another message.
+func Pending(ids []uint, userID uint, roomID string) ([]Attachment, error) {
+	ids = uniqueIDs(ids)
+	if len(ids) > MaxPerMessage {
+		return nil, ErrTooMany
+	}
+
+	attachments, err := repo.FindByIds(ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(attachments) != len(ids) {
+		return nil, ErrNotFound
+	}
+	for i := range attachments {
+		a := &attachments[i]
+		if a.UserID != userID || a.RoomID != roomID || a.MessageID != nil {
+			return nil, ErrNotFound
+		}
+		signURLs(a)
+	}
+	return attachments, nil
+}
+
+// Link attaches the given attachments to a message within tx, the transaction
+// that inserts it, so the message is never stored without its attachments.
+func Link(tx *gorm.DB, attachments []Attachment, messageID uint) error {
+	ids := make([]uint, len(attachments))
+	for i := range attachments {
+		ids[i] = attachments[i].ID
+		attachments[i].MessageID = &messageID
+	}
+
+	linked, err := repo.LinkToMessage(tx, ids, messageID)
+	if err != nil {
+		return err
+	}
+	if linked != int64(len(ids)) {
+		return fmt.Errorf("%d of %d attachments were already linked to another message", int64(len(ids))-linked, len(ids))
+	}
+	return nil
+}
+
+// ForMessages returns the attachments of each message, with signed download URLs
+func ForMessages(messageIDs []uint) (map[uint][]Attachment, error) {
+	attachments, err := repo.FindByMessages(messageIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	byMessage := make(map[uint][]Attachment)
+	for _, a := range attachments {
+		signURLs(&a)
+		byMessage[*a.MessageID] = append(byMessage[*a.MessageID], a)
+	}
+	return byMessage, nil
+}
+
+// Open verifies a signed download URL and opens the requested variant of the
+// attachment. The caller must close the returned reader.
+func Open(id uint, thumbnail bool, expires int64, signature string) (*Attachment, io.ReadCloser, error) {
+	variant := variantOriginal
+	if thumbnail {
+		variant = variantThumbnail
+	}
+	if time.Now().Unix() > expires || !hmac.Equal([]byte(signature), []byte(sign(id, variant, expires))) {
+		return nil, nil, ErrInvalidSignature
+	}
+
+	attachment, err := repo.FindById(id)
+	if err != nil || attachment == nil {
+		return nil, nil, ErrNotFound
+	}
+
+	key := attachment.StorageKey
+	if thumbnail {
+		if attachment.ThumbnailKey == "" {
+			return nil, nil, ErrNotFound
+		}
+		key = attachment.ThumbnailKey
+	}
+
+	reader, err := store.Get(key)
+	if errors.Is(err, ErrBlobNotFound) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return attachment, reader, nil
+}
+
+// signURLs fills the signed download URLs of an attachment
+func signURLs(attachment *Attachment) {
+	expires := time.Now().Add(config.URLTTL).Unix()
+	attachment.URL = downloadURL(attachment.ID, variantOriginal, expires)
+	if attachment.ThumbnailKey != "" {
+		attachment.ThumbnailURL = downloadURL(attachment.ID, variantThumbnail, expires)
+	}
+}
+
+// downloadURL builds the signed URL of a variant of an attachment
+func downloadURL(id uint, variant string, expires int64) string {
+	path := fmt.Sprintf("%s/attachments/%d", config.BaseURL, id)
+	if variant == variantThumbnail {
+		path += "/thumbnail"
+	}
+	return fmt.Sprintf("%s?expires=%d&signature=%s", path, expires, sign(id, variant, expires))
+}
+
+// sign computes the HMAC that authorizes downloading a variant of an attachment until expires
+func sign(id uint, variant string, expires int64) string {
+	if len(config.URLSecret) == 0 {
+		panic("attachment: download URLs signed before Configure")
+	}
+	mac := hmac.New(sha256.New, config.URLSecret)
+	fmt.Fprintf(mac, "%d:%s:%d", id, variant, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// uniqueIDs returns ids without duplicates, keeping their order
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	unique := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
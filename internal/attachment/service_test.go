@@ -0,0 +1,269 @@
+package attachment
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Mock do repository de anexos
+type mockAttachmentRepo struct {
+	attachments []Attachment // Stored in ascending ID order
+}
+
+func (m *mockAttachmentRepo) Create(attachment *Attachment) error {
+	attachment.ID = uint(len(m.attachments) + 1)
+	m.attachments = append(m.attachments, *attachment)
+	return nil
+}
+
+func (m *mockAttachmentRepo) FindById(id uint) (*Attachment, error) {
+	for i := range m.attachments {
+		if m.attachments[i].ID == id {
+			attachment := m.attachments[i]
+			return &attachment, nil
+		}
+	}
+	return nil, errors.New("record not found")
+}
+
+func (m *mockAttachmentRepo) FindByIds(ids []uint) ([]Attachment, error) {
+	var result []Attachment
+	for _, a := range m.attachments {
+		for _, id := range ids {
+			if a.ID == id {
+				result = append(result, a)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (m *mockAttachmentRepo) FindByMessages(messageIDs []uint) ([]Attachment, error) {
+	var result []Attachment
+	for _, a := range m.attachments {
+		for _, id := range messageIDs {
+			if a.MessageID != nil && *a.MessageID == id {
+				result = append(result, a)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (m *mockAttachmentRepo) LinkToMessage(tx *gorm.DB, ids []uint, messageID uint) (int64, error) {
+	var linked int64
+	for i := range m.attachments {
+		for _, id := range ids {
+			if m.attachments[i].ID == id && m.attachments[i].MessageID == nil {
+				m.attachments[i].MessageID = &messageID
+				linked++
+			}
+		}
+	}
+	return linked, nil
+}
+
+// setup replaces the repository and the blob store with test doubles
+func setup(t *testing.T) *LocalStore {
+	t.Helper()
+	repo = &mockAttachmentRepo{}
+	local := &LocalStore{Dir: t.TempDir()}
+	store = local
+	config.URLSecret = []byte("test-secret")
+	return local
+}
+
+func pngImage(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 200, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("could not encode test image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// signedQuery extracts the expires and signature parameters of a download URL
+func signedQuery(t *testing.T, rawURL string) (int64, string) {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("invalid download URL %q: %v", rawURL, err)
+	}
+	expires, err := strconv.ParseInt(u.Query().Get("expires"), 10, 64)
+	if err != nil {
+		t.Fatalf("download URL %q has no expiry", rawURL)
+	}
+	return expires, u.Query().Get("signature")
+}
+
+func TestUpload_ImageGetsThumbnailAndSignedURLs(t *testing.T) {
+	setup(t)
+
+	attachment, err := Upload(1, "room1", "../../photo.png", pngImage(t, 800, 400))
+	if err != nil {
+		t.Fatalf("expected nil, but got error: %v", err)
+	}
+
+	if attachment.FileName != "photo.png" {
+		t.Errorf("expected directories stripped from file name, got %q", attachment.FileName)
+	}
+	if attachment.ContentType != "image/png" || attachment.Width != 800 || attachment.Height != 400 {
+		t.Errorf("unexpected image metadata: %s %dx%d", attachment.ContentType, attachment.Width, attachment.Height)
+	}
+	if attachment.ThumbnailKey == "" || attachment.ThumbnailURL == "" {
+		t.Fatal("expected a thumbnail for an image upload")
+	}
+
+	expires, signature := signedQuery(t, attachment.ThumbnailURL)
+	_, reader, err := Open(attachment.ID, true, expires, signature)
+	if err != nil {
+		t.Fatalf("expected thumbnail to open with its signed URL, got %v", err)
+	}
+	defer reader.Close()
+
+	thumb, format, err := image.Decode(reader)
+	if err != nil || format != "jpeg" {
+		t.Fatalf("expected a JPEG thumbnail, got %q (%v)", format, err)
+	}
+	if size := thumb.Bounds().Size(); size.X != config.ThumbnailSize || size.Y != config.ThumbnailSize/2 {
+		t.Errorf("expected thumbnail scaled to %dx%d, got %v", config.ThumbnailSize, config.ThumbnailSize/2, size)
+	}
+}
+
+func TestUpload_Validation(t *testing.T) {
+	setup(t)
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty file", nil, ErrEmptyFile},
+		{"too large", bytes.Repeat([]byte("a"), int(config.MaxSize)+1), ErrTooLarge},
+		{"type not allowed", []byte("<html><body>hi</body></html>"), ErrTypeNotAllowed},
+	}
+
+	for _, tt := range tests {
+		if _, err := Upload(1, "room1", "file", tt.data); !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestOpen_RejectsTamperedOrExpiredURLs(t *testing.T) {
+	setup(t)
+
+	attachment, err := Upload(1, "room1", "notes.txt", []byte("meeting notes"))
+	if err != nil {
+		t.Fatalf("expected nil, but got error: %v", err)
+	}
+	expires, signature := signedQuery(t, attachment.URL)
+
+	if _, _, err := Open(attachment.ID+1, false, expires, signature); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected signature bound to the attachment ID, got %v", err)
+	}
+	if _, _, err := Open(attachment.ID, true, expires, signature); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected signature bound to the variant, got %v", err)
+	}
+	if _, _, err := Open(attachment.ID, false, expires+60, signature); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected signature bound to the expiry, got %v", err)
+	}
+
+	past := time.Now().Add(-time.Minute).Unix()
+	if _, _, err := Open(attachment.ID, false, past, sign(attachment.ID, variantOriginal, past)); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected expired URL to be rejected, got %v", err)
+	}
+
+	_, reader, err := Open(attachment.ID, false, expires, signature)
+	if err != nil {
+		t.Fatalf("expected valid URL to open, got %v", err)
+	}
+	defer reader.Close()
+	if data, _ := io.ReadAll(reader); string(data) != "meeting notes" {
+		t.Errorf("expected stored content, got %q", data)
+	}
+}
+
+func TestPending_OnlyUnlinkedUploadsOfSenderInRoom(t *testing.T) {
+	setup(t)
+
+	mine, _ := Upload(1, "room1", "a.txt", []byte("a"))
+	other, _ := Upload(2, "room1", "b.txt", []byte("b"))
+	elsewhere, _ := Upload(1, "room2", "c.txt", []byte("c"))
+
+	if _, err := Pending([]uint{mine.ID, other.ID}, 1, "room1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected another user's upload to be rejected, got %v", err)
+	}
+	if _, err := Pending([]uint{elsewhere.ID}, 1, "room1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected an upload to another room to be rejected, got %v", err)
+	}
+
+	attachments, err := Pending([]uint{mine.ID, mine.ID}, 1, "room1")
+	if err != nil || len(attachments) != 1 {
+		t.Fatalf("expected the sender's upload once, got %d (%v)", len(attachments), err)
+	}
+	if err := Link(nil, attachments, 10); err != nil {
+		t.Fatalf("expected nil, but got error: %v", err)
+	}
+
+	if _, err := Pending([]uint{mine.ID}, 1, "room1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected an attachment already sent to be rejected, got %v", err)
+	}
+
+	byMessage, err := ForMessages([]uint{10})
+	if err != nil || len(byMessage[10]) != 1 || byMessage[10][0].URL == "" {
+		t.Errorf("expected the linked attachment with a signed URL, got %+v (%v)", byMessage, err)
+	}
+}
+
+func TestCleanFileName(t *testing.T) {
+	tests := map[string]string{
+		"report.pdf":           "report.pdf",
+		`C:\Users\me\cat.png`:  "cat.png",
+		"../../etc/passwd":     "passwd",
+		"  \"quoted\"\n.txt  ": "quoted.txt",
+		"..":                   "file",
+		"":                     "file",
+	}
+
+	for input, want := range tests {
+		if got := cleanFileName(input); got != want {
+			t.Errorf("cleanFileName(%q) = %q, want %q", input, got, want)
+		}
+	}
+	if got := cleanFileName(strings.Repeat("a", 300)); len(got) != 255 {
+		t.Errorf("expected long names truncated to 255 bytes, got %d", len(got))
+	}
+}
+
+func TestLoadConfig_RequiresURLSecret(t *testing.T) {
+	t.Setenv("ATTACHMENT_URL_SECRET", "")
+	t.Setenv("JWT_SECRET", "")
+	if _, err := LoadConfig(); !errors.Is(err, ErrNoURLSecret) {
+		t.Fatalf("expected ErrNoURLSecret without a secret, got %v", err)
+	}
+
+	t.Setenv("JWT_SECRET", "jwt-secret")
+	t.Setenv("ATTACHMENT_MAX_SIZE", "1024")
+	loaded, err := LoadConfig()
+	if err != nil || string(loaded.URLSecret) != "jwt-secret" || loaded.MaxSize != 1024 {
+		t.Errorf("expected the JWT secret and the size limit from the environment, got %+v %v", loaded, err)
+	}
+}
@@ -0,0 +1,123 @@
+package attachment
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrBlobNotFound is returned by a BlobStore when the key does not exist
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore stores the content of uploaded files. Keys are slash separated
+// paths generated by the service, e.g. "attachments/3f2a.../original".
+type BlobStore interface {
+	Put(key string, data []byte, contentType string) error // Stores data under key, replacing any previous content
+	Get(key string) (io.ReadCloser, error)                 // Opens the content stored under key
+	Delete(key string) error                               // Removes the content stored under key, if any
+}
+
+// store is the BlobStore used by the attachment service, set by Configure
+var store BlobStore
+
+// NewBlobStoreFromEnv selects the BlobStore from STORAGE_DRIVER: "s3" uses an
+// S3-compatible bucket configured by the S3_* variables, anything else stores
+// files under STORAGE_DIR (default "uploads").
+func NewBlobStoreFromEnv() BlobStore {
+	if os.Getenv("STORAGE_DRIVER") == "s3" {
+		region := os.Getenv("S3_REGION")
+		if region == "" {
+			region = "us-east-1"
+		}
+		return &S3Store{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			Region:    region,
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+		}
+	}
+
+	dir := os.Getenv("STORAGE_DIR")
+	if dir == "" {
+		dir = "uploads"
+	}
+	return &LocalStore{Dir: dir}
+}
+
+// validKey reports whether key is a clean relative path that cannot escape the store root
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	if path.Clean(key) != key {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == ".." || part == "." {
+			return false
+		}
+	}
+	return true
+}
+
+// LocalStore keeps blobs as files under a directory of the local filesystem.
+type LocalStore struct {
+	Dir string
+}
+
+// path returns the file path of a key, rejecting keys that would escape Dir
+func (s *LocalStore) path(key string) (string, error) {
+	if !validKey(key) {
+		return "", errors.New("invalid blob key")
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes data to a file, creating parent directories as needed. The file is
+// written to a temporary name and renamed so readers never see partial content.
+func (s *LocalStore) Put(key string, data []byte, contentType string) error {
+	file, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o750); err != nil {
+		return err
+	}
+
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// Get opens the file stored under key.
+func (s *LocalStore) Get(key string) (io.ReadCloser, error) {
+	file, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	return f, err
+}
+
+// Delete removes the file stored under key. Missing files are ignored.
+func (s *LocalStore) Delete(key string) error {
+	file, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Error("could not delete blob", "key", key, "error", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package attachment
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is a local stand-in for an S3-compatible service. It keeps objects in
+// memory and rejects requests without a well-formed Signature Version 4.
+type fakeS3 struct {
+	mu      sync.Mutex
+	bucket  string
+	objects map[string][]byte
+	types   map[string]string
+}
+
+func newFakeS3(bucket string) *fakeS3 {
+	return &fakeS3{bucket: bucket, objects: make(map[string][]byte), types: make(map[string]string)}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	sum := sha256.Sum256(body)
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=test-key/") ||
+		!strings.Contains(auth, "SignedHeaders=host;x-amz-content-sha256;x-amz-date") ||
+		r.Header.Get("X-Amz-Date") == "" ||
+		r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+		http.Error(w, "<Error><Code>SignatureDoesNotMatch</Code></Error>", http.StatusForbidden)
+		return
+	}
+
+	prefix := "/" + f.bucket + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.Error(w, "<Error><Code>NoSuchBucket</Code></Error>", http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, prefix)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		f.objects[key] = body
+		f.types[key] = r.Header.Get("Content-Type")
+	case http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// testBlobStore runs the BlobStore contract against store
+func testBlobStore(t *testing.T, store BlobStore) {
+	t.Helper()
+	key := "attachments/abc/original"
+
+	if err := store.Put(key, []byte("hello"), "text/plain"); err != nil {
+		t.Fatalf("Put: expected nil, but got error: %v", err)
+	}
+
+	reader, err := store.Get(key)
+	if err != nil {
+		t.Fatalf("Get: expected nil, but got error: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "hello" {
+		t.Errorf("Get: expected stored content, got %q", data)
+	}
+
+	if err := store.Delete(key); err != nil {
+		t.Fatalf("Delete: expected nil, but got error: %v", err)
+	}
+	if _, err := store.Get(key); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("Get after Delete: expected ErrBlobNotFound, got %v", err)
+	}
+	if err := store.Delete(key); err != nil {
+		t.Errorf("Delete of a missing key: expected nil, got %v", err)
+	}
+
+	if err := store.Put("../outside", []byte("x"), "text/plain"); err == nil {
+		t.Error("expected keys escaping the store to be rejected")
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	testBlobStore(t, &LocalStore{Dir: t.TempDir()})
+}
+
+func TestS3Store(t *testing.T) {
+	fake := newFakeS3("chat")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	testBlobStore(t, &S3Store{
+		Endpoint:  server.URL,
+		Bucket:    "chat",
+		Region:    "us-east-1",
+		AccessKey: "test-key",
+		SecretKey: "test-secret",
+		Client:    server.Client(),
+	})
+}
+
+func TestS3Store_ReportsServiceErrors(t *testing.T) {
+	server := httptest.NewServer(newFakeS3("chat"))
+	defer server.Close()
+
+	s3 := &S3Store{Endpoint: server.URL, Bucket: "chat", Region: "us-east-1", AccessKey: "wrong-key", SecretKey: "x"}
+	err := s3.Put("attachments/abc/original", []byte("hello"), "text/plain")
+
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expected the rejected request to surface its status, got %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package attachment
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif" // Register the GIF decoder
+	"image/jpeg"
+	_ "image/png" // Register the PNG decoder
+)
+
+// maxImagePixels caps the decoded size of an image, protecting the server from
+// small files that decompress into huge bitmaps
+const maxImagePixels = 40_000_000
+
+// errImageTooLarge is returned when an image has more pixels than maxImagePixels
+var errImageTooLarge = errors.New("image too large to generate a thumbnail")
+
+// imageSize returns the dimensions of an image without decoding its pixels
+func imageSize(data []byte) (int, int, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, err
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// makeThumbnail decodes an image and returns a JPEG scaled down so its longest
+// side is at most maxSide. Smaller images are re-encoded at their own size.
+func makeThumbnail(data []byte, maxSide int) ([]byte, error) {
+	width, height, err := imageSize(data)
+	if err != nil {
+		return nil, err
+	}
+	if width*height > maxImagePixels {
+		return nil, errImageTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	thumbWidth, thumbHeight := fitWithin(width, height, maxSide)
+	thumb := scaleDown(src, thumbWidth, thumbHeight)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// fitWithin returns the size of a width x height box scaled to fit maxSide, keeping its aspect ratio
+func fitWithin(width, height, maxSide int) (int, int) {
+	if width <= maxSide && height <= maxSide {
+		return width, height
+	}
+	if width >= height {
+		return maxSide, max(1, height*maxSide/width)
+	}
+	return max(1, width*maxSide/height), maxSide
+}
+
+// scaleDown resizes src to width x height by averaging the source pixels covered
+// by each destination pixel (box filter), which keeps downscaled images smooth.
+// Transparent areas are composed over white since JPEG has no alpha channel.
+func scaleDown(src image.Image, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*srcHeight/height
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*srcHeight/height)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*srcWidth/width
+			x1 := max(x0+1, bounds.Min.X+(x+1)*srcWidth/width)
+
+			var r, g, b, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					// Compose premultiplied color over white
+					r += uint64(cr + 0xffff - ca)
+					g += uint64(cg + 0xffff - ca)
+					b += uint64(cb + 0xffff - ca)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: 0xff,
+			})
+		}
+	}
+	return dst
+}
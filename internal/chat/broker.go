@@ -0,0 +1,160 @@
+package chat
+
+import (
+	"sync"
+)
+
+// Broker is the pub/sub backplane the Hub publishes room and user traffic to.
+// Every wsserver instance subscribes to the topics of its local clients, so a
+// message published by one instance reaches the clients connected to all of them.
+type Broker interface {
+	Publish(topic string, data []byte) error // Sends data to every subscriber of the topic
+	Subscribe(topic string) error            // Starts receiving messages published to the topic
+	Unsubscribe(topic string) error          // Stops receiving messages published to the topic
+	Messages() <-chan BrokerMessage          // Messages published to subscribed topics, in publish order
+	Close() error                            // Releases the broker resources
+}
+
+// BrokerMessage is a message received from the broker.
+type BrokerMessage struct {
+	Topic       string // Topic the message was published to
+	Data        []byte // Raw published data
+	Interrupted bool   // Delivery was interrupted and messages may have been lost; Topic and Data are empty
+}
+
+// eventBroker publishes room events raised outside a Hub, such as edits made
+// through the REST API; nil disables live propagation of those events.
+var eventBroker Broker
+
+// SetEventBroker makes REST handlers publish message events through b, so every
+// wsserver instance sharing the broker delivers them to its open clients.
+func SetEventBroker(b Broker) {
+	eventBroker = b
+}
+
+// publishEvent publishes a message through the event broker, if one is set
+func publishEvent(msg Message) {
+	if eventBroker != nil {
+		publishMessage(eventBroker, msg, EchoOthers)
+	}
+}
+
+// MemoryBroker is an in-process Broker for a single wsserver instance. It cannot
+// carry events published by the REST server, which runs in another process.
+type MemoryBroker struct {
+	mu     sync.Mutex
+	topics map[string]bool // Subscribed topics
+	queue  *messageQueue   // Pending deliveries
+}
+
+// NewMemoryBroker creates a Broker that delivers messages within the current process.
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		topics: make(map[string]bool),
+		queue:  newMessageQueue(),
+	}
+}
+
+// Publish queues the message for delivery when the topic has subscribers.
+func (b *MemoryBroker) Publish(topic string, data []byte) error {
+	b.mu.Lock()
+	subscribed := b.topics[topic]
+	b.mu.Unlock()
+
+	if subscribed {
+		b.queue.push(BrokerMessage{Topic: topic, Data: data})
+	}
+	return nil
+}
+
+// Subscribe marks the topic as subscribed.
+func (b *MemoryBroker) Subscribe(topic string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.topics[topic] = true
+	return nil
+}
+
+// Unsubscribe removes the topic subscription.
+func (b *MemoryBroker) Unsubscribe(topic string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.topics, topic)
+	return nil
+}
+
+// Messages returns the channel of delivered messages.
+func (b *MemoryBroker) Messages() <-chan BrokerMessage {
+	return b.queue.out
+}
+
+// Close stops delivering messages.
+func (b *MemoryBroker) Close() error {
+	b.queue.close()
+	return nil
+}
+
+// messageQueue is an unbounded FIFO feeding a channel. Publishing never blocks,
+// so the Hub can publish from its own loop while it is the one consuming out.
+type messageQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []BrokerMessage
+	closed  bool
+	done    chan struct{}
+	out     chan BrokerMessage
+}
+
+// newMessageQueue creates a queue and starts its forwarding goroutine.
+func newMessageQueue() *messageQueue {
+	q := &messageQueue{done: make(chan struct{}), out: make(chan BrokerMessage)}
+	q.cond = sync.NewCond(&q.mu)
+	go q.forward()
+	return q
+}
+
+// push appends a message to the queue.
+func (q *messageQueue) push(msg BrokerMessage) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.pending = append(q.pending, msg)
+	q.cond.Signal()
+}
+
+// close stops the forwarding goroutine and closes out, dropping pending messages.
+func (q *messageQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.done)
+		q.cond.Signal()
+	}
+}
+
+// forward moves queued messages to out in order.
+func (q *messageQueue) forward() {
+	defer close(q.out)
+	for {
+		q.mu.Lock()
+		for len(q.pending) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if q.closed {
+			q.mu.Unlock()
+			return
+		}
+		msg := q.pending[0]
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+
+		select {
+		case q.out <- msg:
+		case <-q.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package chat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryBroker_DeliversSubscribedTopicsInOrder(t *testing.T) {
+	broker := NewMemoryBroker()
+	defer broker.Close()
+
+	broker.Subscribe("room:1")
+	broker.Publish("room:2", []byte("ignored"))
+	for _, data := range []string{"first", "second", "third"} {
+		broker.Publish("room:1", []byte(data))
+	}
+
+	for _, expected := range []string{"first", "second", "third"} {
+		select {
+		case msg := <-broker.Messages():
+			if msg.Topic != "room:1" || string(msg.Data) != expected {
+				t.Fatalf("expected %q on room:1, got %q on %s", expected, msg.Data, msg.Topic)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %q", expected)
+		}
+	}
+
+	broker.Unsubscribe("room:1")
+	broker.Publish("room:1", []byte("late"))
+	select {
+	case msg := <-broker.Messages():
+		t.Fatalf("expected no delivery after unsubscribe, got %q", msg.Data)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -1,17 +1,142 @@
 package chat
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
 	"github.com/gorilla/websocket"
 )
 
 // Client represents an active WebSocket connection from a user in the chat.
 // Contains user information and channels for asynchronous communication.
 type Client struct {
-	ID        string          // Unique connection identifier
+	ID        string          // Unique connection identifier, shared with other instances through the broker
 	Conn      *websocket.Conn // Active WebSocket connection
 	RoomID    string          // ID of the room the client is connected to
 	Send      chan []byte     // Channel for sending messages
 	UserID    uint            // Authenticated user ID
 	UserName  string          // User's name
 	UserEmail string          // User's email
+	TokenID   string          // jti of the access token used to connect
+	SessionID string          // Login session (sid claim) of the token; a device keeps it across token refreshes
+	Device    string          // Device name sent by the client, or its User-Agent
+	IP        string          // Address the connection came from
+	Connected time.Time       // When the connection was opened
+
+	hub         *Hub         // Hub the client is registered with
+	mu          sync.Mutex   // Guards Send against sends after close
+	closed      bool         // Whether Send has been closed
+	closeCode   int          // Close code sent to the client when the connection ends
+	closeReason string       // Close reason sent to the client when the connection ends
+	typing      typingState  // Typing indicator state
+	rate        rateState    // Message rate limit state, only used by readPump
+	replay      replayState  // Live frames held while missed messages are replayed
+	resumeSince uint         // Message ID the client resumes from when connecting, zero for none
+	tokenExpiry time.Time    // Expiration of the access token, recorded when it is revoked on sign-out
+	log         *slog.Logger // Logger carrying the connection ID and room
+}
+
+// connectionLogger returns the logger of a connection: every line it writes
+// carries the connection ID, which correlates the lines of readPump and writePump.
+func connectionLogger(base *slog.Logger, id, roomID string) *slog.Logger {
+	return base.With("conn_id", id, "room", roomID)
+}
+
+// logger returns the logger of the connection, or the default logger for
+// clients not created by NewClient
+func (c *Client) logger() *slog.Logger {
+	if c.log == nil {
+		return slog.Default()
+	}
+	return c.log
+}
+
+// trySend queues a frame without blocking. It returns false when the Send
+// queue is full; frames for an already closed client are silently discarded.
+func (c *Client) trySend(data []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return true
+	}
+	if c.replay.active {
+		return c.holdFrame(data)
+	}
+	select {
+	case c.Send <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+// setCloseReason records why the connection is ending. The first reason wins,
+// so the original cause is reported even if several follow.
+func (c *Client) setCloseReason(code int, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closeCode == 0 {
+		c.closeCode = code
+		c.closeReason = reason
+	}
+}
+
+// closeMessage returns the close frame payload to send to the client.
+func (c *Client) closeMessage() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closeCode == 0 {
+		return websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	}
+	return websocket.FormatCloseMessage(c.closeCode, c.closeReason)
+}
+
+// close closes the Send queue, which makes writePump flush what is queued and
+// close the connection. Safe to call more than once.
+func (c *Client) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.Send)
+	}
+}
+
+// sendEnvelope builds an envelope addressed to this client only and queues it for writing.
+func (c *Client) sendEnvelope(msgType, id string, payload interface{}) {
+	env, err := NewEnvelope(msgType, id, c.RoomID, payload)
+	if err != nil {
+		c.logger().Error("could not build envelope", "type", msgType, "error", err)
+		return
+	}
+	msgBytes, err := json.Marshal(env)
+	if err != nil {
+		c.logger().Error("could not encode envelope", "type", msgType, "error", err)
+		return
+	}
+	if !c.trySend(msgBytes) && c.hub != nil {
+		c.hub.slowConsumer(c)
+	}
+}
+
+// sendError notifies the client that the frame with the given envelope ID was rejected.
+func (c *Client) sendError(id string, err error) {
+	payload := ErrorPayload{Code: ErrCodeInternal, Message: err.Error()}
+	var protoErr *ProtocolError
+	if errors.As(err, &protoErr) {
+		payload = ErrorPayload{Code: protoErr.Code, Message: protoErr.Message}
+	}
+	c.sendEnvelope(TypeError, id, payload)
+}
+
+// newConnectionID generates a random identifier for a WebSocket connection.
+func newConnectionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
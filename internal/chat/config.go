@@ -0,0 +1,176 @@
+package chat
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-chat-live/internal/proxy"
+)
+
+// Slow consumer policies applied when a client's Send queue is full
+const (
+	PolicyDisconnect = "disconnect" // Close the connection of the slow client
+	PolicyDrop       = "drop"       // Drop the frame and keep the client connected
+)
+
+// Echo modes decide which connections of a user receive the events the user caused
+const (
+	EchoNone   = "none"   // No connection of the user receives the event
+	EchoOthers = "others" // The user's other connections receive it, the originating connection does not
+	EchoAll    = "all"    // Every connection receives it, including the originating one
+)
+
+// defaultEcho lists the envelope types not echoed with EchoOthers by default:
+// a user's own typing indicator is noise on their other devices.
+var defaultEcho = map[string]string{
+	TypeTypingStart: EchoNone,
+	TypeTypingStop:  EchoNone,
+}
+
+// HubConfig holds the tunable limits of the Hub and its clients.
+type HubConfig struct {
+	SendBufferSize     int               // Capacity of each client's Send queue
+	SlowConsumerPolicy string            // What to do when a client's Send queue is full
+	PingInterval       time.Duration     // How often the server pings each client
+	PongWait           time.Duration     // How long to wait for any frame (including pongs) before dropping the client
+	WriteTimeout       time.Duration     // Deadline for writing a single frame
+	MaxMessageSize     int64             // Largest frame accepted from a client, in bytes
+	RevocationInterval time.Duration     // How often live sessions are checked for revoked tokens; zero disables it
+	TypingThrottle     time.Duration     // Minimum time between typing_start events relayed for a client
+	TypingTimeout      time.Duration     // Typing stops automatically after this long without typing_start; zero disables it
+	MessageRate        int               // Messages per second allowed on each connection; zero disables the limit
+	MessageBurst       int               // Messages a connection may send at once before MessageRate applies
+	UserMessageRate    int               // Messages per second allowed for each user across connections; zero disables the limit
+	UserMessageBurst   int               // Messages a user may send at once before UserMessageRate applies
+	MaxRateViolations  int               // Consecutive rate limited frames before the connection is closed; zero never closes
+	ReplayLimit        int               // Most missed messages replayed on reconnect; larger gaps get resync_required
+	Echo               map[string]string // Echo mode per envelope type; types not listed use EchoOthers
+	TrustedProxies     *proxy.Trusted    // Proxies whose X-Forwarded-For sets the client IP; nil trusts none
+}
+
+// echoMode returns which connections of the sender receive an envelope of the given type
+func (c HubConfig) echoMode(msgType string) string {
+	if mode, ok := c.Echo[msgType]; ok {
+		return mode
+	}
+	return EchoOthers
+}
+
+// LoadHubConfig reads the Hub configuration from environment variables with defaults.
+func LoadHubConfig() HubConfig {
+	config := HubConfig{
+		SendBufferSize:     envInt("WS_SEND_BUFFER", 256),
+		SlowConsumerPolicy: os.Getenv("WS_SLOW_CONSUMER_POLICY"),
+		PongWait:           envDuration("WS_PONG_WAIT", 60*time.Second),
+		WriteTimeout:       envDuration("WS_WRITE_TIMEOUT", 10*time.Second),
+		MaxMessageSize:     int64(envInt("WS_MAX_MESSAGE_SIZE", 4096)),
+		RevocationInterval: envOptionalDuration("WS_REVOCATION_CHECK_INTERVAL", 30*time.Second),
+		TypingThrottle:     envDuration("WS_TYPING_THROTTLE", 2*time.Second),
+		TypingTimeout:      envOptionalDuration("WS_TYPING_TIMEOUT", 6*time.Second),
+		MessageRate:        envOptionalInt("WS_MESSAGE_RATE", 5),
+		MessageBurst:       envInt("WS_MESSAGE_BURST", 10),
+		UserMessageRate:    envOptionalInt("WS_USER_MESSAGE_RATE", 10),
+		UserMessageBurst:   envInt("WS_USER_MESSAGE_BURST", 20),
+		MaxRateViolations:  envOptionalInt("WS_MAX_RATE_VIOLATIONS", 10),
+		ReplayLimit:        envInt("WS_REPLAY_LIMIT", 100),
+		Echo:               envEcho("WS_ECHO", defaultEcho),
+	}
+
+	// Pings must be sent more often than the pong wait or healthy clients time out
+	config.PingInterval = envDuration("WS_PING_INTERVAL", config.PongWait*9/10)
+	if config.PingInterval >= config.PongWait {
+		slog.Warn("WS_PING_INTERVAL must be shorter than WS_PONG_WAIT", "using", (config.PongWait * 9 / 10).String())
+		config.PingInterval = config.PongWait * 9 / 10
+	}
+
+	// Replayed frames are queued at once, so the replay must fit in the Send queue
+	if config.ReplayLimit >= config.SendBufferSize {
+		slog.Warn("WS_REPLAY_LIMIT must be smaller than WS_SEND_BUFFER", "using", config.SendBufferSize/2)
+		config.ReplayLimit = config.SendBufferSize / 2
+	}
+
+	if config.SlowConsumerPolicy == "" {
+		config.SlowConsumerPolicy = PolicyDisconnect
+	}
+	if config.SlowConsumerPolicy != PolicyDisconnect && config.SlowConsumerPolicy != PolicyDrop {
+		slog.Warn("unknown WS_SLOW_CONSUMER_POLICY", "value", config.SlowConsumerPolicy, "using", PolicyDisconnect)
+		config.SlowConsumerPolicy = PolicyDisconnect
+	}
+
+	return config
+}
+
+// envInt reads a positive integer environment variable, falling back to def
+func envInt(key string, def int) int {
+	return parseEnvInt(key, def, 1)
+}
+
+// envOptionalInt reads a non-negative integer environment variable for settings
+// that zero disables, falling back to def
+func envOptionalInt(key string, def int) int {
+	return parseEnvInt(key, def, 0)
+}
+
+// parseEnvInt reads an integer environment variable of at least min, falling back to def
+func parseEnvInt(key string, def, min int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min {
+		slog.Warn("invalid environment variable, using default", "key", key, "value", value, "default", def)
+		return def
+	}
+	return n
+}
+
+// envEcho reads per type echo modes from an environment variable formatted as
+// "type=mode,type=mode" (e.g. "message=all,typing_start=others"), applied over def
+func envEcho(key string, def map[string]string) map[string]string {
+	echo := make(map[string]string, len(def))
+	for msgType, mode := range def {
+		echo[msgType] = mode
+	}
+	value := os.Getenv(key)
+	if value == "" {
+		return echo
+	}
+	for _, entry := range strings.Split(value, ",") {
+		msgType, mode, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || msgType == "" || (mode != EchoNone && mode != EchoOthers && mode != EchoAll) {
+			slog.Warn("invalid echo entry, expected type=none|others|all", "key", key, "entry", entry)
+			continue
+		}
+		echo[msgType] = mode
+	}
+	return echo
+}
+
+// envDuration reads a positive duration environment variable (e.g. "30s"), falling back to def
+func envDuration(key string, def time.Duration) time.Duration {
+	return parseEnvDuration(key, def, false)
+}
+
+// envOptionalDuration reads a duration environment variable for settings that
+// zero ("0" or "0s") disables, falling back to def
+func envOptionalDuration(key string, def time.Duration) time.Duration {
+	return parseEnvDuration(key, def, true)
+}
+
+// parseEnvDuration reads a duration environment variable, accepting zero when allowZero is set
+func parseEnvDuration(key string, def time.Duration, allowZero bool) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 || (d == 0 && !allowZero) {
+		slog.Warn("invalid environment variable, using default", "key", key, "value", value, "default", def.String())
+		return def
+	}
+	return d
+}
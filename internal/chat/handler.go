@@ -0,0 +1,386 @@
+package chat
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-chat-live/internal/room"
+	"go-chat-live/internal/user"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListRoomMessages handles GET requests to page through the message history of a room.
+// Supports cursor pagination through the "before" (message ID) and "limit" query parameters.
+func ListRoomMessages(c *gin.Context) {
+	roomID, _, ok := authorizeRoom(c)
+	if !ok {
+		return
+	}
+
+	before, limit, ok := parsePagination(c)
+	if !ok {
+		return
+	}
+
+	page, err := History(roomID, before, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// EditMessageRequest represents the payload for editing a room message
+type EditMessageRequest struct {
+	Content string `json:"content"` // New message content
+}
+
+// EditRoomMessage handles PUT requests to edit a room message. Only the author and
+// room moderators may edit it; open clients receive a message_updated event.
+func EditRoomMessage(c *gin.Context) {
+	roomID, userID, ok := authorizeRoom(c)
+	if !ok {
+		return
+	}
+
+	messageID, ok := parseMessageID(c)
+	if !ok {
+		return
+	}
+
+	var req EditMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	msg, err := EditMessage(roomID, messageID, userID, req.Content)
+	if err != nil {
+		respondMessageError(c, err)
+		return
+	}
+
+	publishEvent(Message{Type: TypeMessageUpdated, RoomID: roomID, Payload: NewChatMessage(msg)})
+	c.JSON(http.StatusOK, msg)
+}
+
+// DeleteRoomMessage handles DELETE requests to remove a room message. Only the author and
+// room moderators may delete it; open clients receive a message_deleted event.
+func DeleteRoomMessage(c *gin.Context) {
+	roomID, userID, ok := authorizeRoom(c)
+	if !ok {
+		return
+	}
+
+	messageID, ok := parseMessageID(c)
+	if !ok {
+		return
+	}
+
+	if err := DeleteMessage(roomID, messageID, userID); err != nil {
+		respondMessageError(c, err)
+		return
+	}
+
+	publishEvent(Message{
+		Type:    TypeMessageDeleted,
+		RoomID:  roomID,
+		Payload: MessageDeletedPayload{MessageID: messageID, DeletedBy: userID},
+	})
+	c.Status(http.StatusNoContent)
+}
+
+// ListMessageEdits handles GET requests to list the previous versions of a room message.
+func ListMessageEdits(c *gin.Context) {
+	roomID, _, ok := authorizeRoom(c)
+	if !ok {
+		return
+	}
+
+	messageID, ok := parseMessageID(c)
+	if !ok {
+		return
+	}
+
+	edits, err := MessageEdits(roomID, messageID)
+	if err != nil {
+		respondMessageError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, edits)
+}
+
+// GetThread handles GET requests to page through the replies of a message's thread.
+// Supports the same "before" and "limit" pagination as the room history.
+func GetThread(c *gin.Context) {
+	userID, ok := user.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthenticated"})
+		return
+	}
+
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+
+	// Messages are only visible to members of their room
+	roomID, err := MessageRoom(uint(messageID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	roomNumber, _ := strconv.Atoi(roomID)
+	if _, err := room.FindForMember(roomNumber, userID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": ErrMessageNotFound.Error()})
+		return
+	}
+
+	before, limit, ok := parsePagination(c)
+	if !ok {
+		return
+	}
+
+	page, err := Thread(uint(messageID), before, limit)
+	if err != nil {
+		respondMessageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// ListConversations handles GET requests to list the direct conversations of the authenticated user.
+func ListConversations(c *gin.Context) {
+	userID, ok := user.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthenticated"})
+		return
+	}
+
+	conversations, err := Conversations(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, conversations)
+}
+
+// ListUnread handles GET requests for the unread message counts of every room
+// the authenticated user belongs to.
+func ListUnread(c *gin.Context) {
+	userID, ok := user.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthenticated"})
+		return
+	}
+
+	unread, err := Unread(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, unread)
+}
+
+// SearchRoomMessages handles GET requests for a full-text search over the messages
+// of the rooms the authenticated user belongs to. Supports the "room", "from"
+// (sender user ID), "before" and "after" (RFC 3339 time or YYYY-MM-DD date) filters
+// and cursor pagination through "cursor" (message ID) and "limit".
+func SearchRoomMessages(c *gin.Context) {
+	userID, ok := user.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthenticated"})
+		return
+	}
+
+	filter := SearchFilter{Query: c.Query("q")}
+	if room := c.Query("room"); room != "" {
+		roomID, err := strconv.ParseUint(room, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid room"})
+			return
+		}
+		// Canonical form, so "007" matches the room stored as "7"
+		filter.RoomIDs = []string{strconv.FormatUint(roomID, 10)}
+	}
+	if from := c.Query("from"); from != "" {
+		senderID, err := strconv.ParseUint(from, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from user ID"})
+			return
+		}
+		filter.SenderID = uint(senderID)
+	}
+
+	var err error
+	if filter.Before, err = parseTimeQuery(c, "before"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid before time"})
+		return
+	}
+	if filter.After, err = parseTimeQuery(c, "after"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid after time"})
+		return
+	}
+	if cursor := c.Query("cursor"); cursor != "" {
+		beforeID, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		filter.BeforeID = uint(beforeID)
+	}
+
+	limit := 0
+	if limitStr := c.Query("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+	}
+
+	page, err := SearchMessages(userID, filter, limit)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrQueryRequired):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, room.ErrNotMember):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// parseTimeQuery reads a query parameter holding an RFC 3339 time or a YYYY-MM-DD
+// date (midnight UTC). A missing parameter returns nil.
+func parseTimeQuery(c *gin.Context, name string) (*time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t, err = time.Parse(time.DateOnly, value)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ListConversationMessages handles GET requests to page through the direct messages
+// exchanged between the authenticated user and the user in the :userId path parameter.
+func ListConversationMessages(c *gin.Context) {
+	userID, ok := user.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthenticated"})
+		return
+	}
+
+	otherID, err := strconv.ParseUint(c.Param("userId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	before, limit, ok := parsePagination(c)
+	if !ok {
+		return
+	}
+
+	page, err := DirectHistory(userID, uint(otherID), before, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// parseMessageID reads the :messageId path parameter, writing the error response when it is invalid.
+func parseMessageID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("messageId"), 10, 64)
+	if err != nil || id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// respondMessageError maps message edit and delete errors to HTTP status codes
+func respondMessageError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrMessageNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrNotAllowed):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrContentRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// parsePagination reads the "before" cursor and "limit" query parameters,
+// writing the error response when they are invalid.
+func parsePagination(c *gin.Context) (uint, int, bool) {
+	var before uint64
+	if beforeStr := c.Query("before"); beforeStr != "" {
+		var err error
+		before, err = strconv.ParseUint(beforeStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid before cursor"})
+			return 0, 0, false
+		}
+	}
+
+	limit := 0
+	if limitStr := c.Query("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return 0, 0, false
+		}
+	}
+
+	return uint(before), limit, true
+}
+
+// authorizeRoom resolves the room from the :id path parameter and checks that the
+// authenticated user is a member of it, writing the error response otherwise.
+// The returned room ID is canonical ("07" and "+7" become "7").
+func authorizeRoom(c *gin.Context) (string, uint, bool) {
+	userID, ok := user.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthenticated"})
+		return "", 0, false
+	}
+
+	roomNumber, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return "", 0, false
+	}
+
+	if _, err := room.FindForMember(roomNumber, userID); err != nil {
+		if errors.Is(err, room.ErrNotMember) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		}
+		return "", 0, false
+	}
+
+	return strconv.Itoa(roomNumber), userID, true
+}
@@ -0,0 +1,40 @@
+package chat
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-chat-live/internal/room"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSearchRoomMessages_CanonicalRoomFilter(t *testing.T) {
+	messages := &mockMessageRepo{}
+	messageRepo = messages
+	reactionRepo = &mockReactionRepo{}
+	searchRepo = &mockSearchRepo{messages: messages}
+	joinedRooms = func(userID uint) ([]room.Room, error) {
+		return []room.Room{{ID: 7}}, nil
+	}
+	defer func() { joinedRooms = room.Joined }()
+	SaveMessage(&StoredMessage{RoomID: "7", UserID: 1, Content: "deploy"})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/search", func(c *gin.Context) {
+		c.Set("user_id", float64(5))
+		SearchRoomMessages(c)
+	})
+
+	// "007" é a mesma sala 7 e não pode ser tratada como sala alheia
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/search?q=deploy&room=007", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if filters := searchRepo.(*mockSearchRepo).filters; len(filters) != 1 || filters[0].RoomIDs[0] != "7" {
+		t.Errorf("expected the search filtered by room 7, got %+v", filters)
+	}
+}
@@ -1,76 +1,618 @@
 package chat
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-chat-live/internal/ratelimit"
+	"go-chat-live/internal/user"
+
+	"github.com/gorilla/websocket"
 )
 
+// CloseTokenRevoked is the close code sent to sessions whose access token was revoked.
+const CloseTokenRevoked = 4001
+
+// invalidTokens reports which session tokens can no longer be used; replaced in tests
+var invalidTokens = user.InvalidTokens
+
 // Hub manages all active WebSocket connections and distributes messages between clients.
 // Uses channels for asynchronous communication and mutex for concurrency safety.
+// Messages are published to a Broker and fanned out to local clients when the broker
+// delivers them back, so several Hubs sharing a broker behave as one.
 type Hub struct {
 	clients    map[string][]*Client // Mapping of rooms to connected clients
+	users      map[uint][]*Client   // Mapping of user IDs to all of their connections
 	register   chan *Client         // Channel to register new clients
 	unregister chan *Client         // Channel to unregister clients
 	broadcast  chan Message         // Channel for message broadcasting
+	broker     Broker               // Pub/sub backplane shared between instances
+	outbox     *outbox              // Publishes to the broker off the Run loop
+	config     HubConfig            // Queue sizes and slow consumer policy
+	userLimit  *ratelimit.Limiter   // Per-user message rate limit, nil when disabled
 	mu         sync.Mutex           // Mutex for concurrency protection
+
+	subMu     sync.Mutex     // Serializes broker subscriptions; never held with mu
+	topicRefs map[string]int // Local clients using each subscribed topic
+
+	closing bool          // Set by Shutdown; new clients are refused
+	drained chan struct{} // Closed when the last client unregisters during shutdown
+	quit    chan struct{} // Closed to stop the Run loop
+	stopped chan struct{} // Closed when the Run loop returns
+
+	droppedFrames       atomic.Uint64 // Frames not queued because a client's Send queue was full
+	slowConsumersClosed atomic.Uint64 // Clients disconnected for not keeping up
 }
 
-// Message represents an internal system message to be distributed.
+// HubStats is a snapshot of the Hub delivery counters.
+type HubStats struct {
+	DroppedFrames       uint64 `json:"droppedFrames"`       // Frames not queued because a client's Send queue was full
+	SlowConsumersClosed uint64 `json:"slowConsumersClosed"` // Clients disconnected for not keeping up
+}
+
+// Message represents an internal system message to be distributed as a typed envelope.
 type Message struct {
-	RoomID   string  // Target room ID
-	Content  string  // Message content
-	UserName string  // Sender user name
-	Sender   *Client // Client who sent the message
+	Type    string      // Envelope type sent to clients
+	RoomID  string      // Target room ID, ignored when ToUsers is set
+	ToUsers []uint      // Target users; every connection of each user receives the message
+	Payload interface{} // Envelope payload
+	Sender  *Client     // Client who originated the message; which of its user's connections get it depends on the echo mode
+}
+
+// brokerPayload is what the Hub publishes to the broker: a ready-to-send
+// envelope plus the sender and echo mode that decide which of the sender's
+// connections must not receive it.
+type brokerPayload struct {
+	SenderID     string          `json:"senderId,omitempty"`     // Connection ID of the originating client
+	SenderUserID uint            `json:"senderUserId,omitempty"` // User of the originating client
+	Echo         string          `json:"echo,omitempty"`         // Echo mode; empty behaves as EchoOthers
+	Envelope     json.RawMessage `json:"envelope"`               // Encoded envelope delivered to clients
 }
 
-// ChatMessage represents the message structure sent to the client via WebSocket.
-type ChatMessage struct {
-	Content  string `json:"content"`  // Message content
-	UserName string `json:"userName"` // User's name
+// skips reports whether the client must not receive the payload under its echo mode
+func (p brokerPayload) skips(c *Client) bool {
+	switch p.Echo {
+	case EchoAll:
+		return false
+	case EchoNone:
+		return p.SenderID != "" && c.UserID == p.SenderUserID
+	default:
+		return c.ID == p.SenderID
+	}
 }
 
-// NewHub creates and initializes a new Hub instance.
+// NewHub creates and initializes a new Hub instance backed by an in-memory broker.
 func NewHub() *Hub {
+	return NewHubWithBroker(NewMemoryBroker())
+}
+
+// NewHubWithBroker creates and initializes a new Hub instance using the given broker
+// and the configuration read from the environment.
+func NewHubWithBroker(broker Broker) *Hub {
+	return NewHubWithConfig(broker, LoadHubConfig())
+}
+
+// NewHubWithConfig creates and initializes a new Hub instance using the given broker and configuration.
+func NewHubWithConfig(broker Broker, config HubConfig) *Hub {
+	var userLimit *ratelimit.Limiter
+	if config.UserMessageRate > 0 {
+		userLimit = ratelimit.NewLimiter(float64(config.UserMessageRate), max(1, config.UserMessageBurst))
+	}
 	return &Hub{
 		clients:    make(map[string][]*Client),
+		users:      make(map[uint][]*Client),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
 		broadcast:  make(chan Message),
+		broker:     broker,
+		outbox:     newOutbox(broker),
+		config:     config,
+		userLimit:  userLimit,
+		topicRefs:  make(map[string]int),
+		drained:    make(chan struct{}),
+		quit:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+}
+
+// NewClient creates a client for a connection with a Send queue sized by the Hub configuration.
+func (h *Hub) NewClient(conn *websocket.Conn, roomID string) *Client {
+	id := newConnectionID()
+	return &Client{
+		ID:     id,
+		Conn:   conn,
+		RoomID: roomID,
+		Send:   make(chan []byte, h.config.SendBufferSize),
+		hub:    h,
+		rate:   newRateState(h.config),
+		log:    connectionLogger(slog.Default(), id, roomID),
+	}
+}
+
+// Stats returns a snapshot of the Hub delivery counters.
+func (h *Hub) Stats() HubStats {
+	return HubStats{
+		DroppedFrames:       h.droppedFrames.Load(),
+		SlowConsumersClosed: h.slowConsumersClosed.Load(),
 	}
 }
 
 // Run executes the main Hub loop to process events asynchronously.
-// Manages client registration/unregistration and message distribution.
+// Manages client registration/unregistration, publishing to the broker and
+// distribution of broker messages to local clients.
 func (h *Hub) Run() {
+	defer close(h.stopped)
+	if h.config.RevocationInterval > 0 {
+		go h.watchRevocations()
+	}
 	for {
 		select {
+		case <-h.quit:
+			return
 		case client := <-h.register:
-			h.mu.Lock()
-			h.clients[client.RoomID] = append(h.clients[client.RoomID], client)
-			h.mu.Unlock()
+			h.handleRegister(client)
 		case client := <-h.unregister:
-			h.mu.Lock()
-			clients := h.clients[client.RoomID]
-			for i, c := range clients {
-				if c == client {
-					h.clients[client.RoomID] = append(clients[:i], clients[i+1:]...)
-					break
-				}
-			}
-			h.mu.Unlock()
+			h.handleUnregister(client)
 		case msg := <-h.broadcast:
-			h.mu.Lock()
-			for _, c := range h.clients[msg.RoomID] {
-				if c != msg.Sender {
-					chatMsg := ChatMessage{
-						Content:  msg.Content,
-						UserName: msg.UserName,
-					}
-					msgBytes, _ := json.Marshal(chatMsg)
-					c.Send <- msgBytes
-				}
+			h.publish(msg)
+		case delivery, ok := <-h.broker.Messages():
+			if !ok {
+				return
+			}
+			h.dispatch(delivery)
+		}
+	}
+}
+
+// registerClient subscribes the broker to the client's topics and hands the
+// client to the Run loop. The subscription runs on the caller's goroutine, so a
+// slow broker delays only the connecting client. When it fails the client is
+// not registered and must be closed.
+func (h *Hub) registerClient(client *Client) error {
+	if err := h.acquireTopics(client); err != nil {
+		return err
+	}
+	h.register <- client
+	return nil
+}
+
+// handleRegister adds the client, whose topics are already subscribed, sends it
+// the room roster and announces the user when it was not online yet.
+func (h *Hub) handleRegister(client *Client) {
+	h.mu.Lock()
+	if h.closing {
+		h.mu.Unlock()
+		client.setCloseReason(websocket.CloseGoingAway, "server shutting down")
+		client.close()
+		go h.releaseTopics(client)
+		return
+	}
+	firstConnection := h.userConnections(client.RoomID, client.UserID) == 0
+	h.clients[client.RoomID] = append(h.clients[client.RoomID], client)
+	h.users[client.UserID] = append(h.users[client.UserID], client)
+	roster := h.roster(client.RoomID)
+	h.mu.Unlock()
+
+	client.sendEnvelope(TypePresence, "", PresencePayload{Users: roster})
+	if firstConnection {
+		h.publish(Message{
+			Type:    TypeUserJoined,
+			RoomID:  client.RoomID,
+			Payload: UserEvent{UserID: client.UserID, UserName: client.UserName},
+			Sender:  client,
+		})
+	}
+}
+
+// handleUnregister removes the client, releases its topics in the background so
+// the ones without local clients are unsubscribed, and announces the user left
+// when this was its last connection to the room.
+func (h *Hub) handleUnregister(client *Client) {
+	h.mu.Lock()
+	clients := h.clients[client.RoomID]
+	h.clients[client.RoomID] = removeClient(clients, client)
+	removed := len(h.clients[client.RoomID]) != len(clients)
+	if len(h.clients[client.RoomID]) == 0 {
+		delete(h.clients, client.RoomID)
+	}
+	h.users[client.UserID] = removeClient(h.users[client.UserID], client)
+	if len(h.users[client.UserID]) == 0 {
+		delete(h.users, client.UserID)
+	}
+	if removed {
+		go h.releaseTopics(client)
+	}
+	lastConnection := removed && h.userConnections(client.RoomID, client.UserID) == 0
+	if h.closing && len(h.users) == 0 {
+		h.closeDrained()
+	}
+	h.mu.Unlock()
+
+	client.close()
+
+	// A client that disconnects mid-typing must not leave "is typing" behind
+	if removed && client.clearTyping() {
+		h.publish(typingMessage(TypeTypingStop, client))
+	}
+
+	if lastConnection {
+		h.publish(Message{
+			Type:    TypeUserLeft,
+			RoomID:  client.RoomID,
+			Payload: UserEvent{UserID: client.UserID, UserName: client.UserName},
+			Sender:  client,
+		})
+	}
+}
+
+// Shutdown stops the Hub gracefully: new clients are refused, every client gets
+// its queued frames flushed followed by a "going away" close frame, and once all
+// of them have unregistered (so in-flight messages are persisted) the Run loop
+// stops and the broker is closed. Run must be executing while Shutdown drains.
+// Returns ctx.Err() if the drain times out.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+	h.closing = true
+	var clients []*Client
+	for _, userClients := range h.users {
+		clients = append(clients, userClients...)
+	}
+	if len(clients) == 0 {
+		h.closeDrained()
+	}
+	h.mu.Unlock()
+
+	slog.Info("closing WebSocket connections", "count", len(clients))
+	for _, c := range clients {
+		c.setCloseReason(websocket.CloseGoingAway, "server shutting down")
+		c.close()
+	}
+
+	var err error
+	select {
+	case <-h.drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		// Drop connections that did not finish in time
+		for _, c := range clients {
+			if c.Conn != nil {
+				c.Conn.Close()
+			}
+		}
+	}
+
+	close(h.quit)
+	<-h.stopped
+	h.outbox.close()
+
+	if closeErr := h.broker.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// watchRevocations periodically disconnects sessions whose access token was
+// revoked (e.g. by logout on the REST server) or whose user was deleted.
+func (h *Hub) watchRevocations() {
+	ticker := time.NewTicker(h.config.RevocationInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.quit:
+			return
+		case <-ticker.C:
+			h.disconnectRevoked()
+		}
+	}
+}
+
+// disconnectRevoked closes every local session authenticated with an invalid token.
+func (h *Hub) disconnectRevoked() {
+	h.mu.Lock()
+	var refs []user.TokenRef
+	var clients []*Client
+	for userID, userClients := range h.users {
+		for _, c := range userClients {
+			if c.TokenID != "" {
+				refs = append(refs, user.TokenRef{ID: c.TokenID, UserID: userID, SessionID: c.SessionID})
+				clients = append(clients, c)
+			}
+		}
+	}
+	h.mu.Unlock()
+
+	if len(refs) == 0 {
+		return
+	}
+
+	invalid, err := invalidTokens(refs)
+	if err != nil {
+		slog.Error("could not check token revocations", "error", err)
+		return
+	}
+
+	revoked := make(map[string]bool)
+	for _, id := range invalid {
+		revoked[id] = true
+	}
+	for _, c := range clients {
+		if revoked[c.TokenID] {
+			c.logger().Info("disconnecting client: token revoked", "user_id", c.UserID)
+			c.setCloseReason(CloseTokenRevoked, "token revoked")
+			c.close()
+		}
+	}
+}
+
+// closeDrained signals that no clients remain. Callers must hold h.mu.
+func (h *Hub) closeDrained() {
+	select {
+	case <-h.drained:
+	default:
+		close(h.drained)
+	}
+}
+
+// Roster returns the users currently online in a room, one entry per user
+// regardless of how many connections they have open.
+func (h *Hub) Roster(roomID string) []UserEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.roster(roomID)
+}
+
+// roster builds the deduplicated list of online users. Callers must hold h.mu.
+func (h *Hub) roster(roomID string) []UserEvent {
+	users := []UserEvent{}
+	seen := make(map[uint]bool)
+	for _, c := range h.clients[roomID] {
+		if seen[c.UserID] {
+			continue
+		}
+		seen[c.UserID] = true
+		users = append(users, UserEvent{UserID: c.UserID, UserName: c.UserName})
+	}
+	return users
+}
+
+// userConnections counts the open connections of a user in a room. Callers must hold h.mu.
+func (h *Hub) userConnections(roomID string, userID uint) int {
+	count := 0
+	for _, c := range h.clients[roomID] {
+		if c.UserID == userID {
+			count++
+		}
+	}
+	return count
+}
+
+// publish wraps the message in an envelope and queues it in the outbox for the
+// topic of the target room, or of each target user, with the configured echo
+// mode of its type. The broker round trip happens off the Run loop.
+func (h *Hub) publish(msg Message) {
+	topics, data, ok := encodeMessage(msg, h.config.echoMode(msg.Type))
+	if !ok {
+		return
+	}
+	for _, topic := range topics {
+		h.outbox.push(topic, data)
+	}
+	messagesPublished.Inc(msg.Type)
+}
+
+// publishMessage encodes a message and publishes it to its topics, waiting for the broker.
+func publishMessage(broker Broker, msg Message, echo string) {
+	topics, data, ok := encodeMessage(msg, echo)
+	if !ok {
+		return
+	}
+	for _, topic := range topics {
+		if err := broker.Publish(topic, data); err != nil {
+			slog.Error("could not publish", "type", msg.Type, "topic", topic, "error", err)
+		}
+	}
+	messagesPublished.Inc(msg.Type)
+}
+
+// encodeMessage encodes a message as a broker payload for the room topic, or for
+// the topic of each recipient user for direct messages. Reports false, after
+// logging, when the message cannot be encoded.
+func encodeMessage(msg Message, echo string) ([]string, []byte, bool) {
+	roomID := msg.RoomID
+	if len(msg.ToUsers) > 0 {
+		roomID = ""
+	}
+	env, err := NewEnvelope(msg.Type, "", roomID, msg.Payload)
+	if err != nil {
+		slog.Error("could not build envelope", "type", msg.Type, "error", err)
+		return nil, nil, false
+	}
+	envBytes, err := json.Marshal(env)
+	if err != nil {
+		slog.Error("could not encode envelope", "type", msg.Type, "error", err)
+		return nil, nil, false
+	}
+
+	payload := brokerPayload{Envelope: envBytes}
+	if msg.Sender != nil {
+		payload.SenderID = msg.Sender.ID
+		payload.SenderUserID = msg.Sender.UserID
+		payload.Echo = echo
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("could not encode broker payload", "type", msg.Type, "error", err)
+		return nil, nil, false
+	}
+
+	topics := []string{roomTopic(msg.RoomID)}
+	if len(msg.ToUsers) > 0 {
+		topics = nil
+		for _, userID := range msg.ToUsers {
+			topics = append(topics, userTopic(userID))
+		}
+	}
+	return topics, data, true
+}
+
+// dispatch sends a message received from the broker to the local clients of its
+// topic. By default the connection that originated it is skipped and the sender's
+// other connections receive it, so the user's devices stay in sync.
+func (h *Hub) dispatch(delivery BrokerMessage) {
+	if delivery.Interrupted {
+		h.resyncAll()
+		return
+	}
+
+	var payload brokerPayload
+	if err := json.Unmarshal(delivery.Data, &payload); err != nil {
+		slog.Warn("invalid broker payload", "topic", delivery.Topic, "error", err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var recipients []*Client
+	if roomID, ok := strings.CutPrefix(delivery.Topic, roomTopicPrefix); ok {
+		recipients = h.clients[roomID]
+	} else if userStr, ok := strings.CutPrefix(delivery.Topic, userTopicPrefix); ok {
+		if userID, err := strconv.ParseUint(userStr, 10, 64); err == nil {
+			recipients = h.users[uint(userID)]
+		}
+	}
+
+	for _, c := range recipients {
+		if !payload.skips(c) && !c.trySend(payload.Envelope) {
+			h.slowConsumer(c)
+		}
+	}
+}
+
+// resyncAll tells every local client to reload its room, since broker
+// deliveries may have been lost while the broker was disconnected
+func (h *Hub) resyncAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	slog.Warn("broker delivery interrupted, asking clients to resync")
+	for roomID, clients := range h.clients {
+		env, err := NewEnvelope(TypeResyncRequired, "", roomID, ResyncPayload{
+			Reason:      ResyncDeliveryInterrupted,
+			ReplayLimit: h.config.ReplayLimit,
+		})
+		if err != nil {
+			slog.Error("could not build envelope", "type", TypeResyncRequired, "error", err)
+			continue
+		}
+		data, err := json.Marshal(env)
+		if err != nil {
+			slog.Error("could not encode envelope", "type", TypeResyncRequired, "error", err)
+			continue
+		}
+		for _, c := range clients {
+			if !c.trySend(data) {
+				h.slowConsumer(c)
+			}
+		}
+	}
+}
+
+// slowConsumer applies the configured policy to a client whose Send queue is full.
+// A stalled client never blocks delivery to the others.
+func (h *Hub) slowConsumer(c *Client) {
+	h.droppedFrames.Add(1)
+	if h.config.SlowConsumerPolicy == PolicyDrop {
+		return
+	}
+
+	c.mu.Lock()
+	alreadyClosed := c.closed
+	c.mu.Unlock()
+	if alreadyClosed {
+		return
+	}
+
+	c.logger().Warn("disconnecting slow consumer", "user_id", c.UserID)
+	h.slowConsumersClosed.Add(1)
+	c.setCloseReason(websocket.ClosePolicyViolation, "slow consumer")
+	c.close()
+}
+
+// clientTopics returns the broker topics a client receives traffic on
+func clientTopics(c *Client) []string {
+	return []string{roomTopic(c.RoomID), userTopic(c.UserID)}
+}
+
+// acquireTopics subscribes the broker to the client's topics that no local
+// client uses yet. On failure the topics acquired so far are released.
+func (h *Hub) acquireTopics(c *Client) error {
+	h.subMu.Lock()
+	defer h.subMu.Unlock()
+
+	topics := clientTopics(c)
+	for i, topic := range topics {
+		if h.topicRefs[topic] == 0 {
+			if err := h.broker.Subscribe(topic); err != nil {
+				h.releaseLocked(topics[:i])
+				return fmt.Errorf("could not subscribe to %s: %w", topic, err)
 			}
-			h.mu.Unlock()
+		}
+		h.topicRefs[topic]++
+	}
+	return nil
+}
+
+// releaseTopics releases the client's topics, unsubscribing the ones no local client uses anymore.
+func (h *Hub) releaseTopics(c *Client) {
+	h.subMu.Lock()
+	defer h.subMu.Unlock()
+	h.releaseLocked(clientTopics(c))
+}
+
+// releaseLocked drops one reference to each topic. Callers must hold h.subMu.
+func (h *Hub) releaseLocked(topics []string) {
+	for _, topic := range topics {
+		h.topicRefs[topic]--
+		if h.topicRefs[topic] > 0 {
+			continue
+		}
+		delete(h.topicRefs, topic)
+		if err := h.broker.Unsubscribe(topic); err != nil {
+			slog.Error("could not unsubscribe", "topic", topic, "error", err)
+		}
+	}
+}
+
+// Broker topic prefixes for room and user traffic
+const (
+	roomTopicPrefix = "room:"
+	userTopicPrefix = "user:"
+)
+
+// roomTopic returns the broker topic of a room
+func roomTopic(roomID string) string {
+	return roomTopicPrefix + roomID
+}
+
+// userTopic returns the broker topic of a user's connections
+func userTopic(userID uint) string {
+	return fmt.Sprintf("%s%d", userTopicPrefix, userID)
+}
+
+// removeClient returns the list without the given client
+func removeClient(clients []*Client, client *Client) []*Client {
+	for i, c := range clients {
+		if c == client {
+			return append(clients[:i], clients[i+1:]...)
 		}
 	}
+	return clients
 }
@@ -0,0 +1,432 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"go-chat-live/internal/user"
+
+	"github.com/gorilla/websocket"
+)
+
+func newTestClient(hub *Hub, roomID string, userID uint) *Client {
+	client := hub.NewClient(nil, roomID)
+	client.UserID = userID
+	client.UserName = "user"
+	return client
+}
+
+func newTestHub(bufferSize int, policy string) *Hub {
+	hub := NewHubWithConfig(NewMemoryBroker(), HubConfig{
+		SendBufferSize:     bufferSize,
+		SlowConsumerPolicy: policy,
+	})
+	go hub.Run()
+	return hub
+}
+
+// nextEnvelope reads the next frame queued for the client, failing after a short timeout
+func nextEnvelope(t *testing.T, c *Client) Envelope {
+	t.Helper()
+	select {
+	case data := <-c.Send:
+		var env Envelope
+		if err := json.Unmarshal(data, &env); err != nil {
+			t.Fatalf("invalid envelope: %v", err)
+		}
+		return env
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for envelope")
+	}
+	return Envelope{}
+}
+
+// expectNoEnvelope asserts that nothing is queued for the client
+func expectNoEnvelope(t *testing.T, c *Client) {
+	t.Helper()
+	select {
+	case data := <-c.Send:
+		t.Fatalf("expected no envelope, got %s", data)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestHub_PresenceDeduplicatesUserConnections(t *testing.T) {
+	hub := newTestHub(16, PolicyDisconnect)
+
+	watcher := newTestClient(hub, "1", 1)
+	hub.registerClient(watcher)
+	if env := nextEnvelope(t, watcher); env.Type != TypePresence {
+		t.Fatalf("expected presence roster on join, got %s", env.Type)
+	}
+
+	firstTab := newTestClient(hub, "1", 2)
+	secondTab := newTestClient(hub, "1", 2)
+
+	hub.registerClient(firstTab)
+	if env := nextEnvelope(t, watcher); env.Type != TypeUserJoined {
+		t.Fatalf("expected user_joined, got %s", env.Type)
+	}
+
+	hub.registerClient(secondTab)
+	expectNoEnvelope(t, watcher)
+
+	roster := hub.Roster("1")
+	if len(roster) != 2 {
+		t.Fatalf("expected 2 users in roster, got %d", len(roster))
+	}
+
+	hub.unregister <- firstTab
+	expectNoEnvelope(t, watcher)
+
+	hub.unregister <- secondTab
+	if env := nextEnvelope(t, watcher); env.Type != TypeUserLeft {
+		t.Fatalf("expected user_left, got %s", env.Type)
+	}
+}
+
+func TestHub_DirectMessageReachesEveryUserConnection(t *testing.T) {
+	hub := newTestHub(16, PolicyDisconnect)
+
+	senderLaptop := newTestClient(hub, "1", 1)
+	senderPhone := newTestClient(hub, "2", 1)
+	recipientTab := newTestClient(hub, "1", 2)
+	recipientOtherRoom := newTestClient(hub, "3", 2)
+	bystander := newTestClient(hub, "1", 3)
+
+	for _, c := range []*Client{senderLaptop, senderPhone, recipientTab, recipientOtherRoom, bystander} {
+		hub.registerClient(c)
+	}
+	// Drain presence traffic generated by the registrations
+	drain(senderLaptop, senderPhone, recipientTab, recipientOtherRoom, bystander)
+
+	hub.broadcast <- Message{
+		Type:    TypeDirect,
+		ToUsers: []uint{2, 1},
+		Payload: DirectMessage{SenderID: 1, RecipientID: 2, Content: "psst"},
+		Sender:  senderLaptop,
+	}
+
+	for _, c := range []*Client{recipientTab, recipientOtherRoom, senderPhone} {
+		if env := nextEnvelope(t, c); env.Type != TypeDirect {
+			t.Fatalf("expected direct_message, got %s", env.Type)
+		}
+	}
+	expectNoEnvelope(t, senderLaptop)
+	expectNoEnvelope(t, bystander)
+}
+
+func TestHub_InterruptedDeliveryAsksClientsToResync(t *testing.T) {
+	broker := NewMemoryBroker()
+	hub := NewHubWithConfig(broker, HubConfig{SendBufferSize: 16, SlowConsumerPolicy: PolicyDisconnect, ReplayLimit: 5})
+	go hub.Run()
+
+	first := newTestClient(hub, "1", 1)
+	second := newTestClient(hub, "2", 2)
+	hub.registerClient(first)
+	hub.registerClient(second)
+	drain(first, second)
+
+	broker.queue.push(BrokerMessage{Interrupted: true})
+
+	for _, c := range []*Client{first, second} {
+		env := nextEnvelope(t, c)
+		if env.Type != TypeResyncRequired || env.Room != c.RoomID {
+			t.Fatalf("expected resync_required for room %s, got %s for room %s", c.RoomID, env.Type, env.Room)
+		}
+		var payload ResyncPayload
+		if err := json.Unmarshal(env.Payload, &payload); err != nil {
+			t.Fatalf("invalid payload: %v", err)
+		}
+		if payload.Reason != ResyncDeliveryInterrupted {
+			t.Fatalf("expected reason %s, got %s", ResyncDeliveryInterrupted, payload.Reason)
+		}
+	}
+}
+
+func TestHub_EchoModesPerType(t *testing.T) {
+	hub := NewHubWithConfig(NewMemoryBroker(), HubConfig{
+		SendBufferSize:     16,
+		SlowConsumerPolicy: PolicyDisconnect,
+		Echo:               map[string]string{TypeTypingStart: EchoNone, TypeReactionAdded: EchoAll},
+	})
+	go hub.Run()
+
+	laptop := newTestClient(hub, "1", 1)
+	phone := newTestClient(hub, "1", 1)
+	friend := newTestClient(hub, "1", 2)
+	for _, c := range []*Client{laptop, phone, friend} {
+		hub.registerClient(c)
+	}
+	drain(laptop, phone, friend)
+
+	// Padrão (others): o celular do remetente recebe a própria mensagem
+	hub.broadcast <- Message{Type: TypeMessage, RoomID: "1", Payload: ChatMessage{ID: 1}, Sender: laptop}
+	for _, c := range []*Client{phone, friend} {
+		if env := nextEnvelope(t, c); env.Type != TypeMessage {
+			t.Fatalf("expected message, got %s", env.Type)
+		}
+	}
+	expectNoEnvelope(t, laptop)
+
+	hub.broadcast <- typingMessage(TypeTypingStart, laptop)
+	if env := nextEnvelope(t, friend); env.Type != TypeTypingStart {
+		t.Fatalf("expected typing_start, got %s", env.Type)
+	}
+	expectNoEnvelope(t, phone)
+	expectNoEnvelope(t, laptop)
+
+	hub.broadcast <- Message{Type: TypeReactionAdded, RoomID: "1", Payload: ReactionPayload{MessageID: 1}, Sender: laptop}
+	for _, c := range []*Client{laptop, phone, friend} {
+		if env := nextEnvelope(t, c); env.Type != TypeReactionAdded {
+			t.Fatalf("expected reaction_added, got %s", env.Type)
+		}
+	}
+}
+
+// drain discards every frame already queued for the clients
+func drain(clients ...*Client) {
+	time.Sleep(50 * time.Millisecond)
+	for _, c := range clients {
+		for len(c.Send) > 0 {
+			<-c.Send
+		}
+	}
+}
+
+func TestHub_StuckClientDoesNotStallOthers(t *testing.T) {
+	for _, policy := range []string{PolicyDisconnect, PolicyDrop} {
+		t.Run(policy, func(t *testing.T) {
+			hub := newTestHub(4, policy)
+
+			const total = 50
+
+			// The stuck client never reads and has a tiny queue; the reader's queue fits every frame
+			stuck := newTestClient(hub, "1", 1)
+			reader := newTestClient(hub, "1", 2)
+			reader.Send = make(chan []byte, total+8)
+			sender := newTestClient(hub, "1", 3)
+			for _, c := range []*Client{stuck, reader, sender} {
+				hub.registerClient(c)
+			}
+			drain(reader, sender)
+
+			done := make(chan struct{})
+			go func() {
+				for i := 0; i < total; i++ {
+					hub.broadcast <- Message{Type: TypeMessage, RoomID: "1", Payload: ChatMessage{Content: "hi"}, Sender: sender}
+				}
+				close(done)
+			}()
+
+			select {
+			case <-done:
+			case <-time.After(2 * time.Second):
+				t.Fatal("hub stalled while broadcasting to a stuck client")
+			}
+
+			for i := 0; i < total; i++ {
+				if env := nextEnvelope(t, reader); env.Type != TypeMessage {
+					t.Fatalf("expected message envelope, got %s", env.Type)
+				}
+			}
+
+			stats := hub.Stats()
+			if stats.DroppedFrames == 0 {
+				t.Error("expected dropped frames to be counted")
+			}
+
+			stuck.mu.Lock()
+			closed := stuck.closed
+			stuck.mu.Unlock()
+			if policy == PolicyDisconnect && (!closed || stats.SlowConsumersClosed != 1) {
+				t.Errorf("expected stuck client to be disconnected once, closed=%v stats=%+v", closed, stats)
+			}
+			if policy == PolicyDrop && (closed || stats.SlowConsumersClosed != 0) {
+				t.Errorf("expected stuck client to stay connected, closed=%v stats=%+v", closed, stats)
+			}
+		})
+	}
+}
+
+func TestHub_RevokedTokenDisconnectsSession(t *testing.T) {
+	original := invalidTokens
+	defer func() { invalidTokens = original }()
+	invalidTokens = func(tokens []user.TokenRef) ([]string, error) {
+		return []string{"revoked"}, nil
+	}
+
+	hub := newTestHub(16, PolicyDisconnect)
+
+	revoked := newTestClient(hub, "1", 1)
+	revoked.TokenID = "revoked"
+	valid := newTestClient(hub, "1", 2)
+	valid.TokenID = "valid"
+	hub.registerClient(revoked)
+	hub.registerClient(valid)
+	nextEnvelope(t, revoked)
+	nextEnvelope(t, valid)
+	drain(revoked, valid)
+
+	hub.disconnectRevoked()
+
+	for range revoked.Send {
+	}
+	closeErr := websocket.FormatCloseMessage(CloseTokenRevoked, "token revoked")
+	if string(revoked.closeMessage()) != string(closeErr) {
+		t.Errorf("expected close code %d, got %q", CloseTokenRevoked, revoked.closeMessage())
+	}
+
+	valid.mu.Lock()
+	closed := valid.closed
+	valid.mu.Unlock()
+	if closed {
+		t.Error("expected session with a valid token to stay connected")
+	}
+}
+
+func newTypingHub(throttle, timeout time.Duration) *Hub {
+	hub := NewHubWithConfig(NewMemoryBroker(), HubConfig{
+		SendBufferSize:     16,
+		SlowConsumerPolicy: PolicyDisconnect,
+		TypingThrottle:     throttle,
+		TypingTimeout:      timeout,
+	})
+	go hub.Run()
+	return hub
+}
+
+func TestHub_TypingStartIsThrottled(t *testing.T) {
+	hub := newTypingHub(time.Hour, 0)
+
+	watcher := newTestClient(hub, "1", 1)
+	typist := newTestClient(hub, "1", 2)
+	hub.registerClient(watcher)
+	hub.registerClient(typist)
+	drain(watcher, typist)
+
+	hub.startTyping(typist)
+	hub.startTyping(typist)
+	hub.startTyping(typist)
+
+	if env := nextEnvelope(t, watcher); env.Type != TypeTypingStart {
+		t.Fatalf("expected typing_start, got %s", env.Type)
+	}
+	expectNoEnvelope(t, watcher)
+	expectNoEnvelope(t, typist)
+
+	hub.stopTyping(typist)
+	if env := nextEnvelope(t, watcher); env.Type != TypeTypingStop {
+		t.Fatalf("expected typing_stop, got %s", env.Type)
+	}
+
+	// Parar de novo não gera outro evento
+	hub.stopTyping(typist)
+	expectNoEnvelope(t, watcher)
+}
+
+func TestHub_TypingExpiresWithoutRefresh(t *testing.T) {
+	hub := newTypingHub(time.Hour, 50*time.Millisecond)
+
+	watcher := newTestClient(hub, "1", 1)
+	typist := newTestClient(hub, "1", 2)
+	hub.registerClient(watcher)
+	hub.registerClient(typist)
+	drain(watcher, typist)
+
+	hub.startTyping(typist)
+	if env := nextEnvelope(t, watcher); env.Type != TypeTypingStart {
+		t.Fatalf("expected typing_start, got %s", env.Type)
+	}
+	if env := nextEnvelope(t, watcher); env.Type != TypeTypingStop {
+		t.Fatalf("expected typing_stop after the timeout, got %s", env.Type)
+	}
+}
+
+func TestHub_TypingStopsWhenClientDisconnects(t *testing.T) {
+	hub := newTypingHub(time.Hour, time.Hour)
+
+	watcher := newTestClient(hub, "1", 1)
+	typist := newTestClient(hub, "1", 2)
+	hub.registerClient(watcher)
+	hub.registerClient(typist)
+	drain(watcher, typist)
+
+	hub.startTyping(typist)
+	if env := nextEnvelope(t, watcher); env.Type != TypeTypingStart {
+		t.Fatalf("expected typing_start, got %s", env.Type)
+	}
+
+	hub.unregister <- typist
+	if env := nextEnvelope(t, watcher); env.Type != TypeTypingStop {
+		t.Fatalf("expected typing_stop on disconnect, got %s", env.Type)
+	}
+	if env := nextEnvelope(t, watcher); env.Type != TypeUserLeft {
+		t.Fatalf("expected user_left, got %s", env.Type)
+	}
+}
+
+func TestLoadHubConfig_ZeroDisablesOptionalLimits(t *testing.T) {
+	t.Setenv("WS_MESSAGE_RATE", "0")
+	t.Setenv("WS_USER_MESSAGE_RATE", "0")
+	t.Setenv("WS_MAX_RATE_VIOLATIONS", "0")
+	t.Setenv("WS_REVOCATION_CHECK_INTERVAL", "0s")
+	t.Setenv("WS_TYPING_TIMEOUT", "0")
+	t.Setenv("WS_SEND_BUFFER", "0")
+
+	config := LoadHubConfig()
+	if config.MessageRate != 0 || config.UserMessageRate != 0 || config.MaxRateViolations != 0 ||
+		config.RevocationInterval != 0 || config.TypingTimeout != 0 {
+		t.Errorf("expected zero to disable the optional limits, got %+v", config)
+	}
+	if config.SendBufferSize != 256 {
+		t.Errorf("expected a zero Send queue to fall back to the default, got %d", config.SendBufferSize)
+	}
+}
+
+// Mock do broker que falha ao assinar os tópicos de um usuário
+type failingSubscribeBroker struct {
+	*MemoryBroker
+	failTopic string
+}
+
+func (b *failingSubscribeBroker) Subscribe(topic string) error {
+	if topic == b.failTopic {
+		return errors.New("listen failed")
+	}
+	return b.MemoryBroker.Subscribe(topic)
+}
+
+func TestHub_RejectsClientWhenSubscribeFails(t *testing.T) {
+	broker := &failingSubscribeBroker{MemoryBroker: NewMemoryBroker(), failTopic: userTopic(2)}
+	hub := NewHubWithConfig(broker, HubConfig{SendBufferSize: 16, SlowConsumerPolicy: PolicyDisconnect})
+	go hub.Run()
+
+	rejected := newTestClient(hub, "1", 2)
+	if err := hub.registerClient(rejected); err == nil {
+		t.Fatal("expected registration to fail")
+	}
+	// A sala assinada antes da falha deve ser liberada
+	hub.subMu.Lock()
+	refs := len(hub.topicRefs)
+	hub.subMu.Unlock()
+	if refs != 0 {
+		t.Fatalf("expected no topic references after the failure, got %d", refs)
+	}
+
+	accepted := newTestClient(hub, "1", 1)
+	if err := hub.registerClient(accepted); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if env := nextEnvelope(t, accepted); env.Type != TypePresence {
+		t.Fatalf("expected presence, got %s", env.Type)
+	}
+	hub.mu.Lock()
+	connections := hub.userConnections("1", 2)
+	hub.mu.Unlock()
+	if connections != 0 {
+		t.Fatal("rejected client should not be registered")
+	}
+}
@@ -0,0 +1,71 @@
+package chat
+
+import (
+	"go-chat-live/internal/metrics"
+)
+
+// messagesPublished counts envelopes published for delivery, by type
+var messagesPublished = metrics.NewCounter("chat_messages_broadcast_total",
+	"Envelopes published to rooms or users, by type.", "type")
+
+// RegisterMetrics exposes the connections, Send queues and delivery counters of
+// the Hub in the Default metrics registry. Call it once for the Hub of the server.
+func RegisterMetrics(hub *Hub) {
+	hub.registerMetrics(metrics.Default)
+}
+
+func (h *Hub) registerMetrics(registry *metrics.Registry) {
+	registry.NewGaugeFunc("chat_websocket_connections",
+		"Open WebSocket connections, by room.",
+		func(emit metrics.Emit) {
+			for _, room := range h.queueStats() {
+				emit(float64(room.connections), room.id)
+			}
+		}, "room")
+	registry.NewGaugeFunc("chat_send_queue_depth",
+		"Frames waiting in the Send queues of the connections of a room.",
+		func(emit metrics.Emit) {
+			for _, room := range h.queueStats() {
+				emit(float64(room.queued), room.id)
+			}
+		}, "room")
+	registry.NewGaugeFunc("chat_send_queue_max_depth",
+		"Frames waiting in the fullest Send queue of a room.",
+		func(emit metrics.Emit) {
+			for _, room := range h.queueStats() {
+				emit(float64(room.maxQueued), room.id)
+			}
+		}, "room")
+	registry.NewCounterFunc("chat_dropped_frames_total",
+		"Frames not delivered because a client's Send queue was full.",
+		func(emit metrics.Emit) { emit(float64(h.Stats().DroppedFrames)) })
+	registry.NewCounterFunc("chat_slow_consumers_disconnected_total",
+		"Clients disconnected for not keeping up with their Send queue.",
+		func(emit metrics.Emit) { emit(float64(h.Stats().SlowConsumersClosed)) })
+}
+
+// roomQueueStats is a snapshot of the connections of a room and their Send queues
+type roomQueueStats struct {
+	id          string
+	connections int
+	queued      int
+	maxQueued   int
+}
+
+// queueStats returns a snapshot of the connections and Send queues of each room
+func (h *Hub) queueStats() []roomQueueStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stats := make([]roomQueueStats, 0, len(h.clients))
+	for roomID, clients := range h.clients {
+		room := roomQueueStats{id: roomID, connections: len(clients)}
+		for _, c := range clients {
+			depth := len(c.Send)
+			room.queued += depth
+			room.maxQueued = max(room.maxQueued, depth)
+		}
+		stats = append(stats, room)
+	}
+	return stats
+}
@@ -0,0 +1,45 @@
+package chat
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"go-chat-live/internal/metrics"
+)
+
+func TestHub_MetricsReportConnectionsAndQueues(t *testing.T) {
+	hub := newTestHub(16, PolicyDisconnect)
+	registry := metrics.NewRegistry()
+	hub.registerMetrics(registry)
+
+	first := newTestClient(hub, "1", 1)
+	second := newTestClient(hub, "1", 2)
+	other := newTestClient(hub, "2", 3)
+	for _, c := range []*Client{first, second, other} {
+		hub.registerClient(c)
+	}
+	// Drain presence traffic, then leave two frames in each queue of room 1
+	drain(first, second, other)
+	for range 2 {
+		hub.broadcast <- Message{Type: TypeMessage, RoomID: "1", Payload: ChatMessage{Content: "hi"}}
+	}
+	drain()
+
+	var out strings.Builder
+	if err := registry.WriteTo(bufio.NewWriter(&out)); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`chat_websocket_connections{room="1"} 2`,
+		`chat_websocket_connections{room="2"} 1`,
+		`chat_send_queue_depth{room="1"} 4`,
+		`chat_send_queue_max_depth{room="1"} 2`,
+		`chat_send_queue_depth{room="2"} 0`,
+		"chat_dropped_frames_total 0",
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("expected line %q in output:\n%s", line, out.String())
+		}
+	}
+}
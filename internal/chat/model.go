@@ -0,0 +1,122 @@
+package chat
+
+import (
+	"time"
+
+	"go-chat-live/internal/attachment"
+
+	"gorm.io/gorm"
+)
+
+// StoredMessage represents a chat message persisted in the database.
+// Every message broadcast in a room is stored so clients can load history.
+type StoredMessage struct {
+	ID        uint           `gorm:"primaryKey" json:"id"`                                            // Server-assigned message ID, used as pagination cursor
+	RoomID    string         `gorm:"index;not null" json:"roomId"`                                    // Room the message was sent to
+	UserID    uint           `gorm:"index;not null;uniqueIndex:idx_messages_client_id" json:"userId"` // Sender user ID
+	UserName  string         `json:"userName"`                                                        // Sender display name at send time
+	Content   string         `gorm:"type:text;not null" json:"content"`                               // Message content
+	CreatedAt time.Time      `json:"createdAt"`                                                       // Timestamp assigned on persistence
+	EditedAt  *time.Time     `json:"editedAt,omitempty"`                                              // Timestamp of the latest edit, nil if never edited
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`                                                  // Soft delete; deleted messages are hidden from history
+
+	ReplyToID   *uint      `gorm:"index" json:"replyToId,omitempty"`     // Quoted message, if any
+	ThreadID    *uint      `gorm:"index" json:"threadId,omitempty"`      // Root message of the thread, nil for main room messages
+	ReplyCount  int        `gorm:"not null;default:0" json:"replyCount"` // Number of thread replies, on root messages
+	LastReplyAt *time.Time `json:"lastReplyAt,omitempty"`                // Timestamp of the latest thread reply, on root messages
+
+	ClientMessageID *string `gorm:"size:64;uniqueIndex:idx_messages_client_id" json:"clientMessageId,omitempty"` // ID chosen by the sender's client, unique per sender, used to drop retried sends
+
+	AttachmentCount int                     `gorm:"not null;default:0" json:"-"`    // Number of linked attachments, avoids lookups for plain messages
+	Attachments     []attachment.Attachment `gorm:"-" json:"attachments,omitempty"` // Linked files, filled when loading history
+
+	Reactions []ReactionSummary `gorm:"-" json:"reactions,omitempty"` // Aggregated reactions, filled when loading history
+}
+
+// TableName overrides the default GORM table name.
+func (StoredMessage) TableName() string {
+	return "messages"
+}
+
+// Reaction is an emoji reaction of a user to a room message.
+// A user can react with each emoji at most once per message.
+type Reaction struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`                                              // Primary key for database
+	MessageID uint      `gorm:"uniqueIndex:idx_reaction_user_emoji;not null" json:"messageId"`     // Message reacted to
+	UserID    uint      `gorm:"uniqueIndex:idx_reaction_user_emoji;not null" json:"userId"`        // User that reacted
+	Emoji     string    `gorm:"uniqueIndex:idx_reaction_user_emoji;size:32;not null" json:"emoji"` // Reaction emoji
+	CreatedAt time.Time `json:"createdAt"`                                                         // Time of the reaction
+}
+
+// TableName overrides the default GORM table name.
+func (Reaction) TableName() string {
+	return "message_reactions"
+}
+
+// ReactionSummary aggregates the reactions with one emoji to a message.
+type ReactionSummary struct {
+	Emoji   string `json:"emoji"`   // Reaction emoji
+	Count   int    `json:"count"`   // Number of users that reacted with it
+	UserIDs []uint `json:"userIds"` // Users that reacted, so clients can highlight their own
+}
+
+// MessageEdit records the content a room message had before an edit.
+type MessageEdit struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`              // Primary key for database
+	MessageID uint      `gorm:"index;not null" json:"messageId"`   // Edited message
+	Content   string    `gorm:"type:text;not null" json:"content"` // Content replaced by the edit
+	EditedBy  uint      `gorm:"not null" json:"editedBy"`          // User that made the edit
+	EditedAt  time.Time `json:"editedAt"`                          // Time of the edit
+}
+
+// ReadMarker records the latest room message a user has read. Messages with a
+// greater ID, sent by other users, count as unread.
+type ReadMarker struct {
+	UserID     uint      `gorm:"primaryKey;autoIncrement:false" json:"userId"` // Reader user ID
+	RoomID     string    `gorm:"primaryKey" json:"roomId"`                     // Room the marker belongs to
+	LastReadID uint      `gorm:"not null" json:"lastReadId"`                   // Latest message read in the room
+	UpdatedAt  time.Time `json:"updatedAt"`                                    // Time the marker last moved forward
+}
+
+// TableName overrides the default GORM table name.
+func (ReadMarker) TableName() string {
+	return "read_markers"
+}
+
+// Conversation represents a private 1:1 conversation between two users.
+// The pair is stored ordered (UserLowID < UserHighID) so each pair has a single row.
+type Conversation struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`                                 // Primary key for database
+	UserLowID     uint      `gorm:"uniqueIndex:idx_conversation_users;not null" json:"-"` // Smaller participant user ID
+	UserHighID    uint      `gorm:"uniqueIndex:idx_conversation_users;not null" json:"-"` // Greater participant user ID
+	LastMessageAt time.Time `gorm:"index" json:"lastMessageAt"`                           // Timestamp of the latest message
+	CreatedAt     time.Time `json:"createdAt"`                                            // Creation timestamp
+}
+
+// DirectMessage represents a private message persisted in a conversation.
+type DirectMessage struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`                                               // Server-assigned message ID, used as pagination cursor
+	ConversationID uint      `gorm:"index;not null" json:"conversationId"`                               // Conversation the message belongs to
+	SenderID       uint      `gorm:"not null;uniqueIndex:idx_direct_messages_client_id" json:"senderId"` // Sender user ID
+	RecipientID    uint      `gorm:"not null" json:"recipientId"`                                        // Recipient user ID
+	SenderName     string    `json:"senderName"`                                                         // Sender display name at send time
+	Content        string    `gorm:"type:text;not null" json:"content"`                                  // Message content
+	CreatedAt      time.Time `json:"createdAt"`                                                          // Timestamp assigned on persistence
+
+	ClientMessageID *string `gorm:"size:64;uniqueIndex:idx_direct_messages_client_id" json:"clientMessageId,omitempty"` // ID chosen by the sender's client, unique per sender
+}
+
+// SearchResult is a room message matching a search, with the matching terms
+// highlighted in Snippet.
+type SearchResult struct {
+	StoredMessage
+	Snippet string `json:"snippet"` // HTML-escaped excerpt of the content with matches wrapped in <mark>
+}
+
+// NotificationPayload holds broker data too large for a PostgreSQL NOTIFY
+// payload; the notification carries its ID. Rows are pruned after a few minutes.
+type NotificationPayload struct {
+	ID        uint      `gorm:"primaryKey"`
+	Data      string    `gorm:"type:text;not null"` // Published data
+	CreatedAt time.Time `gorm:"index"`
+}
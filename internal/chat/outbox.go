@@ -0,0 +1,68 @@
+package chat
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// outbox publishes the Hub's messages to the broker from its own goroutine, in
+// order. Pushing never blocks, so a slow broker delays publishing but never the
+// Run loop and the deliveries it makes to every room.
+type outbox struct {
+	broker  Broker
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []BrokerMessage
+	closed  bool
+	done    chan struct{} // Closed once every pending message was published after close
+}
+
+// newOutbox creates an outbox and starts its publishing goroutine.
+func newOutbox(broker Broker) *outbox {
+	o := &outbox{broker: broker, done: make(chan struct{})}
+	o.cond = sync.NewCond(&o.mu)
+	go o.run()
+	return o
+}
+
+// push queues data for publishing to the topic.
+func (o *outbox) push(topic string, data []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return
+	}
+	o.pending = append(o.pending, BrokerMessage{Topic: topic, Data: data})
+	o.cond.Signal()
+}
+
+// close stops accepting messages and waits until the pending ones are published.
+func (o *outbox) close() {
+	o.mu.Lock()
+	o.closed = true
+	o.cond.Signal()
+	o.mu.Unlock()
+	<-o.done
+}
+
+// run publishes queued messages until the outbox is closed and empty.
+func (o *outbox) run() {
+	defer close(o.done)
+	for {
+		o.mu.Lock()
+		for len(o.pending) == 0 && !o.closed {
+			o.cond.Wait()
+		}
+		if len(o.pending) == 0 {
+			o.mu.Unlock()
+			return
+		}
+		msg := o.pending[0]
+		o.pending = o.pending[1:]
+		o.mu.Unlock()
+
+		if err := o.broker.Publish(msg.Topic, msg.Data); err != nil {
+			slog.Error("could not publish", "topic", msg.Topic, "error", err)
+		}
+	}
+}
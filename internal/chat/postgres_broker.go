@@ -0,0 +1,364 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-chat-live/internal/database"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// postgresNotifyLimit is the maximum NOTIFY payload size accepted by PostgreSQL.
+const postgresNotifyLimit = 8000
+
+// notificationRefPrefix marks a NOTIFY payload carrying the ID of a
+// NotificationPayload row instead of the data; published data is JSON and never starts with it
+const notificationRefPrefix = "ref:"
+
+// notificationPayloadTTL is how long large payloads are kept for the listeners to load them
+const notificationPayloadTTL = 5 * time.Minute
+
+// notificationSweepInterval is how often expired large payloads are deleted
+const notificationSweepInterval = time.Minute
+
+// Delays between attempts to reconnect the LISTEN connection, doubling up to the maximum
+const (
+	reconnectMinBackoff = 500 * time.Millisecond
+	reconnectMaxBackoff = 30 * time.Second
+)
+
+// PostgresBroker is a Broker backed by PostgreSQL LISTEN/NOTIFY.
+// It publishes through the shared database.DB pool and keeps one dedicated
+// connection from the same pool to LISTEN on the subscribed topics. When that
+// connection fails it reconnects with backoff, listens to every subscribed
+// topic again and delivers an Interrupted message, since notifications sent
+// in the meantime are lost.
+type PostgresBroker struct {
+	db      *sql.DB            // Pool the dedicated connection is taken from
+	queue   *messageQueue      // Pending deliveries
+	ctx     context.Context    // Cancelled on Close
+	cancel  context.CancelFunc // Stops the listen loop
+	stopped chan struct{}      // Closed when the listen loop returns
+	swept   chan struct{}      // Closed when the payload sweeper returns
+
+	mu        sync.Mutex
+	topics    map[string]bool    // Subscribed topics, listened to again after a reconnect
+	connected bool               // Whether the dedicated connection is listening to every topic
+	commands  []listenCommand    // LISTEN/UNLISTEN requests waiting for the listen loop
+	wake      context.CancelFunc // Interrupts the current wait for notifications
+}
+
+// listenCommand asks the listen loop to run LISTEN or UNLISTEN on its connection.
+type listenCommand struct {
+	sql  string
+	done chan error
+}
+
+// NewPostgresBroker creates a Broker using the existing database.DB connection pool.
+// It fails when the first LISTEN connection cannot be opened.
+func NewPostgresBroker() (*PostgresBroker, error) {
+	sqlDB, err := database.DB.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	b := &PostgresBroker{
+		db:      sqlDB,
+		queue:   newMessageQueue(),
+		ctx:     ctx,
+		cancel:  cancel,
+		stopped: make(chan struct{}),
+		swept:   make(chan struct{}),
+		topics:  make(map[string]bool),
+	}
+	go b.maintain(conn)
+	go b.sweep()
+	return b, nil
+}
+
+// Publish sends data to the topic with NOTIFY. Data too large for a NOTIFY
+// payload is stored in a NotificationPayload row and its ID is sent instead;
+// the row is deleted by the sweeper once it expires.
+func (b *PostgresBroker) Publish(topic string, data []byte) error {
+	payload := string(data)
+	if len(data) >= postgresNotifyLimit {
+		row := NotificationPayload{Data: payload}
+		if err := database.DB.Create(&row).Error; err != nil {
+			return fmt.Errorf("could not store large payload: %w", err)
+		}
+		payload = notificationRefPrefix + strconv.FormatUint(uint64(row.ID), 10)
+	}
+	return database.DB.Exec("SELECT pg_notify(?, ?)", topic, payload).Error
+}
+
+// Subscribe runs LISTEN for the topic, returning once the subscription is active.
+// While the connection is down it only records the topic, which is listened to
+// on reconnect.
+func (b *PostgresBroker) Subscribe(topic string) error {
+	return b.run(topic, true, "LISTEN "+pgx.Identifier{topic}.Sanitize())
+}
+
+// Unsubscribe runs UNLISTEN for the topic.
+func (b *PostgresBroker) Unsubscribe(topic string) error {
+	return b.run(topic, false, "UNLISTEN "+pgx.Identifier{topic}.Sanitize())
+}
+
+// Messages returns the channel of received notifications.
+func (b *PostgresBroker) Messages() <-chan BrokerMessage {
+	return b.queue.out
+}
+
+// Close stops listening and sweeping and closes the dedicated connection.
+func (b *PostgresBroker) Close() error {
+	b.cancel()
+	<-b.stopped
+	<-b.swept
+	b.queue.close()
+	return nil
+}
+
+// sweep periodically deletes the NotificationPayload rows older than
+// notificationPayloadTTL until the broker is closed.
+func (b *PostgresBroker) sweep() {
+	defer close(b.swept)
+
+	ticker := time.NewTicker(notificationSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := database.DB.WithContext(b.ctx).
+			Where("created_at < ?", time.Now().Add(-notificationPayloadTTL)).
+			Delete(&NotificationPayload{}).Error
+		if err != nil && b.ctx.Err() == nil {
+			slog.Error("could not delete expired notification payloads", "error", err)
+		}
+	}
+}
+
+// run records the subscription change and, while connected, hands the
+// statement to the listen loop and waits for its result.
+func (b *PostgresBroker) run(topic string, subscribe bool, statement string) error {
+	cmd := listenCommand{sql: statement, done: make(chan error, 1)}
+
+	b.mu.Lock()
+	if subscribe {
+		b.topics[topic] = true
+	} else {
+		delete(b.topics, topic)
+	}
+	if !b.connected {
+		b.mu.Unlock()
+		return nil
+	}
+	b.commands = append(b.commands, cmd)
+	if b.wake != nil {
+		b.wake()
+	}
+	b.mu.Unlock()
+
+	select {
+	case err := <-cmd.done:
+		return err
+	case <-b.stopped:
+		return errors.New("postgres broker is closed")
+	}
+}
+
+// maintain runs listen sessions on dedicated connections until the broker is
+// closed, reconnecting with exponential backoff after a failure.
+func (b *PostgresBroker) maintain(conn *sql.Conn) {
+	defer close(b.stopped)
+
+	backoff := reconnectMinBackoff
+	for reconnect := false; ; reconnect = true {
+		var listened bool
+		var err error
+		if conn == nil {
+			conn, err = b.db.Conn(b.ctx)
+		}
+		if conn != nil {
+			listened, err = b.session(conn, reconnect)
+			conn = nil
+		}
+		if b.ctx.Err() != nil {
+			return
+		}
+		if listened {
+			backoff = reconnectMinBackoff
+		}
+
+		slog.Error("postgres broker connection lost, reconnecting", "error", err, "retry_in", backoff.String())
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, reconnectMaxBackoff)
+	}
+}
+
+// session listens on one dedicated connection until it fails or the broker is
+// closed, reporting whether it got to listen. The connection is never returned
+// to the pool, since it is left with LISTEN state.
+func (b *PostgresBroker) session(conn *sql.Conn, reconnect bool) (bool, error) {
+	defer conn.Close()
+
+	var listened bool
+	var sessionErr error
+	conn.Raw(func(driverConn any) error {
+		stdConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			sessionErr = errors.New("database driver is not pgx")
+			return driver.ErrBadConn
+		}
+		pgConn := stdConn.Conn()
+
+		if sessionErr = b.listenAll(pgConn); sessionErr != nil {
+			return driver.ErrBadConn
+		}
+		listened = true
+		if reconnect {
+			slog.Info("postgres broker reconnected")
+			b.queue.push(BrokerMessage{Interrupted: true})
+		}
+
+		sessionErr = b.listen(pgConn)
+		return driver.ErrBadConn
+	})
+
+	b.disconnect()
+	return listened, sessionErr
+}
+
+// listenAll runs LISTEN for every subscribed topic, including the ones
+// subscribed while it runs, then marks the broker connected.
+func (b *PostgresBroker) listenAll(conn *pgx.Conn) error {
+	listening := make(map[string]bool)
+	for {
+		b.mu.Lock()
+		var pending []string
+		for topic := range b.topics {
+			if !listening[topic] {
+				pending = append(pending, topic)
+			}
+		}
+		if len(pending) == 0 {
+			b.connected = true
+			b.mu.Unlock()
+			return nil
+		}
+		b.mu.Unlock()
+
+		for _, topic := range pending {
+			if _, err := conn.Exec(b.ctx, "LISTEN "+pgx.Identifier{topic}.Sanitize()); err != nil {
+				return err
+			}
+			listening[topic] = true
+		}
+	}
+}
+
+// disconnect marks the broker disconnected and releases the waiting
+// subscription changes; they are applied by listenAll on reconnect.
+func (b *PostgresBroker) disconnect() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.connected = false
+	for _, cmd := range b.commands {
+		cmd.done <- nil
+	}
+	b.commands = nil
+}
+
+// listen owns the dedicated connection: it executes pending LISTEN/UNLISTEN
+// statements and waits for notifications until the broker is closed.
+func (b *PostgresBroker) listen(conn *pgx.Conn) error {
+	for {
+		b.mu.Lock()
+		commands := b.commands
+		b.commands = nil
+		b.mu.Unlock()
+
+		for _, cmd := range commands {
+			_, err := conn.Exec(b.ctx, cmd.sql)
+			cmd.done <- err
+			if err != nil && conn.IsClosed() {
+				return err
+			}
+		}
+
+		waitCtx, wake := context.WithCancel(b.ctx)
+		b.mu.Lock()
+		if len(b.commands) > 0 {
+			b.mu.Unlock()
+			wake()
+			continue
+		}
+		b.wake = wake
+		b.mu.Unlock()
+
+		notification, err := conn.WaitForNotification(waitCtx)
+
+		b.mu.Lock()
+		b.wake = nil
+		b.mu.Unlock()
+		wake()
+
+		if b.ctx.Err() != nil {
+			return b.ctx.Err()
+		}
+		if errors.Is(err, context.Canceled) {
+			continue // Woken up to run new commands
+		}
+		if err != nil {
+			return err
+		}
+
+		data, err := b.notificationData(notification.Payload)
+		if err != nil {
+			slog.Error("could not load large notification payload", "topic", notification.Channel, "error", err)
+			b.queue.push(BrokerMessage{Interrupted: true})
+			continue
+		}
+		b.queue.push(BrokerMessage{Topic: notification.Channel, Data: data})
+	}
+}
+
+// notificationData returns the published data of a notification, loading it
+// from its NotificationPayload row when the payload is a reference
+func (b *PostgresBroker) notificationData(payload string) ([]byte, error) {
+	ref, ok := strings.CutPrefix(payload, notificationRefPrefix)
+	if !ok {
+		return []byte(payload), nil
+	}
+	id, err := strconv.ParseUint(ref, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	var row NotificationPayload
+	if err := database.DB.WithContext(b.ctx).First(&row, id).Error; err != nil {
+		return nil, err
+	}
+	return []byte(row.Data), nil
+}
@@ -0,0 +1,41 @@
+package chat
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ServePresence handles GET /rooms/{id}/presence requests, returning the users
+// currently online in the room. Only members of the room can read its roster.
+func ServePresence(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if !allowCORS(w, r, http.MethodGet) {
+		return
+	}
+
+	_, roomID, ok := authorize(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PresencePayload{Users: hub.Roster(roomID)})
+}
+
+// allowCORS sets the CORS headers of the REST endpoints served next to the
+// WebSocket and answers preflight requests. It returns false, with the response
+// written, unless the request uses the given method.
+func allowCORS(w http.ResponseWriter, r *http.Request, method string) bool {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID")
+	w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
+	w.Header().Set("Access-Control-Allow-Methods", method+", OPTIONS")
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return false
+	}
+	if r.Method != method {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
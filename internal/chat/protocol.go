@@ -0,0 +1,361 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go-chat-live/internal/attachment"
+)
+
+// ProtocolVersion is the current version of the WebSocket wire protocol.
+const ProtocolVersion = 1
+
+// maxClientMessageIDLength caps the client message IDs accepted with messages
+const maxClientMessageIDLength = 64
+
+// Envelope types exchanged over the WebSocket connection
+const (
+	TypeMessage         = "message"          // Chat text sent to a room
+	TypeDirect          = "direct_message"   // Private message between two users
+	TypeUserJoined      = "user_joined"      // A user came online in the room
+	TypeUserLeft        = "user_left"        // A user's last connection to the room closed
+	TypePresence        = "presence"         // Roster of online users, sent to a client when it joins
+	TypeTypingStart     = "typing_start"     // A user started typing in the room
+	TypeTypingStop      = "typing_stop"      // A user stopped typing, sent a message or disconnected
+	TypeTyping          = "typing"           // Deprecated alias of typing_start accepted from older clients
+	TypeThreadReply     = "thread_reply"     // Reply posted in the thread of a room message
+	TypeReactionAdd     = "reaction_add"     // Client reacts to a message
+	TypeReactionRemove  = "reaction_remove"  // Client removes its reaction
+	TypeReactionAdded   = "reaction_added"   // A user reacted to a message of the room
+	TypeReactionRemoved = "reaction_removed" // A user removed a reaction
+	TypeMessageEdit     = "message_edit"     // Client edits a message it may modify
+	TypeMessageDelete   = "message_delete"   // Client deletes a message it may modify
+	TypeMessageUpdated  = "message_updated"  // A message of the room was edited
+	TypeMessageDeleted  = "message_deleted"  // A message of the room was deleted
+	TypeRead            = "read"             // Client read the room up to a message
+	TypeReadReceipt     = "read_receipt"     // A user read the room up to a message
+	TypeResume          = "resume"           // Client asks for the room messages missed since a message
+	TypeResyncRequired  = "resync_required"  // Messages were missed and cannot be replayed; reload the history
+	TypeAck             = "ack"              // Server accepted a client frame
+	TypeNack            = "nack"             // Server could not persist a message; the client may retry it
+	TypeError           = "error"            // Server rejected a client frame
+)
+
+// Error codes sent in error envelopes
+const (
+	ErrCodeInvalidFrame       = "invalid_frame"       // Frame is not a valid JSON envelope
+	ErrCodeUnsupportedVersion = "unsupported_version" // Envelope version is not supported
+	ErrCodeUnknownType        = "unknown_type"        // Type cannot be sent by clients
+	ErrCodeInvalidPayload     = "invalid_payload"     // Payload does not match the type
+	ErrCodeWrongRoom          = "wrong_room"          // Envelope addresses a room other than the connection's
+	ErrCodeNotFound           = "not_found"           // Referenced message does not exist in the room
+	ErrCodeForbidden          = "forbidden"           // User may not perform the action
+	ErrCodeInternal           = "internal_error"      // Server failed to process the frame
+	ErrCodeRateLimited        = "rate_limited"        // Client is sending messages faster than allowed
+)
+
+// Envelope is the versioned JSON frame used for every WebSocket message in both directions.
+type Envelope struct {
+	Version int             `json:"v"`                 // Protocol version
+	Type    string          `json:"type"`              // Message type, one of the Type constants
+	ID      string          `json:"id,omitempty"`      // Envelope ID, echoed back in acks and errors
+	Room    string          `json:"room,omitempty"`    // Room the envelope refers to
+	Payload json.RawMessage `json:"payload,omitempty"` // Type specific payload
+	TS      time.Time       `json:"ts"`                // Time the envelope was created
+}
+
+// ChatMessage is the payload of message and message_updated envelopes sent to the client.
+type ChatMessage struct {
+	ID         uint       `json:"id"`                  // Persisted message ID
+	Content    string     `json:"content"`             // Message content
+	UserID     uint       `json:"userId"`              // Sender user ID
+	UserName   string     `json:"userName"`            // User's name
+	CreatedAt  time.Time  `json:"createdAt"`           // Message timestamp
+	EditedAt   *time.Time `json:"editedAt,omitempty"`  // Latest edit timestamp, if edited
+	ReplyToID  *uint      `json:"replyToId,omitempty"` // Quoted message, if any
+	ThreadID   *uint      `json:"threadId,omitempty"`  // Thread root, for thread replies
+	ReplyCount int        `json:"replyCount"`          // Number of thread replies, for root messages
+
+	ClientMessageID string `json:"clientMessageId,omitempty"` // ID chosen by the sender's client, lets its other devices match the message
+
+	Attachments []attachment.Attachment `json:"attachments,omitempty"` // Files sent with the message
+}
+
+// NewChatMessage builds the client payload of a persisted room message.
+func NewChatMessage(msg *StoredMessage) ChatMessage {
+	return ChatMessage{
+		ID:         msg.ID,
+		Content:    msg.Content,
+		UserID:     msg.UserID,
+		UserName:   msg.UserName,
+		CreatedAt:  msg.CreatedAt,
+		EditedAt:   msg.EditedAt,
+		ReplyToID:  msg.ReplyToID,
+		ThreadID:   msg.ThreadID,
+		ReplyCount: msg.ReplyCount,
+
+		ClientMessageID: clientMessageID(msg.ClientMessageID),
+
+		Attachments: msg.Attachments,
+	}
+}
+
+// clientMessageID returns the client message ID of a stored message, empty when it has none
+func clientMessageID(id *string) string {
+	if id == nil {
+		return ""
+	}
+	return *id
+}
+
+// ThreadReplyPayload is the payload of thread_reply envelopes sent to the client.
+type ThreadReplyPayload struct {
+	Message    ChatMessage `json:"message"`    // The new reply
+	ReplyCount int         `json:"replyCount"` // Updated reply count of the thread root
+}
+
+// ReactionPayload is the payload of reaction_added and reaction_removed envelopes.
+type ReactionPayload struct {
+	MessageID uint   `json:"messageId"` // Message reacted to
+	Emoji     string `json:"emoji"`     // Reaction emoji
+	UserID    uint   `json:"userId"`    // User that reacted
+	UserName  string `json:"userName"`  // User's name
+}
+
+// MessageDeletedPayload is the payload of message_deleted envelopes.
+type MessageDeletedPayload struct {
+	MessageID uint `json:"messageId"` // Deleted message ID
+	DeletedBy uint `json:"deletedBy"` // User that deleted it: the author or a moderator
+}
+
+// ReadReceiptPayload is the payload of read_receipt envelopes.
+type ReadReceiptPayload struct {
+	UserID    uint   `json:"userId"`    // User that read the room
+	UserName  string `json:"userName"`  // User's name
+	MessageID uint   `json:"messageId"` // Latest message read
+}
+
+// ResyncPayload is the payload of resync_required envelopes.
+type ResyncPayload struct {
+	Reason      string `json:"reason"`      // Why the client must resync
+	Since       uint   `json:"since"`       // Last message the client had seen; zero when unknown
+	ReplayLimit int    `json:"replayLimit"` // Most messages the server replays on reconnect
+}
+
+// Reasons sent in resync_required envelopes
+const (
+	ResyncTooManyMissed       = "too_many_missed"      // The gap since the resume point is larger than the replay window
+	ResyncDeliveryInterrupted = "delivery_interrupted" // The server lost its broker connection and may have missed events
+	ResyncUnavailable         = "unavailable"          // The missed messages could not be loaded; retry or reload the history
+)
+
+// UserEvent is the payload of user_joined, user_left, typing_start and typing_stop envelopes.
+type UserEvent struct {
+	UserID   uint   `json:"userId"`   // User that triggered the event
+	UserName string `json:"userName"` // User's name
+}
+
+// PresencePayload is the payload of presence envelopes.
+type PresencePayload struct {
+	Users []UserEvent `json:"users"` // Users with at least one open connection to the room
+}
+
+// AckPayload is the payload of ack envelopes, confirming a client frame.
+// Acks of message, thread_reply and direct_message frames echo the client
+// message ID with the persisted ID and timestamp.
+type AckPayload struct {
+	MessageID       uint       `json:"messageId,omitempty"`       // Persisted message ID, when the frame created one
+	ClientMessageID string     `json:"clientMessageId,omitempty"` // Client message ID sent with the frame
+	CreatedAt       *time.Time `json:"createdAt,omitempty"`       // Timestamp assigned to the persisted message
+	Duplicate       bool       `json:"duplicate,omitempty"`       // The client message ID was already stored; nothing was sent again
+}
+
+// NackPayload is the payload of nack envelopes, sent when a message, thread_reply
+// or direct_message frame could not be persisted. Nothing was delivered to the
+// room; when Retryable is set the client can send the same frame again.
+type NackPayload struct {
+	ClientMessageID string `json:"clientMessageId,omitempty"` // Client message ID sent with the frame
+	Code            string `json:"code"`                      // Machine readable error code
+	Reason          string `json:"reason"`                    // Human readable description
+	Retryable       bool   `json:"retryable"`                 // The failure is temporary and retrying the same frame is safe
+}
+
+// ErrorPayload is the payload of error envelopes.
+type ErrorPayload struct {
+	Code         string `json:"code"`                   // Machine readable error code
+	Message      string `json:"message"`                // Human readable description
+	RetryAfterMs int64  `json:"retryAfterMs,omitempty"` // For rate_limited errors, how long to wait before sending again
+}
+
+// sendMessageRequest is the payload clients send in message envelopes.
+type sendMessageRequest struct {
+	Content       string `json:"content"`
+	ReplyToID     uint   `json:"replyToId,omitempty"`     // Message quoted in the main room
+	AttachmentIDs []uint `json:"attachmentIds,omitempty"` // Files uploaded to the room beforehand
+
+	ClientMessageID string `json:"clientMessageId,omitempty"` // Sender chosen ID; retries with the same ID are stored once
+}
+
+// threadReplyRequest is the payload clients send in thread_reply envelopes.
+type threadReplyRequest struct {
+	ReplyToID uint   `json:"replyToId"` // Root message or reply being answered
+	Content   string `json:"content"`
+
+	ClientMessageID string `json:"clientMessageId,omitempty"` // Sender chosen ID; retries with the same ID are stored once
+}
+
+// sendDirectRequest is the payload clients send in direct_message envelopes.
+type sendDirectRequest struct {
+	ToUserID uint   `json:"toUserId"`
+	Content  string `json:"content"`
+
+	ClientMessageID string `json:"clientMessageId,omitempty"` // Sender chosen ID; retries with the same ID are stored once
+}
+
+// editMessageRequest is the payload clients send in message_edit envelopes.
+type editMessageRequest struct {
+	MessageID uint   `json:"messageId"`
+	Content   string `json:"content"`
+}
+
+// reactionRequest is the payload clients send in reaction_add and reaction_remove envelopes.
+type reactionRequest struct {
+	MessageID uint   `json:"messageId"`
+	Emoji     string `json:"emoji"`
+}
+
+// deleteMessageRequest is the payload clients send in message_delete envelopes.
+type deleteMessageRequest struct {
+	MessageID uint `json:"messageId"`
+}
+
+// readRequest is the payload clients send in read envelopes.
+type readRequest struct {
+	MessageID uint `json:"messageId"` // Latest message the user has seen
+}
+
+// resumeRequest is the payload clients send in resume envelopes.
+type resumeRequest struct {
+	Since uint `json:"since"` // Latest room message the client received before reconnecting
+}
+
+// ProtocolError describes why a client frame was rejected.
+type ProtocolError struct {
+	Code    string
+	Message string
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// errClientMessageIDTooLong rejects messages with a client message ID longer than the stored column
+var errClientMessageIDTooLong = &ProtocolError{
+	Code:    ErrCodeInvalidPayload,
+	Message: fmt.Sprintf("clientMessageId can have at most %d characters", maxClientMessageIDLength),
+}
+
+// NewEnvelope builds a server envelope with the payload marshaled to JSON.
+func NewEnvelope(msgType, id, room string, payload interface{}) (*Envelope, error) {
+	env := &Envelope{
+		Version: ProtocolVersion,
+		Type:    msgType,
+		ID:      id,
+		Room:    room,
+		TS:      time.Now().UTC(),
+	}
+
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		env.Payload = data
+	}
+
+	return env, nil
+}
+
+// DecodeEnvelope parses and validates a frame received from a client connected to roomID.
+// A missing version is treated as the current one; an empty room defaults to roomID.
+func DecodeEnvelope(data []byte, roomID string) (*Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, &ProtocolError{Code: ErrCodeInvalidFrame, Message: "frame must be a JSON envelope"}
+	}
+
+	if env.Version == 0 {
+		env.Version = ProtocolVersion
+	}
+	if env.Version != ProtocolVersion {
+		return &env, &ProtocolError{Code: ErrCodeUnsupportedVersion, Message: fmt.Sprintf("supported version is %d", ProtocolVersion)}
+	}
+
+	if env.Room == "" {
+		env.Room = roomID
+	}
+	if env.Room != roomID {
+		return &env, &ProtocolError{Code: ErrCodeWrongRoom, Message: "envelope room does not match the connection room"}
+	}
+
+	switch env.Type {
+	case TypeMessage:
+		var req sendMessageRequest
+		if err := json.Unmarshal(env.Payload, &req); err != nil || (req.Content == "" && len(req.AttachmentIDs) == 0) {
+			return &env, &ProtocolError{Code: ErrCodeInvalidPayload, Message: "message payload requires content or attachmentIds"}
+		}
+		if len(req.AttachmentIDs) > attachment.MaxPerMessage {
+			return &env, &ProtocolError{Code: ErrCodeInvalidPayload, Message: fmt.Sprintf("a message can have at most %d attachments", attachment.MaxPerMessage)}
+		}
+		if len(req.ClientMessageID) > maxClientMessageIDLength {
+			return &env, errClientMessageIDTooLong
+		}
+	case TypeDirect:
+		var req sendDirectRequest
+		if err := json.Unmarshal(env.Payload, &req); err != nil || req.ToUserID == 0 || req.Content == "" {
+			return &env, &ProtocolError{Code: ErrCodeInvalidPayload, Message: "direct_message payload requires toUserId and content"}
+		}
+		if len(req.ClientMessageID) > maxClientMessageIDLength {
+			return &env, errClientMessageIDTooLong
+		}
+	case TypeThreadReply:
+		var req threadReplyRequest
+		if err := json.Unmarshal(env.Payload, &req); err != nil || req.ReplyToID == 0 || req.Content == "" {
+			return &env, &ProtocolError{Code: ErrCodeInvalidPayload, Message: "thread_reply payload requires replyToId and content"}
+		}
+		if len(req.ClientMessageID) > maxClientMessageIDLength {
+			return &env, errClientMessageIDTooLong
+		}
+	case TypeReactionAdd, TypeReactionRemove:
+		var req reactionRequest
+		if err := json.Unmarshal(env.Payload, &req); err != nil || req.MessageID == 0 || req.Emoji == "" {
+			return &env, &ProtocolError{Code: ErrCodeInvalidPayload, Message: env.Type + " payload requires messageId and emoji"}
+		}
+	case TypeMessageEdit:
+		var req editMessageRequest
+		if err := json.Unmarshal(env.Payload, &req); err != nil || req.MessageID == 0 || req.Content == "" {
+			return &env, &ProtocolError{Code: ErrCodeInvalidPayload, Message: "message_edit payload requires messageId and content"}
+		}
+	case TypeMessageDelete:
+		var req deleteMessageRequest
+		if err := json.Unmarshal(env.Payload, &req); err != nil || req.MessageID == 0 {
+			return &env, &ProtocolError{Code: ErrCodeInvalidPayload, Message: "message_delete payload requires messageId"}
+		}
+	case TypeRead:
+		var req readRequest
+		if err := json.Unmarshal(env.Payload, &req); err != nil || req.MessageID == 0 {
+			return &env, &ProtocolError{Code: ErrCodeInvalidPayload, Message: "read payload requires messageId"}
+		}
+	case TypeResume:
+		var req resumeRequest
+		if err := json.Unmarshal(env.Payload, &req); err != nil || req.Since == 0 {
+			return &env, &ProtocolError{Code: ErrCodeInvalidPayload, Message: "resume payload requires since"}
+		}
+	case TypeTyping, TypeTypingStart, TypeTypingStop:
+	default:
+		return &env, &ProtocolError{Code: ErrCodeUnknownType, Message: fmt.Sprintf("type %q cannot be sent by clients", env.Type)}
+	}
+
+	return &env, nil
+}
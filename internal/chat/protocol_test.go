@@ -0,0 +1,69 @@
+package chat
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func expectProtocolError(t *testing.T, err error, code string) {
+	t.Helper()
+	var protoErr *ProtocolError
+	if !errors.As(err, &protoErr) {
+		t.Fatalf("expected protocol error %q, got %v", code, err)
+	}
+	if protoErr.Code != code {
+		t.Errorf("expected error code %q, got %q", code, protoErr.Code)
+	}
+}
+
+func TestDecodeEnvelope_ValidMessage(t *testing.T) {
+	env, err := DecodeEnvelope([]byte(`{"type":"message","id":"c1","payload":{"content":"hi"}}`), "1")
+	if err != nil {
+		t.Fatalf("expected nil, but got error: %v", err)
+	}
+
+	if env.Version != ProtocolVersion || env.Room != "1" || env.ID != "c1" {
+		t.Errorf("expected defaults to be filled in, got %+v", env)
+	}
+}
+
+func TestDecodeEnvelope_RawText(t *testing.T) {
+	_, err := DecodeEnvelope([]byte("hello"), "1")
+	expectProtocolError(t, err, ErrCodeInvalidFrame)
+}
+
+func TestDecodeEnvelope_UnsupportedVersion(t *testing.T) {
+	_, err := DecodeEnvelope([]byte(`{"v":2,"type":"message","payload":{"content":"hi"}}`), "1")
+	expectProtocolError(t, err, ErrCodeUnsupportedVersion)
+}
+
+func TestDecodeEnvelope_WrongRoom(t *testing.T) {
+	_, err := DecodeEnvelope([]byte(`{"type":"message","room":"2","payload":{"content":"hi"}}`), "1")
+	expectProtocolError(t, err, ErrCodeWrongRoom)
+}
+
+func TestDecodeEnvelope_ServerOnlyType(t *testing.T) {
+	env, err := DecodeEnvelope([]byte(`{"type":"ack","id":"c9"}`), "1")
+	expectProtocolError(t, err, ErrCodeUnknownType)
+
+	if env == nil || env.ID != "c9" {
+		t.Error("expected envelope ID to be returned for error correlation")
+	}
+}
+
+func TestDecodeEnvelope_EmptyContent(t *testing.T) {
+	_, err := DecodeEnvelope([]byte(`{"type":"message","payload":{"content":""}}`), "1")
+	expectProtocolError(t, err, ErrCodeInvalidPayload)
+}
+
+func TestDecodeEnvelope_EditRequiresMessageID(t *testing.T) {
+	_, err := DecodeEnvelope([]byte(`{"v":1,"type":"message_edit","payload":{"content":"x"}}`), "1")
+	expectProtocolError(t, err, ErrCodeInvalidPayload)
+}
+
+func TestDecodeEnvelope_ClientMessageIDTooLong(t *testing.T) {
+	id := strings.Repeat("x", maxClientMessageIDLength+1)
+	_, err := DecodeEnvelope([]byte(`{"type":"message","payload":{"content":"hi","clientMessageId":"`+id+`"}}`), "1")
+	expectProtocolError(t, err, ErrCodeInvalidPayload)
+}
@@ -0,0 +1,71 @@
+package chat
+
+import (
+	"strconv"
+	"time"
+
+	"go-chat-live/internal/ratelimit"
+
+	"github.com/gorilla/websocket"
+)
+
+// rateState is the message rate limit state of a connection. Only readPump
+// touches it, so it needs no locking.
+type rateState struct {
+	bucket     *ratelimit.Bucket // Per-connection token bucket, nil when disabled
+	violations int               // Consecutive frames rejected for exceeding the limits
+}
+
+// newRateState creates the rate limit state of a new connection
+func newRateState(config HubConfig) rateState {
+	if config.MessageRate <= 0 {
+		return rateState{}
+	}
+	return rateState{bucket: ratelimit.NewBucket(float64(config.MessageRate), max(1, config.MessageBurst))}
+}
+
+// isRateLimited reports whether frames of a type persist, broadcast or query
+// and so count against the message rate limits. Typing frames are exempt: they
+// are relayed at most once per TypingThrottle.
+func isRateLimited(msgType string) bool {
+	switch msgType {
+	case TypeMessage, TypeDirect, TypeThreadReply,
+		TypeReactionAdd, TypeReactionRemove,
+		TypeMessageEdit, TypeMessageDelete,
+		TypeRead, TypeResume:
+		return true
+	}
+	return false
+}
+
+// allowMessage applies the per-connection and per-user message limits to a
+// frame. Rejected frames get a rate_limited error; once the client keeps
+// sending through MaxRateViolations rejections in a row the connection is
+// closed with a policy violation and disconnect is true.
+func (c *Client) allowMessage(hub *Hub, envelopeID string) (allowed, disconnect bool) {
+	allowed, wait := true, time.Duration(0)
+	if c.rate.bucket != nil {
+		allowed, wait = c.rate.bucket.Allow(time.Now())
+	}
+	if allowed && hub.userLimit != nil {
+		allowed, wait = hub.userLimit.Allow(strconv.FormatUint(uint64(c.UserID), 10))
+	}
+	if allowed {
+		c.rate.violations = 0
+		return true, false
+	}
+
+	c.rate.violations++
+	if hub.config.MaxRateViolations > 0 && c.rate.violations >= hub.config.MaxRateViolations {
+		c.logger().Warn("disconnecting client: rate limit exceeded", "user_id", c.UserID)
+		c.setCloseReason(websocket.ClosePolicyViolation, "rate limit exceeded")
+		return false, true
+	}
+
+	c.sendEnvelope(TypeError, envelopeID, ErrorPayload{
+		Code:         ErrCodeRateLimited,
+		Message:      "sending frames too fast",
+		RetryAfterMs: max(1, wait.Milliseconds()),
+	})
+	return false, false
+}
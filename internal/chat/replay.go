@@ -0,0 +1,128 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// replayState holds live frames delivered while a client catches up, so the
+// missed messages reach it first and in order. Guarded by Client.mu.
+type replayState struct {
+	active bool     // Whether live frames are being held
+	held   [][]byte // Live frames waiting for the replay to finish
+}
+
+// holdFrame keeps a live frame until the replay finishes. It returns false,
+// like a full Send queue, when more frames are held than the queue could take.
+// Callers must hold c.mu.
+func (c *Client) holdFrame(data []byte) bool {
+	if len(c.replay.held) >= cap(c.Send) {
+		return false
+	}
+	c.replay.held = append(c.replay.held, data)
+	return true
+}
+
+// holdLive starts holding live frames for the client.
+func (c *Client) holdLive() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.replay.active = true
+}
+
+// releaseLive queues the held live frames after the replay and resumes live
+// delivery. Messages up to lastReplayed were already replayed and are skipped.
+func (c *Client) releaseLive(lastReplayed uint) {
+	c.mu.Lock()
+	held := c.replay.held
+	c.replay = replayState{}
+	queued := true
+	for _, data := range held {
+		if c.closed {
+			break
+		}
+		if replayedMessage(data, lastReplayed) {
+			continue
+		}
+		select {
+		case c.Send <- data:
+		default:
+			queued = false
+		}
+	}
+	c.mu.Unlock()
+
+	if !queued && c.hub != nil {
+		c.hub.slowConsumer(c)
+	}
+}
+
+// replayedMessage reports whether a live frame is a room message with an ID up to lastReplayed
+func replayedMessage(data []byte, lastReplayed uint) bool {
+	if lastReplayed == 0 {
+		return false
+	}
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil || env.Type != TypeMessage {
+		return false
+	}
+	var msg ChatMessage
+	if err := json.Unmarshal(env.Payload, &msg); err != nil {
+		return false
+	}
+	return msg.ID <= lastReplayed
+}
+
+// replay sends the client the room messages it missed since the given message,
+// oldest first, before any live frame that arrives meanwhile. When the gap is
+// larger than the replay window the client gets resync_required instead and
+// should reload the history through the REST API; when the messages cannot be
+// loaded the reason is unavailable rather than too_many_missed.
+func (h *Hub) replay(c *Client, since uint) {
+	c.holdLive()
+
+	var lastReplayed uint
+	messages, err := MissedMessages(c.RoomID, since, h.config.ReplayLimit)
+	if errors.Is(err, ErrResyncRequired) {
+		c.sendReplayed(TypeResyncRequired, ResyncPayload{Reason: ResyncTooManyMissed, Since: since, ReplayLimit: h.config.ReplayLimit})
+	} else if err != nil {
+		c.logger().Error("could not load missed messages", "since", since, "error", err)
+		c.sendReplayed(TypeResyncRequired, ResyncPayload{Reason: ResyncUnavailable, Since: since, ReplayLimit: h.config.ReplayLimit})
+	} else {
+		for i := range messages {
+			c.sendReplayed(TypeMessage, NewChatMessage(&messages[i]))
+			lastReplayed = messages[i].ID
+		}
+	}
+
+	c.releaseLive(lastReplayed)
+}
+
+// sendReplayed queues a replayed frame ahead of the held live frames
+func (c *Client) sendReplayed(msgType string, payload interface{}) {
+	env, err := NewEnvelope(msgType, "", c.RoomID, payload)
+	if err != nil {
+		c.logger().Error("could not build envelope", "type", msgType, "error", err)
+		return
+	}
+	data, err := json.Marshal(env)
+	if err != nil {
+		c.logger().Error("could not encode envelope", "type", msgType, "error", err)
+		return
+	}
+
+	c.mu.Lock()
+	queued := true
+	if !c.closed {
+		select {
+		case c.Send <- data:
+		default:
+			queued = false
+		}
+	}
+	c.mu.Unlock()
+
+	if !queued && c.hub != nil {
+		c.hub.slowConsumer(c)
+	}
+}
@@ -0,0 +1,505 @@
+package chat
+
+import (
+	"errors"
+	"time"
+
+	"go-chat-live/internal/database"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MessageRepository defines the interface for chat message data access operations.
+// This interface follows the Repository pattern to abstract database operations.
+type MessageRepository interface {
+	Create(msg *StoredMessage, then func(tx *gorm.DB) error) error               // Persists a new message and runs then in its transaction, ErrDuplicateMessage if its client ID is taken
+	FindByClientID(userID uint, clientID string) (*StoredMessage, error)         // Message a user sent with a client message ID, nil if none
+	FindByRoom(roomID string, beforeID uint, limit int) ([]StoredMessage, error) // Page of main room messages older than beforeID
+	FindSince(roomID string, afterID uint, limit int) ([]StoredMessage, error)   // Main room messages newer than afterID, oldest first
+	CreateReply(msg *StoredMessage) error                                        // Persists a thread reply and bumps its root, ErrDuplicateMessage if its client ID is taken
+	FindThread(rootID, beforeID uint, limit int) ([]StoredMessage, error)        // Page of thread replies older than beforeID
+	FindById(id uint) (*StoredMessage, error)                                    // Finds a message that was not deleted
+	Update(msg *StoredMessage, edit *MessageEdit) error                          // Saves new content and records the previous one
+	Delete(id uint) error                                                        // Soft deletes a message, updating its thread root
+	FindEdits(messageID uint) ([]MessageEdit, error)                             // Edit history of a message, oldest first
+}
+
+// messageRepositoryImpl implements MessageRepository using GORM ORM.
+type messageRepositoryImpl struct{}
+
+// NewMessageRepository creates a new instance of MessageRepository.
+func NewMessageRepository() MessageRepository {
+	return &messageRepositoryImpl{}
+}
+
+// Create inserts a new message into the database and runs then in the same
+// transaction; an error from then rolls the insert back. A message whose client
+// message ID the sender already used is not inserted and ErrDuplicateMessage is returned.
+func (r *messageRepositoryImpl) Create(msg *StoredMessage, then func(tx *gorm.DB) error) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := createMessage(tx, msg); err != nil {
+			return err
+		}
+		return then(tx)
+	})
+}
+
+// createMessage inserts msg, reporting a (user_id, client_message_id) conflict as ErrDuplicateMessage
+func createMessage(tx *gorm.DB, msg *StoredMessage) error {
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(msg)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrDuplicateMessage
+	}
+	return nil
+}
+
+// FindByClientID retrieves the message a user sent with a client message ID,
+// returning nil when there is none. Deleted messages are included, as their ID stays taken.
+func (r *messageRepositoryImpl) FindByClientID(userID uint, clientID string) (*StoredMessage, error) {
+	var msg StoredMessage
+	err := database.DB.Unscoped().Where("user_id = ? AND client_message_id = ?", userID, clientID).First(&msg).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// FindByRoom retrieves up to limit messages of a room, newest first. Thread
+// replies are left out. When beforeID is non-zero only messages with a smaller ID are returned.
+func (r *messageRepositoryImpl) FindByRoom(roomID string, beforeID uint, limit int) ([]StoredMessage, error) {
+	var messages []StoredMessage
+	query := database.DB.Where("room_id = ? AND thread_id IS NULL", roomID)
+	if beforeID > 0 {
+		query = query.Where("id < ?", beforeID)
+	}
+	err := query.Order("id DESC").Limit(limit).Find(&messages).Error
+	return messages, err
+}
+
+// FindSince retrieves up to limit main room messages with an ID greater than
+// afterID, oldest first. Thread replies are left out.
+func (r *messageRepositoryImpl) FindSince(roomID string, afterID uint, limit int) ([]StoredMessage, error) {
+	var messages []StoredMessage
+	err := database.DB.Where("room_id = ? AND thread_id IS NULL AND id > ?", roomID, afterID).
+		Order("id ASC").Limit(limit).Find(&messages).Error
+	return messages, err
+}
+
+// CreateReply inserts a thread reply and updates the reply count and last reply
+// time of its root. A duplicate client message ID leaves the root untouched.
+func (r *messageRepositoryImpl) CreateReply(msg *StoredMessage) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := createMessage(tx, msg); err != nil {
+			return err
+		}
+		return tx.Model(&StoredMessage{}).
+			Where("id = ?", *msg.ThreadID).
+			Updates(map[string]interface{}{
+				"reply_count":   gorm.Expr("reply_count + 1"),
+				"last_reply_at": msg.CreatedAt,
+			}).Error
+	})
+}
+
+// FindThread retrieves up to limit replies of a thread, newest first.
+// When beforeID is non-zero only replies with a smaller ID are returned.
+func (r *messageRepositoryImpl) FindThread(rootID, beforeID uint, limit int) ([]StoredMessage, error) {
+	var messages []StoredMessage
+	query := database.DB.Where("thread_id = ?", rootID)
+	if beforeID > 0 {
+		query = query.Where("id < ?", beforeID)
+	}
+	err := query.Order("id DESC").Limit(limit).Find(&messages).Error
+	return messages, err
+}
+
+// FindById retrieves a message by its ID, ignoring deleted messages.
+func (r *messageRepositoryImpl) FindById(id uint) (*StoredMessage, error) {
+	var msg StoredMessage
+	err := database.DB.First(&msg, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// Update saves the edited content of a message and its previous content in a single transaction.
+func (r *messageRepositoryImpl) Update(msg *StoredMessage, edit *MessageEdit) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(edit).Error; err != nil {
+			return err
+		}
+		return tx.Model(msg).Updates(map[string]interface{}{
+			"content":   msg.Content,
+			"edited_at": msg.EditedAt,
+		}).Error
+	})
+}
+
+// Delete soft deletes a message, hiding it from history. Deleting a thread
+// reply decrements the reply count of its root.
+func (r *messageRepositoryImpl) Delete(id uint) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		var msg StoredMessage
+		if err := tx.First(&msg, id).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&msg).Error; err != nil {
+			return err
+		}
+		if msg.ThreadID == nil {
+			return nil
+		}
+		return tx.Model(&StoredMessage{}).
+			Where("id = ? AND reply_count > 0", *msg.ThreadID).
+			Update("reply_count", gorm.Expr("reply_count - 1")).Error
+	})
+}
+
+// FindEdits retrieves the edit history of a message, oldest first.
+func (r *messageRepositoryImpl) FindEdits(messageID uint) ([]MessageEdit, error) {
+	var edits []MessageEdit
+	err := database.DB.Where("message_id = ?", messageID).Order("id ASC").Find(&edits).Error
+	return edits, err
+}
+
+// ReactionRepository defines the interface for message reaction data access operations.
+type ReactionRepository interface {
+	Add(reaction *Reaction) (bool, error)                      // Stores a reaction, reporting false if it already existed
+	Remove(messageID, userID uint, emoji string) (bool, error) // Deletes a reaction, reporting false if it did not exist
+	FindByMessages(messageIDs []uint) ([]Reaction, error)      // Reactions to the given messages, oldest first
+}
+
+// reactionRepositoryImpl implements ReactionRepository using GORM ORM.
+type reactionRepositoryImpl struct{}
+
+// NewReactionRepository creates a new instance of ReactionRepository.
+func NewReactionRepository() ReactionRepository {
+	return &reactionRepositoryImpl{}
+}
+
+// Add inserts a reaction, ignoring it when the user already reacted with the same emoji.
+func (r *reactionRepositoryImpl) Add(reaction *Reaction) (bool, error) {
+	result := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(reaction)
+	return result.RowsAffected > 0, result.Error
+}
+
+// Remove deletes the reaction of a user with an emoji to a message.
+func (r *reactionRepositoryImpl) Remove(messageID, userID uint, emoji string) (bool, error) {
+	result := database.DB.
+		Where("message_id = ? AND user_id = ? AND emoji = ?", messageID, userID, emoji).
+		Delete(&Reaction{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// FindByMessages retrieves every reaction to the given messages, oldest first.
+func (r *reactionRepositoryImpl) FindByMessages(messageIDs []uint) ([]Reaction, error) {
+	var reactions []Reaction
+	if len(messageIDs) == 0 {
+		return reactions, nil
+	}
+	err := database.DB.Where("message_id IN ?", messageIDs).Order("id ASC").Find(&reactions).Error
+	return reactions, err
+}
+
+// ReadRepository defines the interface for read marker data access operations.
+type ReadRepository interface {
+	MarkRead(userID uint, roomID string, messageID uint) (bool, error)   // Moves the marker forward, reporting false if it was already there
+	FindMarkers(userID uint, roomIDs []string) ([]ReadMarker, error)     // Markers of the user in the given rooms
+	CountUnread(userID uint, roomIDs []string) (map[string]int64, error) // Unread messages per room, rooms without any are left out
+}
+
+// readRepositoryImpl implements ReadRepository using GORM ORM.
+type readRepositoryImpl struct{}
+
+// NewReadRepository creates a new instance of ReadRepository.
+func NewReadRepository() ReadRepository {
+	return &readRepositoryImpl{}
+}
+
+// MarkRead upserts the read marker of a user in a room. The marker never moves
+// backwards, so receipts arriving out of order are ignored.
+func (r *readRepositoryImpl) MarkRead(userID uint, roomID string, messageID uint) (bool, error) {
+	marker := ReadMarker{UserID: userID, RoomID: roomID, LastReadID: messageID, UpdatedAt: time.Now()}
+	result := database.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "room_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_read_id", "updated_at"}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "read_markers.last_read_id < excluded.last_read_id"},
+		}},
+	}).Create(&marker)
+	return result.RowsAffected > 0, result.Error
+}
+
+// FindMarkers retrieves the read markers of a user in the given rooms.
+func (r *readRepositoryImpl) FindMarkers(userID uint, roomIDs []string) ([]ReadMarker, error) {
+	var markers []ReadMarker
+	if len(roomIDs) == 0 {
+		return markers, nil
+	}
+	err := database.DB.Where("user_id = ? AND room_id IN ?", userID, roomIDs).Find(&markers).Error
+	return markers, err
+}
+
+// CountUnread counts, per room, the main room messages of other users newer than
+// the user's read marker. Deleted messages and thread replies are not counted.
+func (r *readRepositoryImpl) CountUnread(userID uint, roomIDs []string) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	if len(roomIDs) == 0 {
+		return counts, nil
+	}
+
+	var rows []struct {
+		RoomID string
+		Count  int64
+	}
+	err := database.DB.Model(&StoredMessage{}).
+		Select("messages.room_id, COUNT(*) AS count").
+		Joins("LEFT JOIN read_markers ON read_markers.room_id = messages.room_id AND read_markers.user_id = ?", userID).
+		Where("messages.room_id IN ? AND messages.thread_id IS NULL AND messages.user_id <> ?", roomIDs, userID).
+		Where("messages.id > COALESCE(read_markers.last_read_id, 0)").
+		Group("messages.room_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		counts[row.RoomID] = row.Count
+	}
+	return counts, nil
+}
+
+// searchConfig is the PostgreSQL text search configuration of the message index.
+// "simple" does not stem words, so searches behave the same in every language.
+const searchConfig = "simple"
+
+// Markers ts_headline puts around matches; snippets are HTML-escaped before they become <mark> tags
+const (
+	highlightStart = "\x02"
+	highlightStop  = "\x03"
+)
+
+// SearchFilter restricts a message search. Empty fields are not applied.
+type SearchFilter struct {
+	Query    string     // Search terms, in web search syntax ("quoted phrase", -excluded, or)
+	RoomIDs  []string   // Rooms to search, required
+	SenderID uint       // Only messages sent by this user
+	Before   *time.Time // Only messages sent before this time
+	After    *time.Time // Only messages sent after this time
+	BeforeID uint       // Only messages with a smaller ID, the pagination cursor
+}
+
+// SearchRepository defines the interface for full-text message search.
+type SearchRepository interface {
+	Search(filter SearchFilter, limit int) ([]SearchResult, error) // Matching messages, newest first
+}
+
+// searchRepositoryImpl implements SearchRepository with PostgreSQL full-text search.
+type searchRepositoryImpl struct{}
+
+// NewSearchRepository creates a new instance of SearchRepository.
+func NewSearchRepository() SearchRepository {
+	return &searchRepositoryImpl{}
+}
+
+// MigrateSearchIndex adds the generated tsvector column of message contents and
+// its GIN index. It is safe to run on every start.
+func MigrateSearchIndex() error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("ALTER TABLE messages ADD COLUMN IF NOT EXISTS search_vector tsvector " +
+			"GENERATED ALWAYS AS (to_tsvector('" + searchConfig + "', content)) STORED").Error
+		if err != nil {
+			return err
+		}
+		return tx.Exec("CREATE INDEX IF NOT EXISTS idx_messages_search ON messages USING GIN (search_vector)").Error
+	})
+}
+
+// Search retrieves up to limit messages matching the filter, newest first.
+// Deleted messages are left out; thread replies are included.
+func (r *searchRepositoryImpl) Search(filter SearchFilter, limit int) ([]SearchResult, error) {
+	var results []SearchResult
+	if len(filter.RoomIDs) == 0 {
+		return results, nil
+	}
+
+	tsQuery := clause.Expr{SQL: "websearch_to_tsquery('" + searchConfig + "', ?)", Vars: []interface{}{filter.Query}}
+	headline := "StartSel=" + highlightStart + ", StopSel=" + highlightStop + ", MaxFragments=2, MaxWords=20, MinWords=5, FragmentDelimiter=\" … \""
+
+	query := database.DB.Model(&StoredMessage{}).
+		Select("messages.*, ts_headline('"+searchConfig+"', messages.content, ?, ?) AS snippet", tsQuery, headline).
+		Where("messages.search_vector @@ ?", tsQuery).
+		Where("messages.room_id IN ?", filter.RoomIDs)
+	if filter.SenderID > 0 {
+		query = query.Where("messages.user_id = ?", filter.SenderID)
+	}
+	if filter.Before != nil {
+		query = query.Where("messages.created_at < ?", *filter.Before)
+	}
+	if filter.After != nil {
+		query = query.Where("messages.created_at > ?", *filter.After)
+	}
+	if filter.BeforeID > 0 {
+		query = query.Where("messages.id < ?", filter.BeforeID)
+	}
+
+	err := query.Order("messages.id DESC").Limit(limit).Scan(&results).Error
+	return results, err
+}
+
+// ConversationRepository defines the interface for direct message data access operations.
+type ConversationRepository interface {
+	FindOrCreate(userA, userB uint) (*Conversation, error)                          // Conversation between two users, created on first use
+	Find(userA, userB uint) (*Conversation, error)                                  // Existing conversation between two users
+	FindSummaries(userID uint) ([]ConversationSummary, error)                       // Conversations of a user with peer and last message, most recent first
+	CreateMessage(msg *DirectMessage) error                                         // Persists a message and bumps the conversation, ErrDuplicateMessage if its client ID is taken
+	FindMessageByClientID(senderID uint, clientID string) (*DirectMessage, error)   // Message a user sent with a client message ID, nil if none
+	FindMessages(conversationID, beforeID uint, limit int) ([]DirectMessage, error) // Page of messages older than beforeID
+}
+
+// conversationRepositoryImpl implements ConversationRepository using GORM ORM.
+type conversationRepositoryImpl struct{}
+
+// NewConversationRepository creates a new instance of ConversationRepository.
+func NewConversationRepository() ConversationRepository {
+	return &conversationRepositoryImpl{}
+}
+
+// orderedPair returns the two user IDs with the smaller one first
+func orderedPair(userA, userB uint) (uint, uint) {
+	if userA > userB {
+		return userB, userA
+	}
+	return userA, userB
+}
+
+// FindOrCreate retrieves the conversation between two users, creating it if needed.
+func (r *conversationRepositoryImpl) FindOrCreate(userA, userB uint) (*Conversation, error) {
+	low, high := orderedPair(userA, userB)
+	conversation := Conversation{UserLowID: low, UserHighID: high}
+	err := database.DB.
+		Where("user_low_id = ? AND user_high_id = ?", low, high).
+		FirstOrCreate(&conversation).Error
+	if err != nil {
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+// Find retrieves the conversation between two users, returning nil when they never talked.
+func (r *conversationRepositoryImpl) Find(userA, userB uint) (*Conversation, error) {
+	low, high := orderedPair(userA, userB)
+	var conversation Conversation
+	err := database.DB.Where("user_low_id = ? AND user_high_id = ?", low, high).First(&conversation).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+// FindSummaries retrieves every conversation the user takes part in, most recent
+// first, with the other participant's name and the latest message in a single
+// query. DISTINCT ON keeps the newest message of each of the user's conversations.
+func (r *conversationRepositoryImpl) FindSummaries(userID uint) ([]ConversationSummary, error) {
+	var rows []struct {
+		ID            uint
+		UserID        uint
+		UserName      string
+		LastMessageAt time.Time
+		Last          DirectMessage `gorm:"embedded;embeddedPrefix:last_"`
+	}
+	const otherUser = "CASE WHEN conversations.user_low_id = ? THEN conversations.user_high_id ELSE conversations.user_low_id END"
+	err := database.DB.Model(&Conversation{}).
+		Select("conversations.id, conversations.last_message_at, "+otherUser+" AS user_id, COALESCE(users.name, '') AS user_name, "+
+			"latest.id AS last_id, latest.conversation_id AS last_conversation_id, latest.sender_id AS last_sender_id, "+
+			"latest.recipient_id AS last_recipient_id, latest.sender_name AS last_sender_name, latest.content AS last_content, "+
+			"latest.created_at AS last_created_at, latest.client_message_id AS last_client_message_id", userID).
+		Joins("LEFT JOIN users ON users.id = "+otherUser, userID).
+		Joins("LEFT JOIN (SELECT DISTINCT ON (conversation_id) * FROM direct_messages "+
+			"WHERE conversation_id IN (SELECT id FROM conversations WHERE user_low_id = ? OR user_high_id = ?) "+
+			"ORDER BY conversation_id, id DESC) AS latest ON latest.conversation_id = conversations.id", userID, userID).
+		Where("conversations.user_low_id = ? OR conversations.user_high_id = ?", userID, userID).
+		Order("conversations.last_message_at DESC").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]ConversationSummary, 0, len(rows))
+	for _, row := range rows {
+		summary := ConversationSummary{
+			ID:            row.ID,
+			UserID:        row.UserID,
+			UserName:      row.UserName,
+			LastMessageAt: row.LastMessageAt,
+		}
+		if row.Last.ID != 0 {
+			last := row.Last
+			summary.LastMessage = &last
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+// CreateMessage inserts a direct message and updates the conversation's last activity.
+// A message whose client message ID the sender already used is not inserted and
+// ErrDuplicateMessage is returned.
+func (r *conversationRepositoryImpl) CreateMessage(msg *DirectMessage) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		created := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(msg)
+		if created.Error != nil {
+			return created.Error
+		}
+		if created.RowsAffected == 0 {
+			return ErrDuplicateMessage
+		}
+		result := tx.Model(&Conversation{}).
+			Where("id = ?", msg.ConversationID).
+			Update("last_message_at", msg.CreatedAt)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("conversation not found")
+		}
+		return nil
+	})
+}
+
+// FindMessageByClientID retrieves the direct message a user sent with a client
+// message ID, returning nil when there is none.
+func (r *conversationRepositoryImpl) FindMessageByClientID(senderID uint, clientID string) (*DirectMessage, error) {
+	var msg DirectMessage
+	err := database.DB.Where("sender_id = ? AND client_message_id = ?", senderID, clientID).First(&msg).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// FindMessages retrieves up to limit messages of a conversation, newest first.
+// When beforeID is non-zero only messages with a smaller ID are returned.
+func (r *conversationRepositoryImpl) FindMessages(conversationID, beforeID uint, limit int) ([]DirectMessage, error) {
+	var messages []DirectMessage
+	query := database.DB.Where("conversation_id = ?", conversationID)
+	if beforeID > 0 {
+		query = query.Where("id < ?", beforeID)
+	}
+	err := query.Order("id DESC").Limit(limit).Find(&messages).Error
+	return messages, err
+}
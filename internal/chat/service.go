@@ -0,0 +1,716 @@
+package chat
+
+import (
+	"errors"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"go-chat-live/internal/attachment"
+	"go-chat-live/internal/room"
+	"go-chat-live/internal/user"
+
+	"gorm.io/gorm"
+)
+
+// Errors returned by the message edit and delete functions
+var (
+	ErrMessageNotFound  = errors.New("message not found")
+	ErrNotAllowed       = errors.New("not allowed to modify this message")
+	ErrContentRequired  = errors.New("content is required")
+	ErrInvalidEmoji     = errors.New("invalid emoji")
+	ErrAttachment       = errors.New("attachments must be uploaded by the sender to this room and not used by another message")
+	ErrQueryRequired    = errors.New("search query is required")
+	ErrResyncRequired   = errors.New("too many missed messages to replay")
+	ErrDuplicateMessage = errors.New("a message with this client message ID was already sent")
+	ErrInvalidRecipient = errors.New("invalid recipient")
+)
+
+const (
+	// defaultHistoryLimit is the page size used when the client does not provide one
+	defaultHistoryLimit = 50
+	// maxHistoryLimit caps the page size a client can request
+	maxHistoryLimit = 100
+)
+
+// messageRepo is the global repository instance used by service functions
+var messageRepo = NewMessageRepository()
+
+// reactionRepo is the global repository instance used by reaction functions
+var reactionRepo = NewReactionRepository()
+
+// readRepo is the global repository instance used by read receipt functions
+var readRepo = NewReadRepository()
+
+// searchRepo is the global repository instance used by message search
+var searchRepo = NewSearchRepository()
+
+// joinedRooms lists the rooms a user belongs to; replaced in tests
+var joinedRooms = room.Joined
+
+// conversationRepo is the global repository instance used by direct message functions
+var conversationRepo = NewConversationRepository()
+
+// Attachment lookups used by messages; replaced in tests
+var (
+	pendingAttachments = attachment.Pending
+	linkAttachments    = attachment.Link
+	findAttachments    = attachment.ForMessages
+)
+
+// findUser resolves user data for direct messages; replaced in tests
+var findUser = user.FindById
+
+// canModerate reports whether the user may edit or delete other users' messages
+// in the room: the room owner and users with the moderator or admin role. Replaced in tests.
+var canModerate = func(roomID string, userID uint) bool {
+	if u, err := findUser(int(userID)); err == nil && (u.Role == user.RoleModerator || u.Role == user.RoleAdmin) {
+		return true
+	}
+	id, err := strconv.ParseUint(roomID, 10, 64)
+	if err != nil {
+		return false
+	}
+	return room.IsModerator(uint(id), userID)
+}
+
+// HistoryPage is a page of room messages in chronological order.
+// NextCursor holds the value to pass as "before" to load older messages,
+// and is nil when there is nothing left to load.
+type HistoryPage struct {
+	Messages   []StoredMessage `json:"messages"`   // Messages ordered from oldest to newest
+	NextCursor *uint           `json:"nextCursor"` // Cursor for the next (older) page
+}
+
+// ConversationSummary describes a conversation from the point of view of one participant.
+type ConversationSummary struct {
+	ID            uint           `json:"id"`            // Conversation ID
+	UserID        uint           `json:"userId"`        // The other participant
+	UserName      string         `json:"userName"`      // The other participant's name
+	LastMessage   *DirectMessage `json:"lastMessage"`   // Most recent message, if any
+	LastMessageAt time.Time      `json:"lastMessageAt"` // Timestamp of the most recent message
+}
+
+// RoomUnread is the read state of the user in one of its rooms.
+type RoomUnread struct {
+	RoomID      uint   `json:"roomId"`      // Room ID
+	RoomName    string `json:"roomName"`    // Room display name
+	UnreadCount int64  `json:"unreadCount"` // Messages of other users newer than LastReadID
+	LastReadID  uint   `json:"lastReadId"`  // Latest message read, zero if the user never read the room
+}
+
+// SearchPage is a page of search results, newest first. NextCursor holds the
+// value to pass as "cursor" to load older results.
+type SearchPage struct {
+	Results    []SearchResult `json:"results"`    // Matching messages ordered from newest to oldest
+	NextCursor *uint          `json:"nextCursor"` // Cursor for the next (older) page
+}
+
+// DirectHistoryPage is a page of direct messages in chronological order.
+type DirectHistoryPage struct {
+	Messages   []DirectMessage `json:"messages"`   // Messages ordered from oldest to newest
+	NextCursor *uint           `json:"nextCursor"` // Cursor for the next (older) page
+}
+
+// ThreadPage is a page of thread replies in chronological order, with the root message.
+type ThreadPage struct {
+	Root       StoredMessage   `json:"root"`       // Message that started the thread
+	Replies    []StoredMessage `json:"replies"`    // Replies ordered from oldest to newest
+	NextCursor *uint           `json:"nextCursor"` // Cursor for the next (older) page
+}
+
+// SaveMessage validates and persists a message sent to a room.
+// A message quoting another (ReplyToID) must quote a message of the same room.
+// Attachments set with AddAttachments are linked in the transaction that stores
+// the message, so a failed link leaves nothing behind for a retry to trip over.
+// When the sender already sent a message with the same ClientMessageID, nothing
+// is stored, msg is replaced by the stored message and ErrDuplicateMessage is returned.
+func SaveMessage(msg *StoredMessage) error {
+	if msg.RoomID == "" || (msg.Content == "" && len(msg.Attachments) == 0) {
+		return errors.New("room and content or attachments are required")
+	}
+	if err := Deduplicate(msg); err != nil {
+		return err
+	}
+	if msg.ReplyToID != nil {
+		quoted, err := messageRepo.FindById(*msg.ReplyToID)
+		if err != nil || quoted == nil || quoted.RoomID != msg.RoomID {
+			return ErrMessageNotFound
+		}
+	}
+
+	msg.AttachmentCount = len(msg.Attachments)
+	err := messageRepo.Create(msg, func(tx *gorm.DB) error {
+		if msg.AttachmentCount == 0 {
+			return nil
+		}
+		return linkAttachments(tx, msg.Attachments, msg.ID)
+	})
+	if errors.Is(err, ErrDuplicateMessage) {
+		return loadDuplicate(msg)
+	}
+	return err
+}
+
+// Deduplicate checks whether the sender already sent a message with the
+// ClientMessageID of msg. If so msg is replaced by the stored message and
+// ErrDuplicateMessage is returned. Messages without a client message ID are never duplicates.
+func Deduplicate(msg *StoredMessage) error {
+	if msg.ClientMessageID == nil {
+		return nil
+	}
+	existing, err := messageRepo.FindByClientID(msg.UserID, *msg.ClientMessageID)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return nil
+	}
+	*msg = *existing
+	return ErrDuplicateMessage
+}
+
+// loadDuplicate replaces msg by the message stored concurrently with the same
+// client message ID, after an insert conflicted with it
+func loadDuplicate(msg *StoredMessage) error {
+	if err := Deduplicate(msg); err != nil {
+		return err
+	}
+	return errors.New("conflicting message not found")
+}
+
+// AddAttachments loads the files referenced by a message that is about to be
+// sent. They must have been uploaded by the sender to the message's room and
+// not be attached to another message.
+func AddAttachments(msg *StoredMessage, attachmentIDs []uint) error {
+	if len(attachmentIDs) == 0 {
+		return nil
+	}
+	attachments, err := pendingAttachments(attachmentIDs, msg.UserID, msg.RoomID)
+	if errors.Is(err, attachment.ErrNotFound) || errors.Is(err, attachment.ErrTooMany) {
+		return ErrAttachment
+	}
+	if err != nil {
+		return err
+	}
+	msg.Attachments = attachments
+	return nil
+}
+
+// ReplyInThread persists msg as a thread reply to parentID. Replying to a
+// reply quotes it and keeps the message in the same thread. Returns the thread
+// root with its updated reply count. Like SaveMessage, a reply repeating a
+// ClientMessageID of the sender is not stored again and ErrDuplicateMessage is returned.
+func ReplyInThread(parentID uint, msg *StoredMessage) (*StoredMessage, error) {
+	if msg.RoomID == "" || msg.Content == "" {
+		return nil, errors.New("room and content are required")
+	}
+	if err := Deduplicate(msg); err != nil {
+		return nil, err
+	}
+
+	parent, err := messageRepo.FindById(parentID)
+	if err != nil || parent == nil || parent.RoomID != msg.RoomID {
+		return nil, ErrMessageNotFound
+	}
+
+	rootID := parent.ID
+	if parent.ThreadID != nil {
+		rootID = *parent.ThreadID
+	}
+	msg.ThreadID = &rootID
+	msg.ReplyToID = &parent.ID
+
+	if err := messageRepo.CreateReply(msg); err != nil {
+		if errors.Is(err, ErrDuplicateMessage) {
+			return nil, loadDuplicate(msg)
+		}
+		return nil, err
+	}
+
+	root, err := messageRepo.FindById(rootID)
+	if err != nil {
+		return nil, ErrMessageNotFound
+	}
+	return root, nil
+}
+
+// Thread returns a page of the replies to a root message older than the before cursor.
+// The root may itself be a reply, in which case its thread is returned.
+func Thread(messageID uint, before uint, limit int) (*ThreadPage, error) {
+	limit = normalizeLimit(limit)
+
+	root, err := messageRepo.FindById(messageID)
+	if err != nil || root == nil {
+		return nil, ErrMessageNotFound
+	}
+	if root.ThreadID != nil {
+		root, err = messageRepo.FindById(*root.ThreadID)
+		if err != nil || root == nil {
+			return nil, ErrMessageNotFound
+		}
+	}
+
+	// Fetch one extra row to know whether an older page exists
+	replies, err := messageRepo.FindThread(root.ID, before, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &ThreadPage{Root: *root, Replies: []StoredMessage{}}
+	if len(replies) > limit {
+		replies = replies[:limit]
+		cursor := replies[len(replies)-1].ID
+		page.NextCursor = &cursor
+	}
+
+	for i := len(replies) - 1; i >= 0; i-- {
+		page.Replies = append(page.Replies, replies[i])
+	}
+
+	if err := attachReactions(page.Replies); err != nil {
+		return nil, err
+	}
+	roots := []StoredMessage{page.Root}
+	if err := attachReactions(roots); err != nil {
+		return nil, err
+	}
+	if err := attachFiles(roots); err != nil {
+		return nil, err
+	}
+	page.Root = roots[0]
+	return page, nil
+}
+
+// validEmoji reports whether emoji is a short, printable reaction without spaces
+func validEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > 32 || !utf8.ValidString(emoji) {
+		return false
+	}
+	return !strings.ContainsFunc(emoji, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r)
+	})
+}
+
+// AddReaction records a reaction of the user to a message of the room. Reacting
+// twice with the same emoji is a no-op and reports added as false.
+func AddReaction(roomID string, messageID, userID uint, emoji string) (bool, error) {
+	if !validEmoji(emoji) {
+		return false, ErrInvalidEmoji
+	}
+	msg, err := messageRepo.FindById(messageID)
+	if err != nil || msg == nil || msg.RoomID != roomID {
+		return false, ErrMessageNotFound
+	}
+	return reactionRepo.Add(&Reaction{MessageID: messageID, UserID: userID, Emoji: emoji, CreatedAt: time.Now()})
+}
+
+// RemoveReaction removes a reaction of the user to a message of the room.
+// Removing a reaction that does not exist is a no-op and reports removed as false.
+func RemoveReaction(roomID string, messageID, userID uint, emoji string) (bool, error) {
+	if !validEmoji(emoji) {
+		return false, ErrInvalidEmoji
+	}
+	msg, err := messageRepo.FindById(messageID)
+	if err != nil || msg == nil || msg.RoomID != roomID {
+		return false, ErrMessageNotFound
+	}
+	return reactionRepo.Remove(messageID, userID, emoji)
+}
+
+// attachFiles fills the attachments of the messages that have any
+func attachFiles(messages []StoredMessage) error {
+	var ids []uint
+	for _, msg := range messages {
+		if msg.AttachmentCount > 0 {
+			ids = append(ids, msg.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	byMessage, err := findAttachments(ids)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		messages[i].Attachments = byMessage[messages[i].ID]
+	}
+	return nil
+}
+
+// MarkRead moves the read marker of the user in the room up to messageID.
+// Marking an older message than the current marker is a no-op and reports advanced as false.
+func MarkRead(roomID string, messageID, userID uint) (bool, error) {
+	msg, err := messageRepo.FindById(messageID)
+	if err != nil || msg == nil || msg.RoomID != roomID {
+		return false, ErrMessageNotFound
+	}
+	return readRepo.MarkRead(userID, roomID, messageID)
+}
+
+// Unread returns the unread message count of every room the user belongs to
+func Unread(userID uint) ([]RoomUnread, error) {
+	rooms, err := joinedRooms(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	roomIDs := make([]string, len(rooms))
+	for i, r := range rooms {
+		roomIDs[i] = strconv.FormatUint(uint64(r.ID), 10)
+	}
+
+	markers, err := readRepo.FindMarkers(userID, roomIDs)
+	if err != nil {
+		return nil, err
+	}
+	lastRead := make(map[string]uint, len(markers))
+	for _, marker := range markers {
+		lastRead[marker.RoomID] = marker.LastReadID
+	}
+
+	counts, err := readRepo.CountUnread(userID, roomIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]RoomUnread, len(rooms))
+	for i, r := range rooms {
+		result[i] = RoomUnread{
+			RoomID:      r.ID,
+			RoomName:    r.Name,
+			UnreadCount: counts[roomIDs[i]],
+			LastReadID:  lastRead[roomIDs[i]],
+		}
+	}
+	return result, nil
+}
+
+// attachReactions fills the aggregated reactions of each message, keeping
+// emojis in the order they were first used
+func attachReactions(messages []StoredMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, 0, len(messages))
+	for _, msg := range messages {
+		ids = append(ids, msg.ID)
+	}
+	reactions, err := reactionRepo.FindByMessages(ids)
+	if err != nil {
+		return err
+	}
+
+	summaries := make(map[uint][]ReactionSummary)
+	for _, reaction := range reactions {
+		list := summaries[reaction.MessageID]
+		found := false
+		for i := range list {
+			if list[i].Emoji == reaction.Emoji {
+				list[i].Count++
+				list[i].UserIDs = append(list[i].UserIDs, reaction.UserID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, ReactionSummary{Emoji: reaction.Emoji, Count: 1, UserIDs: []uint{reaction.UserID}})
+		}
+		summaries[reaction.MessageID] = list
+	}
+
+	for i := range messages {
+		messages[i].Reactions = summaries[messages[i].ID]
+	}
+	return nil
+}
+
+// MessageRoom returns the room a message belongs to, used to authorize access to it
+func MessageRoom(messageID uint) (string, error) {
+	msg, err := messageRepo.FindById(messageID)
+	if err != nil || msg == nil {
+		return "", ErrMessageNotFound
+	}
+	return msg.RoomID, nil
+}
+
+// History returns a page of messages of a room older than the before cursor.
+// A zero before loads the most recent messages.
+func History(roomID string, before uint, limit int) (*HistoryPage, error) {
+	limit = normalizeLimit(limit)
+
+	// Fetch one extra row to know whether an older page exists
+	messages, err := messageRepo.FindByRoom(roomID, before, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &HistoryPage{Messages: []StoredMessage{}}
+	if len(messages) > limit {
+		messages = messages[:limit]
+		cursor := messages[len(messages)-1].ID
+		page.NextCursor = &cursor
+	}
+
+	// Repository returns newest first; clients render oldest first
+	for i := len(messages) - 1; i >= 0; i-- {
+		page.Messages = append(page.Messages, messages[i])
+	}
+
+	if err := attachReactions(page.Messages); err != nil {
+		return nil, err
+	}
+	if err := attachFiles(page.Messages); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// SearchMessages runs a full-text search over the messages of the rooms the user
+// belongs to. When filter.RoomIDs is set it must only name rooms of the user.
+func SearchMessages(userID uint, filter SearchFilter, limit int) (*SearchPage, error) {
+	filter.Query = strings.TrimSpace(filter.Query)
+	if filter.Query == "" {
+		return nil, ErrQueryRequired
+	}
+	limit = normalizeLimit(limit)
+
+	rooms, err := joinedRooms(userID)
+	if err != nil {
+		return nil, err
+	}
+	joined := make(map[string]bool, len(rooms))
+	roomIDs := make([]string, len(rooms))
+	for i, r := range rooms {
+		roomIDs[i] = strconv.FormatUint(uint64(r.ID), 10)
+		joined[roomIDs[i]] = true
+	}
+	for _, roomID := range filter.RoomIDs {
+		if !joined[roomID] {
+			return nil, room.ErrNotMember
+		}
+	}
+	if len(filter.RoomIDs) == 0 {
+		filter.RoomIDs = roomIDs
+	}
+
+	// Fetch one extra row to know whether an older page exists
+	results, err := searchRepo.Search(filter, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &SearchPage{Results: []SearchResult{}}
+	if len(results) > limit {
+		results = results[:limit]
+		cursor := results[len(results)-1].ID
+		page.NextCursor = &cursor
+	}
+	for _, result := range results {
+		result.Snippet = highlightSnippet(result.Snippet)
+		page.Results = append(page.Results, result)
+	}
+	return page, nil
+}
+
+// highlightSnippet HTML-escapes a ts_headline excerpt and turns its match markers
+// into <mark> tags, so message content can never inject markup
+func highlightSnippet(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, highlightStart, "<mark>")
+	return strings.ReplaceAll(snippet, highlightStop, "</mark>")
+}
+
+// MissedMessages returns the main room messages sent after the since message,
+// oldest first, for a client catching up after a reconnect. When more than limit
+// messages were missed it returns ErrResyncRequired and the client must reload
+// the history instead.
+func MissedMessages(roomID string, since uint, limit int) ([]StoredMessage, error) {
+	if limit <= 0 {
+		return nil, ErrResyncRequired
+	}
+
+	// Fetch one extra row to know whether the gap fits the replay window
+	messages, err := messageRepo.FindSince(roomID, since, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) > limit {
+		return nil, ErrResyncRequired
+	}
+
+	if err := attachFiles(messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// findRoomMessage loads a message of the room and checks that the user may modify it
+func findRoomMessage(roomID string, messageID, userID uint) (*StoredMessage, error) {
+	msg, err := messageRepo.FindById(messageID)
+	if err != nil || msg == nil || msg.RoomID != roomID {
+		return nil, ErrMessageNotFound
+	}
+	if msg.UserID != userID && !canModerate(roomID, userID) {
+		return nil, ErrNotAllowed
+	}
+	return msg, nil
+}
+
+// EditMessage replaces the content of a room message, keeping the previous content
+// in its edit history. Only the author and room moderators may edit a message.
+func EditMessage(roomID string, messageID, userID uint, content string) (*StoredMessage, error) {
+	if content == "" {
+		return nil, ErrContentRequired
+	}
+
+	msg, err := findRoomMessage(roomID, messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Content == content {
+		return msg, nil
+	}
+
+	now := time.Now()
+	edit := &MessageEdit{
+		MessageID: msg.ID,
+		Content:   msg.Content,
+		EditedBy:  userID,
+		EditedAt:  now,
+	}
+	msg.Content = content
+	msg.EditedAt = &now
+	if err := messageRepo.Update(msg, edit); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// DeleteMessage removes a room message from history.
+// Only the author and room moderators may delete a message.
+func DeleteMessage(roomID string, messageID, userID uint) error {
+	msg, err := findRoomMessage(roomID, messageID, userID)
+	if err != nil {
+		return err
+	}
+	return messageRepo.Delete(msg.ID)
+}
+
+// MessageEdits returns the previous versions of a room message, oldest first.
+func MessageEdits(roomID string, messageID uint) ([]MessageEdit, error) {
+	msg, err := messageRepo.FindById(messageID)
+	if err != nil || msg == nil || msg.RoomID != roomID {
+		return nil, ErrMessageNotFound
+	}
+	return messageRepo.FindEdits(msg.ID)
+}
+
+// SendDirectMessage validates and persists a private message from one user to another,
+// creating their conversation on the first message. When the sender already sent a
+// message with the same non-empty clientMessageID, nothing is stored and the stored
+// message is returned along with ErrDuplicateMessage.
+func SendDirectMessage(senderID uint, senderName string, recipientID uint, content, clientMessageID string) (*DirectMessage, error) {
+	if content == "" {
+		return nil, errors.New("content is required")
+	}
+	if recipientID == 0 || recipientID == senderID {
+		return nil, ErrInvalidRecipient
+	}
+	if clientMessageID != "" {
+		existing, err := conversationRepo.FindMessageByClientID(senderID, clientMessageID)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			return existing, ErrDuplicateMessage
+		}
+	}
+	if _, err := findUser(int(recipientID)); err != nil {
+		return nil, ErrInvalidRecipient
+	}
+
+	conversation, err := conversationRepo.FindOrCreate(senderID, recipientID)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &DirectMessage{
+		ConversationID: conversation.ID,
+		SenderID:       senderID,
+		RecipientID:    recipientID,
+		SenderName:     senderName,
+		Content:        content,
+		CreatedAt:      time.Now(),
+	}
+	if clientMessageID != "" {
+		msg.ClientMessageID = &clientMessageID
+	}
+	if err := conversationRepo.CreateMessage(msg); err != nil {
+		if errors.Is(err, ErrDuplicateMessage) {
+			existing, findErr := conversationRepo.FindMessageByClientID(senderID, clientMessageID)
+			if findErr != nil || existing == nil {
+				return nil, err
+			}
+			return existing, ErrDuplicateMessage
+		}
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Conversations lists the conversations of a user with the other participant
+// and the last message, most recent first.
+func Conversations(userID uint) ([]ConversationSummary, error) {
+	return conversationRepo.FindSummaries(userID)
+}
+
+// DirectHistory returns a page of the conversation between two users older than the before cursor.
+// A conversation that does not exist yet yields an empty page.
+func DirectHistory(userID, otherID uint, before uint, limit int) (*DirectHistoryPage, error) {
+	limit = normalizeLimit(limit)
+
+	page := &DirectHistoryPage{Messages: []DirectMessage{}}
+	conversation, err := conversationRepo.Find(userID, otherID)
+	if err != nil {
+		return nil, err
+	}
+	if conversation == nil {
+		return page, nil
+	}
+
+	// Fetch one extra row to know whether an older page exists
+	messages, err := conversationRepo.FindMessages(conversation.ID, before, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(messages) > limit {
+		messages = messages[:limit]
+		cursor := messages[len(messages)-1].ID
+		page.NextCursor = &cursor
+	}
+
+	for i := len(messages) - 1; i >= 0; i-- {
+		page.Messages = append(page.Messages, messages[i])
+	}
+
+	return page, nil
+}
+
+// normalizeLimit applies the default and maximum history page sizes
+func normalizeLimit(limit int) int {
+	if limit <= 0 {
+		return defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		return maxHistoryLimit
+	}
+	return limit
+}
@@ -0,0 +1,713 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"go-chat-live/internal/attachment"
+	"go-chat-live/internal/room"
+
+	"gorm.io/gorm"
+)
+
+// Mock do repository de mensagens
+type mockMessageRepo struct {
+	messages []StoredMessage // Stored in ascending ID order
+	deleted  map[uint]bool
+	edits    []MessageEdit
+}
+
+func (m *mockMessageRepo) Create(msg *StoredMessage, then func(tx *gorm.DB) error) error {
+	if msg.ClientMessageID != nil {
+		if existing, _ := m.FindByClientID(msg.UserID, *msg.ClientMessageID); existing != nil {
+			return ErrDuplicateMessage
+		}
+	}
+	msg.ID = uint(len(m.messages) + 1)
+	m.messages = append(m.messages, *msg)
+	if then == nil {
+		return nil
+	}
+	if err := then(nil); err != nil {
+		m.messages = m.messages[:len(m.messages)-1] // Rollback
+		return err
+	}
+	return nil
+}
+
+func (m *mockMessageRepo) FindByClientID(userID uint, clientID string) (*StoredMessage, error) {
+	for i := range m.messages {
+		msg := m.messages[i]
+		if msg.UserID == userID && msg.ClientMessageID != nil && *msg.ClientMessageID == clientID {
+			return &msg, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *mockMessageRepo) FindByRoom(roomID string, beforeID uint, limit int) ([]StoredMessage, error) {
+	var result []StoredMessage
+	for i := len(m.messages) - 1; i >= 0 && len(result) < limit; i-- {
+		msg := m.messages[i]
+		if msg.RoomID != roomID || msg.ThreadID != nil || m.deleted[msg.ID] || (beforeID > 0 && msg.ID >= beforeID) {
+			continue
+		}
+		result = append(result, msg)
+	}
+	return result, nil
+}
+
+func (m *mockMessageRepo) FindSince(roomID string, afterID uint, limit int) ([]StoredMessage, error) {
+	var result []StoredMessage
+	for _, msg := range m.messages {
+		if len(result) < limit && msg.RoomID == roomID && msg.ThreadID == nil && !m.deleted[msg.ID] && msg.ID > afterID {
+			result = append(result, msg)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockMessageRepo) CreateReply(msg *StoredMessage) error {
+	if err := m.Create(msg, nil); err != nil {
+		return err
+	}
+	for i := range m.messages {
+		if m.messages[i].ID == *msg.ThreadID {
+			m.messages[i].ReplyCount++
+			m.messages[i].LastReplyAt = &msg.CreatedAt
+		}
+	}
+	return nil
+}
+
+func (m *mockMessageRepo) FindThread(rootID, beforeID uint, limit int) ([]StoredMessage, error) {
+	var result []StoredMessage
+	for i := len(m.messages) - 1; i >= 0 && len(result) < limit; i-- {
+		msg := m.messages[i]
+		if msg.ThreadID == nil || *msg.ThreadID != rootID || m.deleted[msg.ID] || (beforeID > 0 && msg.ID >= beforeID) {
+			continue
+		}
+		result = append(result, msg)
+	}
+	return result, nil
+}
+
+func (m *mockMessageRepo) FindById(id uint) (*StoredMessage, error) {
+	for i := range m.messages {
+		if m.messages[i].ID == id && !m.deleted[id] {
+			msg := m.messages[i]
+			return &msg, nil
+		}
+	}
+	return nil, errors.New("record not found")
+}
+
+func (m *mockMessageRepo) Update(msg *StoredMessage, edit *MessageEdit) error {
+	for i := range m.messages {
+		if m.messages[i].ID == msg.ID {
+			m.messages[i] = *msg
+		}
+	}
+	m.edits = append(m.edits, *edit)
+	return nil
+}
+
+func (m *mockMessageRepo) Delete(id uint) error {
+	if m.deleted == nil {
+		m.deleted = map[uint]bool{}
+	}
+	m.deleted[id] = true
+	for _, msg := range m.messages {
+		if msg.ID == id && msg.ThreadID != nil {
+			for i := range m.messages {
+				if m.messages[i].ID == *msg.ThreadID {
+					m.messages[i].ReplyCount--
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (m *mockMessageRepo) FindEdits(messageID uint) ([]MessageEdit, error) {
+	var edits []MessageEdit
+	for _, edit := range m.edits {
+		if edit.MessageID == messageID {
+			edits = append(edits, edit)
+		}
+	}
+	return edits, nil
+}
+
+// Mock do repository de reações
+type mockReactionRepo struct {
+	reactions []Reaction
+}
+
+func (m *mockReactionRepo) Add(reaction *Reaction) (bool, error) {
+	for _, r := range m.reactions {
+		if r.MessageID == reaction.MessageID && r.UserID == reaction.UserID && r.Emoji == reaction.Emoji {
+			return false, nil
+		}
+	}
+	reaction.ID = uint(len(m.reactions) + 1)
+	m.reactions = append(m.reactions, *reaction)
+	return true, nil
+}
+
+func (m *mockReactionRepo) Remove(messageID, userID uint, emoji string) (bool, error) {
+	for i, r := range m.reactions {
+		if r.MessageID == messageID && r.UserID == userID && r.Emoji == emoji {
+			m.reactions = append(m.reactions[:i], m.reactions[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *mockReactionRepo) FindByMessages(messageIDs []uint) ([]Reaction, error) {
+	var result []Reaction
+	for _, r := range m.reactions {
+		for _, id := range messageIDs {
+			if r.MessageID == id {
+				result = append(result, r)
+			}
+		}
+	}
+	return result, nil
+}
+
+// Mock do repository de marcadores de leitura, contando a partir do mock de mensagens
+type mockReadRepo struct {
+	messages *mockMessageRepo
+	markers  map[string]uint // Chave: userID/roomID
+}
+
+func newMockReadRepo(messages *mockMessageRepo) *mockReadRepo {
+	return &mockReadRepo{messages: messages, markers: make(map[string]uint)}
+}
+
+func markerKey(userID uint, roomID string) string {
+	return fmt.Sprintf("%d/%s", userID, roomID)
+}
+
+func (m *mockReadRepo) MarkRead(userID uint, roomID string, messageID uint) (bool, error) {
+	key := markerKey(userID, roomID)
+	if m.markers[key] >= messageID {
+		return false, nil
+	}
+	m.markers[key] = messageID
+	return true, nil
+}
+
+func (m *mockReadRepo) FindMarkers(userID uint, roomIDs []string) ([]ReadMarker, error) {
+	var markers []ReadMarker
+	for _, roomID := range roomIDs {
+		if id, ok := m.markers[markerKey(userID, roomID)]; ok {
+			markers = append(markers, ReadMarker{UserID: userID, RoomID: roomID, LastReadID: id})
+		}
+	}
+	return markers, nil
+}
+
+func (m *mockReadRepo) CountUnread(userID uint, roomIDs []string) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	for _, roomID := range roomIDs {
+		lastRead := m.markers[markerKey(userID, roomID)]
+		for _, msg := range m.messages.messages {
+			if msg.RoomID == roomID && msg.ThreadID == nil && msg.UserID != userID &&
+				msg.ID > lastRead && !m.messages.deleted[msg.ID] {
+				counts[roomID]++
+			}
+		}
+	}
+	return counts, nil
+}
+
+func seedMessages(t *testing.T, roomID string, count int) {
+	t.Helper()
+	for i := 0; i < count; i++ {
+		if err := SaveMessage(&StoredMessage{RoomID: roomID, UserID: 1, Content: "hello"}); err != nil {
+			t.Fatalf("unexpected error seeding messages: %v", err)
+		}
+	}
+}
+
+func TestSaveMessage_WithoutContent(t *testing.T) {
+	messageRepo = &mockMessageRepo{}
+	reactionRepo = &mockReactionRepo{}
+
+	err := SaveMessage(&StoredMessage{RoomID: "room1", UserID: 1})
+
+	if err == nil {
+		t.Error("expected error for empty content, but got nil")
+	}
+}
+
+func TestHistory_PaginatesWithCursor(t *testing.T) {
+	messageRepo = &mockMessageRepo{}
+	reactionRepo = &mockReactionRepo{}
+	seedMessages(t, "room1", 5)
+
+	page, err := History("room1", 0, 2)
+	if err != nil {
+		t.Fatalf("expected nil, but got error: %v", err)
+	}
+
+	if len(page.Messages) != 2 || page.Messages[0].ID != 4 || page.Messages[1].ID != 5 {
+		t.Fatalf("expected messages 4 and 5 in chronological order, got %+v", page.Messages)
+	}
+
+	if page.NextCursor == nil || *page.NextCursor != 4 {
+		t.Fatalf("expected next cursor 4, got %v", page.NextCursor)
+	}
+
+	page, err = History("room1", *page.NextCursor, 10)
+	if err != nil {
+		t.Fatalf("expected nil, but got error: %v", err)
+	}
+
+	if len(page.Messages) != 3 || page.Messages[0].ID != 1 {
+		t.Fatalf("expected the 3 oldest messages, got %+v", page.Messages)
+	}
+
+	if page.NextCursor != nil {
+		t.Errorf("expected no next cursor on last page, got %d", *page.NextCursor)
+	}
+}
+
+func TestHistory_IgnoresOtherRooms(t *testing.T) {
+	messageRepo = &mockMessageRepo{}
+	reactionRepo = &mockReactionRepo{}
+	seedMessages(t, "room1", 2)
+	seedMessages(t, "room2", 3)
+
+	page, err := History("room1", 0, 0)
+	if err != nil {
+		t.Fatalf("expected nil, but got error: %v", err)
+	}
+
+	if len(page.Messages) != 2 {
+		t.Errorf("expected 2 messages from room1, got %d", len(page.Messages))
+	}
+}
+
+// setupEditTest seeds a message by user 1 in room1; user 3 moderates the room
+func setupEditTest(t *testing.T) *mockMessageRepo {
+	t.Helper()
+	repo := &mockMessageRepo{}
+	messageRepo = repo
+	reactionRepo = &mockReactionRepo{}
+	seedMessages(t, "room1", 1)
+
+	original := canModerate
+	canModerate = func(roomID string, userID uint) bool { return roomID == "room1" && userID == 3 }
+	t.Cleanup(func() { canModerate = original })
+	return repo
+}
+
+func TestEditMessage_AuthorKeepsHistory(t *testing.T) {
+	repo := setupEditTest(t)
+
+	msg, err := EditMessage("room1", 1, 1, "hello, world")
+	if err != nil {
+		t.Fatalf("expected nil, but got error: %v", err)
+	}
+	if msg.Content != "hello, world" || msg.EditedAt == nil {
+		t.Errorf("expected edited content with editedAt, got %+v", msg)
+	}
+
+	edits, _ := MessageEdits("room1", 1)
+	if len(edits) != 1 || edits[0].Content != "hello" || edits[0].EditedBy != 1 {
+		t.Errorf("expected previous content in edit history, got %+v", edits)
+	}
+	if repo.messages[0].Content != "hello, world" {
+		t.Errorf("expected stored content to change, got %q", repo.messages[0].Content)
+	}
+}
+
+func TestEditMessage_Permissions(t *testing.T) {
+	setupEditTest(t)
+
+	if _, err := EditMessage("room1", 1, 2, "hijacked"); !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("expected ErrNotAllowed for another user, but got %v", err)
+	}
+	if _, err := EditMessage("room1", 1, 3, "moderated"); err != nil {
+		t.Errorf("expected moderator to edit, but got %v", err)
+	}
+	if _, err := EditMessage("room2", 1, 1, "elsewhere"); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("expected ErrMessageNotFound for a message of another room, but got %v", err)
+	}
+	if _, err := EditMessage("room1", 1, 1, ""); !errors.Is(err, ErrContentRequired) {
+		t.Errorf("expected ErrContentRequired, but got %v", err)
+	}
+}
+
+func TestDeleteMessage_HidesFromHistory(t *testing.T) {
+	setupEditTest(t)
+
+	if err := DeleteMessage("room1", 1, 2); !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("expected ErrNotAllowed for another user, but got %v", err)
+	}
+	if err := DeleteMessage("room1", 1, 1); err != nil {
+		t.Fatalf("expected nil, but got error: %v", err)
+	}
+
+	page, _ := History("room1", 0, 0)
+	if len(page.Messages) != 0 {
+		t.Errorf("expected deleted message to be hidden, got %+v", page.Messages)
+	}
+	if err := DeleteMessage("room1", 1, 1); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("expected ErrMessageNotFound when deleting twice, but got %v", err)
+	}
+}
+
+func TestReplyInThread_BranchesFromMainRoom(t *testing.T) {
+	messageRepo = &mockMessageRepo{}
+	reactionRepo = &mockReactionRepo{}
+	seedMessages(t, "room1", 1)
+
+	first := &StoredMessage{RoomID: "room1", UserID: 2, Content: "reply"}
+	root, err := ReplyInThread(1, first)
+	if err != nil {
+		t.Fatalf("expected nil, but got error: %v", err)
+	}
+	if root.ID != 1 || root.ReplyCount != 1 || root.LastReplyAt == nil {
+		t.Errorf("expected root 1 with one reply, got %+v", root)
+	}
+
+	// Responder a uma resposta mantém a mensagem na mesma thread
+	second := &StoredMessage{RoomID: "room1", UserID: 1, Content: "reply to reply"}
+	root, err = ReplyInThread(first.ID, second)
+	if err != nil {
+		t.Fatalf("expected nil, but got error: %v", err)
+	}
+	if *second.ThreadID != 1 || *second.ReplyToID != first.ID || root.ReplyCount != 2 {
+		t.Errorf("expected reply in thread 1 quoting %d, got thread %d quoting %d (count %d)",
+			first.ID, *second.ThreadID, *second.ReplyToID, root.ReplyCount)
+	}
+
+	page, _ := History("room1", 0, 0)
+	if len(page.Messages) != 1 {
+		t.Errorf("expected thread replies to stay out of the main room, got %d messages", len(page.Messages))
+	}
+}
+
+func TestReplyInThread_OtherRoom(t *testing.T) {
+	messageRepo = &mockMessageRepo{}
+	reactionRepo = &mockReactionRepo{}
+	seedMessages(t, "room1", 1)
+
+	_, err := ReplyInThread(1, &StoredMessage{RoomID: "room2", UserID: 1, Content: "reply"})
+	if !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("expected ErrMessageNotFound for a message of another room, but got %v", err)
+	}
+}
+
+func TestThread_PaginatesReplies(t *testing.T) {
+	messageRepo = &mockMessageRepo{}
+	reactionRepo = &mockReactionRepo{}
+	seedMessages(t, "room1", 1)
+	for i := 0; i < 3; i++ {
+		ReplyInThread(1, &StoredMessage{RoomID: "room1", UserID: 1, Content: "reply"})
+	}
+
+	page, err := Thread(1, 0, 2)
+	if err != nil {
+		t.Fatalf("expected nil, but got error: %v", err)
+	}
+	if page.Root.ID != 1 || len(page.Replies) != 2 || page.Replies[0].ID != 3 || page.Replies[1].ID != 4 {
+		t.Fatalf("expected root 1 with replies 3 and 4, got %+v", page)
+	}
+	if page.NextCursor == nil || *page.NextCursor != 3 {
+		t.Fatalf("expected next cursor 3, got %v", page.NextCursor)
+	}
+
+	// Consultar a thread a partir de uma resposta retorna a thread inteira
+	page, _ = Thread(2, *page.NextCursor, 2)
+	if page.Root.ID != 1 || len(page.Replies) != 1 || page.Replies[0].ID != 2 || page.NextCursor != nil {
+		t.Errorf("expected last page with reply 2, got %+v", page)
+	}
+}
+
+func TestSaveMessage_QuoteMustBeInRoom(t *testing.T) {
+	messageRepo = &mockMessageRepo{}
+	reactionRepo = &mockReactionRepo{}
+	seedMessages(t, "room1", 1)
+
+	quoted := uint(1)
+	if err := SaveMessage(&StoredMessage{RoomID: "room2", UserID: 1, Content: "quote", ReplyToID: &quoted}); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("expected ErrMessageNotFound when quoting another room, but got %v", err)
+	}
+	if err := SaveMessage(&StoredMessage{RoomID: "room1", UserID: 1, Content: "quote", ReplyToID: &quoted}); err != nil {
+		t.Errorf("expected nil, but got error: %v", err)
+	}
+}
+
+func TestSaveMessage_LinksAttachmentsShownInHistory(t *testing.T) {
+	messageRepo = &mockMessageRepo{}
+	reactionRepo = &mockReactionRepo{}
+
+	linked := map[uint][]attachment.Attachment{}
+	pendingAttachments = func(ids []uint, userID uint, roomID string) ([]attachment.Attachment, error) {
+		if userID != 1 {
+			return nil, attachment.ErrNotFound
+		}
+		return []attachment.Attachment{{ID: ids[0], UserID: userID, RoomID: roomID, URL: "/attachments/1"}}, nil
+	}
+	linkAttachments = func(tx *gorm.DB, attachments []attachment.Attachment, messageID uint) error {
+		linked[messageID] = attachments
+		return nil
+	}
+	findAttachments = func(messageIDs []uint) (map[uint][]attachment.Attachment, error) {
+		return linked, nil
+	}
+	t.Cleanup(func() {
+		pendingAttachments, linkAttachments, findAttachments = attachment.Pending, attachment.Link, attachment.ForMessages
+	})
+
+	if err := AddAttachments(&StoredMessage{RoomID: "room1", UserID: 2}, []uint{1}); !errors.Is(err, ErrAttachment) {
+		t.Errorf("expected ErrAttachment for another user's upload, but got %v", err)
+	}
+
+	msg := &StoredMessage{RoomID: "room1", UserID: 1}
+	if err := AddAttachments(msg, []uint{1}); err != nil {
+		t.Fatalf("expected nil, but got error: %v", err)
+	}
+	if err := SaveMessage(msg); err != nil {
+		t.Fatalf("expected a message with only attachments to be saved, got %v", err)
+	}
+
+	page, err := History("room1", 0, 10)
+	if err != nil {
+		t.Fatalf("expected nil, but got error: %v", err)
+	}
+	if len(page.Messages) != 1 || len(page.Messages[0].Attachments) != 1 || page.Messages[0].Attachments[0].URL == "" {
+		t.Errorf("expected the attachment with its download URL in history, got %+v", page.Messages)
+	}
+}
+
+func TestSaveMessage_FailedLinkStoresNothing(t *testing.T) {
+	messages := &mockMessageRepo{}
+	messageRepo = messages
+	reactionRepo = &mockReactionRepo{}
+
+	failLink := true
+	linkAttachments = func(tx *gorm.DB, attachments []attachment.Attachment, messageID uint) error {
+		if failLink {
+			return errors.New("link failed")
+		}
+		return nil
+	}
+	t.Cleanup(func() { linkAttachments = attachment.Link })
+
+	clientID := "c1"
+	newMessage := func() *StoredMessage {
+		return &StoredMessage{RoomID: "room1", UserID: 1, ClientMessageID: &clientID,
+			Attachments: []attachment.Attachment{{ID: 1}}}
+	}
+	if err := SaveMessage(newMessage()); err == nil {
+		t.Fatal("expected the link error")
+	}
+	if len(messages.messages) != 0 {
+		t.Fatalf("expected the message insert to be rolled back, got %+v", messages.messages)
+	}
+
+	// A nova tentativa com o mesmo client_message_id não pode ser tratada como duplicada
+	failLink = false
+	if err := SaveMessage(newMessage()); err != nil {
+		t.Fatalf("expected the retry to be stored, got %v", err)
+	}
+	if len(messages.messages) != 1 {
+		t.Errorf("expected one stored message, got %d", len(messages.messages))
+	}
+}
+
+func TestReactions_IdempotentAndAggregated(t *testing.T) {
+	messageRepo = &mockMessageRepo{}
+	reactionRepo = &mockReactionRepo{}
+	seedMessages(t, "room1", 1)
+
+	if added, err := AddReaction("room1", 1, 1, "👍"); err != nil || !added {
+		t.Fatalf("expected reaction to be added, got %v, %v", added, err)
+	}
+	if added, _ := AddReaction("room1", 1, 1, "👍"); added {
+		t.Error("expected repeated reaction to be ignored")
+	}
+	AddReaction("room1", 1, 2, "👍")
+	AddReaction("room1", 1, 2, "🎉")
+
+	page, _ := History("room1", 0, 0)
+	reactions := page.Messages[0].Reactions
+	if len(reactions) != 2 || reactions[0].Emoji != "👍" || reactions[0].Count != 2 || reactions[1].Count != 1 {
+		t.Fatalf("expected 👍 x2 and 🎉 x1, got %+v", reactions)
+	}
+
+	if removed, _ := RemoveReaction("room1", 1, 2, "👍"); !removed {
+		t.Error("expected reaction to be removed")
+	}
+	if removed, _ := RemoveReaction("room1", 1, 2, "👍"); removed {
+		t.Error("expected removing a missing reaction to be a no-op")
+	}
+
+	page, _ = History("room1", 0, 0)
+	if reactions := page.Messages[0].Reactions; reactions[0].Count != 1 || reactions[0].UserIDs[0] != 1 {
+		t.Errorf("expected 👍 by user 1 only, got %+v", reactions)
+	}
+}
+
+func TestAddReaction_Validation(t *testing.T) {
+	messageRepo = &mockMessageRepo{}
+	reactionRepo = &mockReactionRepo{}
+	seedMessages(t, "room1", 1)
+
+	if _, err := AddReaction("room1", 1, 1, "not an emoji"); !errors.Is(err, ErrInvalidEmoji) {
+		t.Errorf("expected ErrInvalidEmoji, but got %v", err)
+	}
+	if _, err := AddReaction("room2", 1, 1, "👍"); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("expected ErrMessageNotFound for a message of another room, but got %v", err)
+	}
+}
+
+func TestMarkRead_OnlyMovesForward(t *testing.T) {
+	messages := &mockMessageRepo{}
+	messageRepo = messages
+	readRepo = newMockReadRepo(messages)
+	seedMessages(t, "room1", 3)
+
+	if advanced, err := MarkRead("room1", 2, 5); err != nil || !advanced {
+		t.Fatalf("expected marker to advance, got %v, %v", advanced, err)
+	}
+	if advanced, _ := MarkRead("room1", 1, 5); advanced {
+		t.Error("expected an older message to leave the marker in place")
+	}
+	if advanced, _ := MarkRead("room1", 2, 5); advanced {
+		t.Error("expected marking the same message twice to be a no-op")
+	}
+	if _, err := MarkRead("room2", 3, 5); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("expected ErrMessageNotFound for a message of another room, but got %v", err)
+	}
+}
+
+func TestUnread_CountsPerJoinedRoom(t *testing.T) {
+	messages := &mockMessageRepo{}
+	messageRepo = messages
+	reactionRepo = &mockReactionRepo{}
+	readRepo = newMockReadRepo(messages)
+	joinedRooms = func(userID uint) ([]room.Room, error) {
+		return []room.Room{{ID: 1, Name: "geral"}, {ID: 2, Name: "random"}}, nil
+	}
+	defer func() { joinedRooms = room.Joined }()
+
+	seedMessages(t, "1", 3) // IDs 1-3, enviadas pelo usuário 1
+	SaveMessage(&StoredMessage{RoomID: "1", UserID: 5, Content: "minha"})
+	seedMessages(t, "2", 2) // IDs 5-6
+	seedMessages(t, "3", 1) // Sala da qual o usuário não participa
+	ReplyInThread(1, &StoredMessage{RoomID: "1", UserID: 1, Content: "resposta"})
+
+	MarkRead("1", 2, 5)
+
+	unread, err := Unread(5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(unread) != 2 {
+		t.Fatalf("expected 2 rooms, got %+v", unread)
+	}
+	// Sala 1: só a mensagem 3; a própria mensagem e a resposta em thread não contam
+	if unread[0].RoomID != 1 || unread[0].UnreadCount != 1 || unread[0].LastReadID != 2 {
+		t.Errorf("expected 1 unread after message 2 in room 1, got %+v", unread[0])
+	}
+	if unread[1].RoomID != 2 || unread[1].UnreadCount != 2 || unread[1].LastReadID != 0 {
+		t.Errorf("expected 2 unread in room 2, got %+v", unread[1])
+	}
+}
+
+// Mock do repository de busca, casando termos por substring no mock de mensagens
+type mockSearchRepo struct {
+	messages *mockMessageRepo
+	filters  []SearchFilter
+}
+
+func (m *mockSearchRepo) Search(filter SearchFilter, limit int) ([]SearchResult, error) {
+	m.filters = append(m.filters, filter)
+	var results []SearchResult
+	for i := len(m.messages.messages) - 1; i >= 0 && len(results) < limit; i-- {
+		msg := m.messages.messages[i]
+		inRoom := false
+		for _, roomID := range filter.RoomIDs {
+			inRoom = inRoom || msg.RoomID == roomID
+		}
+		if !inRoom || !strings.Contains(msg.Content, filter.Query) || (filter.BeforeID > 0 && msg.ID >= filter.BeforeID) {
+			continue
+		}
+		snippet := strings.ReplaceAll(msg.Content, filter.Query, highlightStart+filter.Query+highlightStop)
+		results = append(results, SearchResult{StoredMessage: msg, Snippet: snippet})
+	}
+	return results, nil
+}
+
+func TestSearchMessages_RestrictedToJoinedRoomsAndPaginated(t *testing.T) {
+	messages := &mockMessageRepo{}
+	messageRepo = messages
+	reactionRepo = &mockReactionRepo{}
+	search := &mockSearchRepo{messages: messages}
+	searchRepo = search
+	joinedRooms = func(userID uint) ([]room.Room, error) {
+		return []room.Room{{ID: 1}, {ID: 2}}, nil
+	}
+	defer func() { joinedRooms = room.Joined }()
+
+	SaveMessage(&StoredMessage{RoomID: "1", UserID: 1, Content: "deploy <b>hoje</b>"})
+	SaveMessage(&StoredMessage{RoomID: "2", UserID: 1, Content: "deploy amanhã"})
+	SaveMessage(&StoredMessage{RoomID: "3", UserID: 1, Content: "deploy secreto"})
+
+	page, err := SearchMessages(5, SearchFilter{Query: " deploy "}, 1)
+	if err != nil {
+		t.Fatalf("expected nil, but got error: %v", err)
+	}
+	if len(page.Results) != 1 || page.Results[0].ID != 2 || page.NextCursor == nil || *page.NextCursor != 2 {
+		t.Fatalf("expected newest match with a cursor, got %+v", page)
+	}
+
+	page, _ = SearchMessages(5, SearchFilter{Query: "deploy", BeforeID: *page.NextCursor}, 10)
+	if len(page.Results) != 1 || page.Results[0].ID != 1 || page.NextCursor != nil {
+		t.Fatalf("expected the last match without a cursor, got %+v", page)
+	}
+	if want := "<mark>deploy</mark> &lt;b&gt;hoje&lt;/b&gt;"; page.Results[0].Snippet != want {
+		t.Errorf("expected escaped snippet %q, got %q", want, page.Results[0].Snippet)
+	}
+
+	if _, err := SearchMessages(5, SearchFilter{Query: "deploy", RoomIDs: []string{"3"}}, 10); !errors.Is(err, room.ErrNotMember) {
+		t.Errorf("expected ErrNotMember for a room the user is not in, got %v", err)
+	}
+	if _, err := SearchMessages(5, SearchFilter{Query: "  "}, 10); !errors.Is(err, ErrQueryRequired) {
+		t.Errorf("expected ErrQueryRequired for a blank query, got %v", err)
+	}
+	for _, filter := range search.filters {
+		if len(filter.RoomIDs) != 2 {
+			t.Errorf("expected search restricted to the 2 joined rooms, got %v", filter.RoomIDs)
+		}
+	}
+}
+
+func TestMissedMessages_BoundedReplayWindow(t *testing.T) {
+	messageRepo = &mockMessageRepo{}
+	reactionRepo = &mockReactionRepo{}
+	seedMessages(t, "room1", 5)
+	seedMessages(t, "room2", 1)
+
+	missed, err := MissedMessages("room1", 2, 3)
+	if err != nil {
+		t.Fatalf("expected nil, but got error: %v", err)
+	}
+	if len(missed) != 3 || missed[0].ID != 3 || missed[2].ID != 5 {
+		t.Errorf("expected messages 3 to 5 in order, got %+v", missed)
+	}
+
+	if _, err := MissedMessages("room1", 1, 3); !errors.Is(err, ErrResyncRequired) {
+		t.Errorf("expected ErrResyncRequired for a gap larger than the window, got %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package chat
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+
+	"go-chat-live/internal/logging"
+	"go-chat-live/internal/user"
+)
+
+// Login session functions; replaced in tests
+var (
+	listSessions  = user.ListSessions
+	revokeSession = user.RevokeSession
+)
+
+// SessionInfo describes a login session of the user, one per device. Sessions
+// come from the stored refresh tokens, so they are listed whichever server the
+// device is connected to; the connection details are filled in when it has
+// connections open on this server.
+type SessionInfo struct {
+	ID          string     `json:"id"`          // Login session ID, used for remote sign-out
+	SignedInAt  time.Time  `json:"signedInAt"`  // When the user logged in on the device
+	LastUsedAt  time.Time  `json:"lastUsedAt"`  // When the device last got a new token pair
+	Device      string     `json:"device"`      // Device name sent by the client, or its User-Agent; empty when not connected here
+	IP          string     `json:"ip"`          // Address of the latest connection; empty when not connected here
+	ConnectedAt *time.Time `json:"connectedAt"` // When the oldest open connection was opened; null when not connected here
+	Rooms       []string   `json:"rooms"`       // Rooms the device is connected to on this server
+	Current     bool       `json:"current"`     // Whether the request was made with a token of this session
+}
+
+// Sessions lists the active login sessions of a user, oldest first, with the
+// connections open on this Hub. current is the session of the caller, flagged
+// in the result.
+func (h *Hub) Sessions(userID uint, current string) ([]SessionInfo, error) {
+	stored, err := listSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]SessionInfo, 0, len(stored))
+	index := make(map[string]int, len(stored))
+	for _, s := range stored {
+		index[s.ID] = len(sessions)
+		sessions = append(sessions, SessionInfo{
+			ID:         s.ID,
+			SignedInAt: s.SignedInAt,
+			LastUsedAt: s.LastUsedAt,
+			Rooms:      []string{},
+			Current:    s.ID == current,
+		})
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	latest := make(map[int]time.Time) // Latest connection of each session, whose device is shown
+	for _, c := range h.users[userID] {
+		i, ok := index[c.SessionID]
+		if !ok {
+			continue
+		}
+		session := &sessions[i]
+		if session.ConnectedAt == nil || c.Connected.Before(*session.ConnectedAt) {
+			connected := c.Connected
+			session.ConnectedAt = &connected
+		}
+		if last, seen := latest[i]; !seen || !c.Connected.Before(last) {
+			latest[i] = c.Connected
+			session.Device, session.IP = c.Device, c.IP
+		}
+		if !slices.Contains(session.Rooms, c.RoomID) {
+			session.Rooms = append(session.Rooms, c.RoomID)
+		}
+	}
+	return sessions, nil
+}
+
+// SignOut signs a session of the user out: its refresh tokens are revoked and
+// its sid is added to the revocation list, so every access token of the session
+// is rejected. Its connections on this Hub are closed with CloseTokenRevoked;
+// the other instances close theirs on their revocation check. Reports false
+// when the user has no such session.
+func (h *Hub) SignOut(userID uint, sessionID string) (bool, error) {
+	found, err := revokeSession(userID, sessionID)
+	if err != nil || !found {
+		return found, err
+	}
+
+	h.mu.Lock()
+	var clients []*Client
+	for _, c := range h.users[userID] {
+		if c.SessionID == sessionID {
+			clients = append(clients, c)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, c := range clients {
+		c.setCloseReason(CloseTokenRevoked, "signed out")
+		c.close()
+	}
+	return true, nil
+}
+
+// ServeSessions handles GET /me/sessions requests, listing the login sessions
+// of the authenticated user.
+func ServeSessions(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if !allowCORS(w, r, http.MethodGet) {
+		return
+	}
+
+	auth, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	sessions, err := hub.Sessions(auth.User.ID, auth.SessionID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("could not list sessions", "error", err)
+		http.Error(w, "Sessions could not be listed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// ServeSignOut handles DELETE /me/sessions/{id} requests, signing one of the
+// devices of the authenticated user out remotely.
+func ServeSignOut(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if !allowCORS(w, r, http.MethodDelete) {
+		return
+	}
+
+	auth, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	found, err := hub.SignOut(auth.User.ID, r.PathValue("id"))
+	if err != nil {
+		logging.FromContext(r.Context()).Error("could not sign out session", "error", err)
+		http.Error(w, "Session could not be signed out", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,93 @@
+package chat
+
+import (
+	"testing"
+	"time"
+
+	"go-chat-live/internal/user"
+)
+
+func TestHub_SessionsListAndRemoteSignOut(t *testing.T) {
+	hub := newTestHub(16, PolicyDisconnect)
+
+	connect := func(roomID, sessionID, tokenID, device string, connected time.Time) *Client {
+		c := newTestClient(hub, roomID, 1)
+		c.SessionID, c.TokenID, c.Device, c.IP, c.Connected = sessionID, tokenID, device, "10.0.0.1", connected
+		hub.registerClient(c)
+		return c
+	}
+	start := time.Now()
+	listSessions = func(userID uint) ([]user.Session, error) {
+		if userID != 1 {
+			return nil, nil
+		}
+		// O tablet não tem conexões nesta réplica, mas continua listado
+		return []user.Session{
+			{ID: "tablet", SignedInAt: start.Add(-time.Hour), LastUsedAt: start},
+			{ID: "laptop", SignedInAt: start.Add(-time.Minute), LastUsedAt: start},
+			{ID: "phone", SignedInAt: start, LastUsedAt: start},
+		}, nil
+	}
+	t.Cleanup(func() { listSessions = user.ListSessions })
+
+	laptopRoom1 := connect("1", "laptop", "jti-1", "Firefox", start)
+	laptopRoom2 := connect("2", "laptop", "jti-2", "Firefox", start.Add(time.Minute))
+	phone := connect("1", "phone", "jti-3", "Pixel", start.Add(2*time.Minute))
+	other := newTestClient(hub, "1", 2)
+	hub.registerClient(other)
+	drain(laptopRoom1, laptopRoom2, phone, other)
+
+	sessions, err := hub.Sessions(1, "phone")
+	if err != nil || len(sessions) != 3 {
+		t.Fatalf("expected 3 sessions, got %+v %v", sessions, err)
+	}
+	tablet := sessions[0]
+	if tablet.ID != "tablet" || tablet.ConnectedAt != nil || tablet.Device != "" || len(tablet.Rooms) != 0 {
+		t.Errorf("expected the tablet session without local connections, got %+v", tablet)
+	}
+	laptop := sessions[1]
+	if laptop.ID != "laptop" || laptop.Device != "Firefox" || len(laptop.Rooms) != 2 || laptop.ConnectedAt == nil || !laptop.ConnectedAt.Equal(start) || laptop.Current {
+		t.Errorf("expected laptop session in 2 rooms since the first connection, got %+v", laptop)
+	}
+	if sessions[2].ID != "phone" || !sessions[2].Current || sessions[2].Device != "Pixel" {
+		t.Errorf("expected the phone to be the current session, got %+v", sessions[2])
+	}
+
+	var revokedSession string
+	revokeSession = func(userID uint, sessionID string) (bool, error) {
+		if userID != 1 {
+			return false, nil
+		}
+		revokedSession = sessionID
+		return true, nil
+	}
+	t.Cleanup(func() { revokeSession = user.RevokeSession })
+
+	if found, err := hub.SignOut(2, "laptop"); found || err != nil {
+		t.Fatalf("expected another user's sign-out to find nothing, got %v %v", found, err)
+	}
+	if found, err := hub.SignOut(1, "laptop"); !found || err != nil {
+		t.Fatalf("expected laptop to be signed out, got %v %v", found, err)
+	}
+	if revokedSession != "laptop" {
+		t.Errorf("expected the laptop session revoked, got %q", revokedSession)
+	}
+
+	for _, c := range []*Client{laptopRoom1, laptopRoom2} {
+		if _, open := <-c.Send; open {
+			t.Fatal("expected the signed out connection to be closed")
+		}
+		c.mu.Lock()
+		code := c.closeCode
+		c.mu.Unlock()
+		if code != CloseTokenRevoked {
+			t.Errorf("expected close code %d, got %d", CloseTokenRevoked, code)
+		}
+	}
+	phone.mu.Lock()
+	closed := phone.closed
+	phone.mu.Unlock()
+	if closed {
+		t.Error("expected the phone to stay connected")
+	}
+}
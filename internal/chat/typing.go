@@ -0,0 +1,75 @@
+package chat
+
+import "time"
+
+// typingState tracks whether a client is typing. Guarded by Client.mu.
+type typingState struct {
+	active    bool        // Whether typing_start was relayed and not stopped yet
+	lastRelay time.Time   // When typing_start was last relayed to the room
+	expiry    *time.Timer // Stops typing automatically if the client goes quiet
+}
+
+// startTyping relays typing_start for the client unless it was already relayed
+// within the throttle window, and re-arms the expiry that stops it if the client
+// never sends typing_stop. Typing events are never persisted.
+func (h *Hub) startTyping(c *Client) {
+	now := time.Now()
+
+	c.mu.Lock()
+	relay := !c.typing.active || now.Sub(c.typing.lastRelay) >= h.config.TypingThrottle
+	c.typing.active = true
+	if relay {
+		c.typing.lastRelay = now
+	}
+	if h.config.TypingTimeout > 0 {
+		if c.typing.expiry != nil {
+			c.typing.expiry.Stop()
+		}
+		c.typing.expiry = time.AfterFunc(h.config.TypingTimeout, func() { h.stopTyping(c) })
+	}
+	c.mu.Unlock()
+
+	if relay {
+		h.send(typingMessage(TypeTypingStart, c))
+	}
+}
+
+// stopTyping relays typing_stop if the client was typing.
+func (h *Hub) stopTyping(c *Client) {
+	if c.clearTyping() {
+		h.send(typingMessage(TypeTypingStop, c))
+	}
+}
+
+// send queues a message for the Run loop, giving up if the Hub stopped.
+func (h *Hub) send(msg Message) {
+	select {
+	case h.broadcast <- msg:
+	case <-h.quit:
+	}
+}
+
+// clearTyping resets the typing state, reporting whether the client was typing.
+func (c *Client) clearTyping() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.typing.active {
+		return false
+	}
+	c.typing.active = false
+	if c.typing.expiry != nil {
+		c.typing.expiry.Stop()
+		c.typing.expiry = nil
+	}
+	return true
+}
+
+// typingMessage builds a typing event of the client for its room
+func typingMessage(msgType string, c *Client) Message {
+	return Message{
+		Type:    msgType,
+		RoomID:  c.RoomID,
+		Payload: UserEvent{UserID: c.UserID, UserName: c.UserName},
+		Sender:  c,
+	}
+}
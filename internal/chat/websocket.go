@@ -1,9 +1,16 @@
 package chat
 
 import (
-	"log"
+	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"go-chat-live/internal/logging"
+	"go-chat-live/internal/room"
 	"go-chat-live/internal/user"
 
 	"github.com/gorilla/websocket"
@@ -25,85 +32,555 @@ func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate JWT token
+	var since uint64
+	if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+		var err error
+		since, err = strconv.ParseUint(sinceStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid since message ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	auth, roomID, ok := authorize(w, r, roomID)
+	if !ok {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("websocket upgrade failed", "error", err)
+		return
+	}
+
+	client := hub.NewClient(conn, roomID)
+	client.UserID = auth.User.ID
+	client.UserName = auth.User.Name
+	client.UserEmail = auth.Email
+	client.TokenID = auth.TokenID
+	client.SessionID = auth.SessionID
+	client.tokenExpiry = auth.ExpiresAt
+	client.Device = deviceName(r)
+	client.IP = hub.config.TrustedProxies.ClientIP(r)
+	client.Connected = time.Now()
+	client.resumeSince = uint(since)
+	client.log = connectionLogger(logging.FromContext(r.Context()), client.ID, roomID)
+
+	hub.serveClient(client)
+}
+
+// authInfo is the identity resolved from an authorized request.
+type authInfo struct {
+	User      *user.User // Authenticated user
+	Email     string     // Email claim of the token
+	TokenID   string     // jti claim of the token
+	SessionID string     // sid claim of the token, empty for tokens issued before login sessions
+	ExpiresAt time.Time  // exp claim of the token
+}
+
+// authorize authenticates the request and checks that the user is a member of the room.
+// It returns the canonical room ID ("07" and "+7" become "7"), which must be used
+// for the hub and storage from then on.
+// Writes the error response and returns false when the request is not allowed.
+func authorize(w http.ResponseWriter, r *http.Request, roomID string) (*authInfo, string, bool) {
+	auth, ok := authenticate(w, r)
+	if !ok {
+		return nil, "", false
+	}
+
+	// Only members of an existing room can access it
+	roomNumber, err := strconv.Atoi(roomID)
+	if err != nil {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return nil, "", false
+	}
+
+	if _, err := room.FindForMember(roomNumber, auth.User.ID); err != nil {
+		if errors.Is(err, room.ErrNotMember) {
+			http.Error(w, "Not a member of this room", http.StatusForbidden)
+		} else {
+			http.Error(w, "Room not found", http.StatusNotFound)
+		}
+		return nil, "", false
+	}
+
+	return auth, strconv.Itoa(roomNumber), true
+}
+
+// authenticate validates the JWT sent in the "token" query parameter or the
+// Authorization header and loads its user.
+// Writes the error response and returns false when the request is not allowed.
+func authenticate(w http.ResponseWriter, r *http.Request) (*authInfo, bool) {
 	token := r.URL.Query().Get("token")
+	if token == "" {
+		token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
 	if token == "" {
 		http.Error(w, "JWT token is required", http.StatusUnauthorized)
-		return
+		return nil, false
 	}
 
 	claims, err := user.ValidateJWT(token)
 	if err != nil {
 		http.Error(w, "Invalid JWT token", http.StatusUnauthorized)
-		return
+		return nil, false
 	}
 
 	userID, _ := claims["user_id"].(float64)
 	email, _ := claims["email"].(string)
-
-	log.Printf("Attempting to find user ID: %v", userID)
+	tokenID, _ := claims["jti"].(string)
+	sessionID, _ := claims["sid"].(string)
+	exp, _ := claims["exp"].(float64)
 
 	// Fetch complete user data
 	userData, err := user.FindById(int(userID))
 	if err != nil {
-		log.Printf("User not found for ID %v: %v", userID, err)
+		logging.FromContext(r.Context()).Warn("websocket token user not found", "error", err)
 		http.Error(w, "User not found", http.StatusUnauthorized)
-		return
+		return nil, false
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Println("websocket upgrade error:", err)
-		return
-	}
+	return &authInfo{
+		User:      userData,
+		Email:     email,
+		TokenID:   tokenID,
+		SessionID: sessionID,
+		ExpiresAt: time.Unix(int64(exp), 0),
+	}, true
+}
 
-	client := &Client{
-		ID:        r.RemoteAddr,
-		Conn:      conn,
-		RoomID:    roomID,
-		Send:      make(chan []byte),
-		UserID:    userData.ID,
-		UserName:  userData.Name,
-		UserEmail: email,
-	}
+// maxDeviceLength caps the device name kept for a connection
+const maxDeviceLength = 200
 
-	hub.register <- client
+// deviceName returns the name the client gave its device in the "device" query
+// parameter, falling back to the User-Agent header.
+func deviceName(r *http.Request) string {
+	device := strings.TrimSpace(r.URL.Query().Get("device"))
+	if device == "" {
+		device = r.UserAgent()
+	}
+	if len(device) > maxDeviceLength {
+		device = strings.ToValidUTF8(device[:maxDeviceLength], "")
+	}
+	return device
+}
 
-	go client.readPump(hub)
-	go client.writePump()
+// serveClient registers the client and starts its read and write pumps.
+// A client resuming a session has live frames held from registration until
+// its missed messages are replayed. When the broker subscription fails the
+// connection is closed with 1013 so the client retries later.
+func (h *Hub) serveClient(client *Client) {
+	if client.resumeSince > 0 {
+		client.holdLive()
+	}
+	client.logger().Debug("client connected", "resume_since", client.resumeSince)
+	go client.writePump(h.config)
+	if err := h.registerClient(client); err != nil {
+		client.logger().Error("could not register client", "error", err)
+		client.setCloseReason(websocket.CloseTryAgainLater, "try again later")
+		client.close()
+		return
+	}
+	go client.readPump(h)
 }
 
-// readPump reads messages from WebSocket connection and sends them to the Hub.
-// Runs in a separate goroutine for each client.
+// readPump reads envelopes from the WebSocket connection, validates them and
+// dispatches them to the Hub. Runs in a separate goroutine for each client.
+// Frames larger than the configured size and connections silent for longer
+// than the pong wait end the connection.
 func (c *Client) readPump(hub *Hub) {
 	defer func() {
 		hub.unregister <- c
-		c.Conn.Close()
+		c.logger().Debug("client disconnected")
 	}()
 
+	c.Conn.SetReadLimit(hub.config.MaxMessageSize)
+	c.Conn.SetReadDeadline(time.Now().Add(hub.config.PongWait))
+	c.Conn.SetPongHandler(func(string) error {
+		return c.Conn.SetReadDeadline(time.Now().Add(hub.config.PongWait))
+	})
+
+	if c.resumeSince > 0 {
+		hub.replay(c, c.resumeSince)
+	}
+
 	for {
-		_, msg, err := c.Conn.ReadMessage()
+		_, data, err := c.Conn.ReadMessage()
 		if err != nil {
+			c.recordReadError(err)
 			break
 		}
+		c.Conn.SetReadDeadline(time.Now().Add(hub.config.PongWait))
+
+		env, err := DecodeEnvelope(data, c.RoomID)
+		if err != nil {
+			id := ""
+			if env != nil {
+				id = env.ID
+			}
+			c.sendError(id, err)
+			continue
+		}
+
+		if isRateLimited(env.Type) {
+			allowed, disconnect := c.allowMessage(hub, env.ID)
+			if disconnect {
+				break
+			}
+			if !allowed {
+				continue
+			}
+		}
+
+		switch env.Type {
+		case TypeMessage:
+			c.handleChatMessage(hub, env)
+		case TypeDirect:
+			c.handleDirectMessage(hub, env)
+		case TypeThreadReply:
+			c.handleThreadReply(hub, env)
+		case TypeReactionAdd, TypeReactionRemove:
+			c.handleReaction(hub, env)
+		case TypeMessageEdit:
+			c.handleEditMessage(hub, env)
+		case TypeMessageDelete:
+			c.handleDeleteMessage(hub, env)
+		case TypeRead:
+			c.handleRead(hub, env)
+		case TypeResume:
+			c.handleResume(hub, env)
+		case TypeTyping, TypeTypingStart:
+			hub.startTyping(c)
+		case TypeTypingStop:
+			hub.stopTyping(c)
+		}
+	}
+}
+
+// handleChatMessage persists a chat message, broadcasts it to the room and
+// acknowledges it to the sender. The message is stored before it is broadcast;
+// a retry with a client message ID that was already stored is acknowledged
+// again without being broadcast, and failures are reported with a nack.
+func (c *Client) handleChatMessage(hub *Hub, env *Envelope) {
+	var req sendMessageRequest
+	if err := json.Unmarshal(env.Payload, &req); err != nil {
+		c.sendError(env.ID, err)
+		return
+	}
+
+	stored := c.newStoredMessage(req.Content, req.ClientMessageID)
+	if req.ReplyToID != 0 {
+		stored.ReplyToID = &req.ReplyToID
+	}
+	// Check for a retry first: its attachments are already linked to the stored message
+	err := Deduplicate(stored)
+	if err == nil {
+		err = AddAttachments(stored, req.AttachmentIDs)
+	}
+	if err == nil {
+		err = SaveMessage(stored)
+	}
+	if errors.Is(err, ErrDuplicateMessage) {
+		c.ackMessage(env.ID, req.ClientMessageID, stored.ID, stored.CreatedAt, true)
+		return
+	}
+	if err != nil {
+		c.sendNack(env.ID, req.ClientMessageID, err)
+		return
+	}
+	hub.stopTyping(c)
+
+	hub.broadcast <- Message{
+		Type:    TypeMessage,
+		RoomID:  c.RoomID,
+		Payload: NewChatMessage(stored),
+		Sender:  c,
+	}
+
+	c.ackMessage(env.ID, req.ClientMessageID, stored.ID, stored.CreatedAt, false)
+}
+
+// handleThreadReply persists a reply in the thread of a room message, notifies the
+// room so clients can update the thread and its reply count, and acknowledges it to the sender.
+func (c *Client) handleThreadReply(hub *Hub, env *Envelope) {
+	var req threadReplyRequest
+	if err := json.Unmarshal(env.Payload, &req); err != nil {
+		c.sendError(env.ID, err)
+		return
+	}
+
+	reply := c.newStoredMessage(req.Content, req.ClientMessageID)
+	root, err := ReplyInThread(req.ReplyToID, reply)
+	if errors.Is(err, ErrDuplicateMessage) {
+		c.ackMessage(env.ID, req.ClientMessageID, reply.ID, reply.CreatedAt, true)
+		return
+	}
+	if err != nil {
+		c.sendNack(env.ID, req.ClientMessageID, err)
+		return
+	}
+	hub.stopTyping(c)
+
+	hub.broadcast <- Message{
+		Type:    TypeThreadReply,
+		RoomID:  c.RoomID,
+		Payload: ThreadReplyPayload{Message: NewChatMessage(reply), ReplyCount: root.ReplyCount},
+		Sender:  c,
+	}
+
+	c.ackMessage(env.ID, req.ClientMessageID, reply.ID, reply.CreatedAt, false)
+}
+
+// newStoredMessage builds a message from this client to its room
+func (c *Client) newStoredMessage(content, clientMessageID string) *StoredMessage {
+	msg := &StoredMessage{
+		RoomID:    c.RoomID,
+		UserID:    c.UserID,
+		UserName:  c.UserName,
+		Content:   content,
+		CreatedAt: time.Now(),
+	}
+	if clientMessageID != "" {
+		msg.ClientMessageID = &clientMessageID
+	}
+	return msg
+}
+
+// ackMessage confirms a persisted message to the sender with its server ID and timestamp.
+// duplicate reports that the client message ID had already been stored.
+func (c *Client) ackMessage(id, clientMessageID string, messageID uint, createdAt time.Time, duplicate bool) {
+	c.sendEnvelope(TypeAck, id, AckPayload{
+		MessageID:       messageID,
+		ClientMessageID: clientMessageID,
+		CreatedAt:       &createdAt,
+		Duplicate:       duplicate,
+	})
+}
+
+// sendNack reports a message that could not be persisted to the sender. Only
+// server failures are retryable; invalid messages fail the same way every time.
+func (c *Client) sendNack(id, clientMessageID string, err error) {
+	nack := NackPayload{ClientMessageID: clientMessageID}
+	switch {
+	case errors.Is(err, ErrMessageNotFound):
+		nack.Code, nack.Reason = ErrCodeNotFound, "replied message not found in this room"
+	case errors.Is(err, ErrAttachment), errors.Is(err, ErrInvalidRecipient):
+		nack.Code, nack.Reason = ErrCodeInvalidPayload, err.Error()
+	default:
+		c.logger().Error("could not persist message", "error", err)
+		nack.Code, nack.Reason, nack.Retryable = ErrCodeInternal, "message could not be saved", true
+	}
+	c.sendEnvelope(TypeNack, id, nack)
+}
+
+// handleDirectMessage persists a private message and delivers it to every connection
+// of the recipient and to the sender's other sessions, acknowledging it to the sender.
+func (c *Client) handleDirectMessage(hub *Hub, env *Envelope) {
+	var req sendDirectRequest
+	if err := json.Unmarshal(env.Payload, &req); err != nil {
+		c.sendError(env.ID, err)
+		return
+	}
+
+	dm, err := SendDirectMessage(c.UserID, c.UserName, req.ToUserID, req.Content, req.ClientMessageID)
+	if errors.Is(err, ErrDuplicateMessage) {
+		c.ackMessage(env.ID, req.ClientMessageID, dm.ID, dm.CreatedAt, true)
+		return
+	}
+	if err != nil {
+		c.sendNack(env.ID, req.ClientMessageID, err)
+		return
+	}
+
+	hub.broadcast <- Message{
+		Type:    TypeDirect,
+		ToUsers: []uint{dm.RecipientID, dm.SenderID},
+		Payload: dm,
+		Sender:  c,
+	}
+
+	c.ackMessage(env.ID, req.ClientMessageID, dm.ID, dm.CreatedAt, false)
+}
+
+// handleEditMessage edits a message of the room, notifies the room and acknowledges it to the sender.
+func (c *Client) handleEditMessage(hub *Hub, env *Envelope) {
+	var req editMessageRequest
+	if err := json.Unmarshal(env.Payload, &req); err != nil {
+		c.sendError(env.ID, err)
+		return
+	}
+
+	msg, err := EditMessage(c.RoomID, req.MessageID, c.UserID, req.Content)
+	if err != nil {
+		c.sendError(env.ID, c.messageError(err))
+		return
+	}
+
+	hub.broadcast <- Message{
+		Type:    TypeMessageUpdated,
+		RoomID:  c.RoomID,
+		Payload: NewChatMessage(msg),
+		Sender:  c,
+	}
+
+	c.sendEnvelope(TypeAck, env.ID, AckPayload{MessageID: msg.ID})
+}
+
+// handleDeleteMessage deletes a message of the room, notifies the room and acknowledges it to the sender.
+func (c *Client) handleDeleteMessage(hub *Hub, env *Envelope) {
+	var req deleteMessageRequest
+	if err := json.Unmarshal(env.Payload, &req); err != nil {
+		c.sendError(env.ID, err)
+		return
+	}
+
+	if err := DeleteMessage(c.RoomID, req.MessageID, c.UserID); err != nil {
+		c.sendError(env.ID, c.messageError(err))
+		return
+	}
+
+	hub.broadcast <- Message{
+		Type:    TypeMessageDeleted,
+		RoomID:  c.RoomID,
+		Payload: MessageDeletedPayload{MessageID: req.MessageID, DeletedBy: c.UserID},
+		Sender:  c,
+	}
+
+	c.sendEnvelope(TypeAck, env.ID, AckPayload{MessageID: req.MessageID})
+}
+
+// handleReaction adds or removes a reaction and acknowledges it to the sender.
+// The room is only notified when the reaction actually changed, so repeating a
+// reaction_add or reaction_remove is harmless.
+func (c *Client) handleReaction(hub *Hub, env *Envelope) {
+	var req reactionRequest
+	if err := json.Unmarshal(env.Payload, &req); err != nil {
+		c.sendError(env.ID, err)
+		return
+	}
+
+	change, event := AddReaction, TypeReactionAdded
+	if env.Type == TypeReactionRemove {
+		change, event = RemoveReaction, TypeReactionRemoved
+	}
+
+	changed, err := change(c.RoomID, req.MessageID, c.UserID, req.Emoji)
+	if err != nil {
+		c.sendError(env.ID, c.messageError(err))
+		return
+	}
+
+	if changed {
+		hub.broadcast <- Message{
+			Type:    event,
+			RoomID:  c.RoomID,
+			Payload: ReactionPayload{MessageID: req.MessageID, Emoji: req.Emoji, UserID: c.UserID, UserName: c.UserName},
+			Sender:  c,
+		}
+	}
+
+	c.sendEnvelope(TypeAck, env.ID, AckPayload{MessageID: req.MessageID})
+}
+
+// handleRead moves the user's read marker forward, notifies the room and
+// acknowledges it to the sender. Stale receipts are acknowledged without a broadcast.
+func (c *Client) handleRead(hub *Hub, env *Envelope) {
+	var req readRequest
+	if err := json.Unmarshal(env.Payload, &req); err != nil {
+		c.sendError(env.ID, err)
+		return
+	}
+
+	advanced, err := MarkRead(c.RoomID, req.MessageID, c.UserID)
+	if err != nil {
+		c.sendError(env.ID, c.messageError(err))
+		return
+	}
+
+	if advanced {
 		hub.broadcast <- Message{
-			RoomID:   c.RoomID,
-			Content:  string(msg),
-			UserName: c.UserName,
-			Sender:   c,
+			Type:    TypeReadReceipt,
+			RoomID:  c.RoomID,
+			Payload: ReadReceiptPayload{UserID: c.UserID, UserName: c.UserName, MessageID: req.MessageID},
+			Sender:  c,
 		}
 	}
+
+	c.sendEnvelope(TypeAck, env.ID, AckPayload{MessageID: req.MessageID})
 }
 
-// writePump sends messages from the Send channel to the WebSocket connection.
+// handleResume replays the room messages missed since the message in the payload.
+// Clients that cannot pass "since" when connecting send it as their first frame;
+// messages already received live may be replayed again and are recognized by ID.
+func (c *Client) handleResume(hub *Hub, env *Envelope) {
+	var req resumeRequest
+	if err := json.Unmarshal(env.Payload, &req); err != nil {
+		c.sendError(env.ID, err)
+		return
+	}
+
+	hub.replay(c, req.Since)
+	c.sendEnvelope(TypeAck, env.ID, AckPayload{})
+}
+
+// messageError translates an edit, delete, reaction or read error into the error sent to the client
+func (c *Client) messageError(err error) error {
+	switch {
+	case errors.Is(err, ErrMessageNotFound):
+		return &ProtocolError{Code: ErrCodeNotFound, Message: err.Error()}
+	case errors.Is(err, ErrNotAllowed):
+		return &ProtocolError{Code: ErrCodeForbidden, Message: err.Error()}
+	case errors.Is(err, ErrContentRequired), errors.Is(err, ErrInvalidEmoji):
+		return &ProtocolError{Code: ErrCodeInvalidPayload, Message: err.Error()}
+	default:
+		c.logger().Error("could not modify message", "error", err)
+		return errors.New("message could not be modified")
+	}
+}
+
+// recordReadError translates the error that ended readPump into the close
+// code reported to the client.
+func (c *Client) recordReadError(err error) {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, websocket.ErrReadLimit):
+		c.setCloseReason(websocket.CloseMessageTooBig, "message too big")
+	case errors.As(err, &netErr) && netErr.Timeout():
+		c.setCloseReason(websocket.CloseGoingAway, "heartbeat timeout")
+	case websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived):
+		c.logger().Info("unexpected close from client", "error", err)
+	}
+}
+
+// writePump sends messages from the Send channel to the WebSocket connection and
+// pings the client periodically. When Send is closed it sends a close frame with
+// the recorded reason and closes the connection.
 // Runs in a separate goroutine for each client.
-func (c *Client) writePump() {
-	defer c.Conn.Close()
+func (c *Client) writePump(config HubConfig) {
+	ticker := time.NewTicker(config.PingInterval)
+	defer func() {
+		ticker.Stop()
+		c.Conn.Close()
+	}()
 
-	for msg := range c.Send {
-		err := c.Conn.WriteMessage(websocket.TextMessage, msg)
-		if err != nil {
-			break
+	for {
+		select {
+		case msg, ok := <-c.Send:
+			c.Conn.SetWriteDeadline(time.Now().Add(config.WriteTimeout))
+			if !ok {
+				c.Conn.WriteMessage(websocket.CloseMessage, c.closeMessage())
+				return
+			}
+			if err := c.Conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				c.logger().Debug("could not write frame", "error", err)
+				return
+			}
+		case <-ticker.C:
+			c.Conn.SetWriteDeadline(time.Now().Add(config.WriteTimeout))
+			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.logger().Debug("could not write ping", "error", err)
+				return
+			}
 		}
 	}
 }
@@ -0,0 +1,653 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// startTestServer serves WebSocket connections straight into the hub, skipping
+// JWT and room checks, and returns the URL to dial.
+func startTestServer(t *testing.T, hub *Hub) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		client := hub.NewClient(conn, "1")
+		client.UserID = 1
+		hub.serveClient(client)
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// readCloseError reads frames until the connection closes and returns the close error
+func readCloseError(t *testing.T, conn *websocket.Conn) *websocket.CloseError {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) {
+				t.Fatalf("expected close frame, got %v", err)
+			}
+			return closeErr
+		}
+	}
+}
+
+func heartbeatConfig() HubConfig {
+	return HubConfig{
+		SendBufferSize:     16,
+		SlowConsumerPolicy: PolicyDisconnect,
+		PingInterval:       50 * time.Millisecond,
+		PongWait:           150 * time.Millisecond,
+		WriteTimeout:       time.Second,
+		MaxMessageSize:     64,
+	}
+}
+
+func TestClient_OversizedFrameIsRejected(t *testing.T) {
+	hub := NewHubWithConfig(NewMemoryBroker(), heartbeatConfig())
+	go hub.Run()
+
+	conn, _, err := websocket.DefaultDialer.Dial(startTestServer(t, hub), nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("x", 128)))
+
+	if closeErr := readCloseError(t, conn); closeErr.Code != websocket.CloseMessageTooBig {
+		t.Errorf("expected close code %d, got %d", websocket.CloseMessageTooBig, closeErr.Code)
+	}
+}
+
+func TestClient_PingsKeepAnsweringClientAlive(t *testing.T) {
+	hub := NewHubWithConfig(NewMemoryBroker(), heartbeatConfig())
+	go hub.Run()
+
+	conn, _, err := websocket.DefaultDialer.Dial(startTestServer(t, hub), nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// Answer every ping with a pong, counting them, while we keep reading
+	pings := 0
+	conn.SetPingHandler(func(data string) error {
+		pings++
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+
+	conn.SetReadDeadline(time.Now().Add(400 * time.Millisecond))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			var netErr interface{ Timeout() bool }
+			if !errors.As(err, &netErr) || !netErr.Timeout() {
+				t.Fatalf("expected connection to stay open, got %v", err)
+			}
+			break
+		}
+	}
+
+	if pings < 3 {
+		t.Errorf("expected several pings, got %d", pings)
+	}
+}
+
+func TestClient_SilentClientTimesOut(t *testing.T) {
+	hub := NewHubWithConfig(NewMemoryBroker(), heartbeatConfig())
+	go hub.Run()
+
+	conn, _, err := websocket.DefaultDialer.Dial(startTestServer(t, hub), nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// Swallow pings without answering so the server never sees a pong
+	conn.SetPingHandler(func(string) error { return nil })
+
+	if closeErr := readCloseError(t, conn); closeErr.Code != websocket.CloseGoingAway {
+		t.Errorf("expected close code %d, got %d", websocket.CloseGoingAway, closeErr.Code)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if roster := hub.Roster("1"); len(roster) != 0 {
+		t.Errorf("expected timed out client to be unregistered, roster=%+v", roster)
+	}
+}
+
+func TestHub_ShutdownFlushesAndSendsGoingAway(t *testing.T) {
+	hub := NewHubWithConfig(NewMemoryBroker(), heartbeatConfig())
+	go hub.Run()
+
+	conn, _, err := websocket.DefaultDialer.Dial(startTestServer(t, hub), nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetPingHandler(func(string) error { return nil })
+
+	hub.broadcast <- Message{Type: TypeMessage, RoomID: "1", Payload: ChatMessage{Content: "last words"}}
+	time.Sleep(50 * time.Millisecond) // Let the broker deliver it to the client's queue
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- hub.Shutdown(ctx) }()
+
+	var types []string
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseGoingAway {
+				t.Fatalf("expected going away close frame, got %v", err)
+			}
+			break
+		}
+		var env Envelope
+		json.Unmarshal(data, &env)
+		types = append(types, env.Type)
+	}
+
+	if len(types) == 0 || types[len(types)-1] != TypeMessage {
+		t.Errorf("expected queued message to be flushed before closing, got %v", types)
+	}
+
+	if err := <-shutdownErr; err != nil {
+		t.Errorf("expected clean shutdown, got %v", err)
+	}
+}
+
+// readEnvelopeOfType reads frames until one of the given type arrives
+func readEnvelopeOfType(t *testing.T, conn *websocket.Conn, msgType string) Envelope {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("expected %s envelope, got %v", msgType, err)
+		}
+		var env Envelope
+		json.Unmarshal(data, &env)
+		if env.Type == msgType {
+			return env
+		}
+	}
+}
+
+func TestClient_EditAndDeletePropagateToRoom(t *testing.T) {
+	messageRepo = &mockMessageRepo{}
+	reactionRepo = &mockReactionRepo{}
+	seedMessages(t, "1", 1)
+
+	config := heartbeatConfig()
+	config.PingInterval = time.Minute
+	config.PongWait = time.Minute
+	config.MaxMessageSize = 4096
+	hub := NewHubWithConfig(NewMemoryBroker(), config)
+	go hub.Run()
+	url := startTestServer(t, hub)
+
+	author, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer author.Close()
+	watcher, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer watcher.Close()
+	readEnvelopeOfType(t, watcher, TypePresence)
+
+	author.WriteJSON(map[string]interface{}{
+		"v": 1, "type": TypeMessageEdit, "id": "e1",
+		"payload": map[string]interface{}{"messageId": 1, "content": "edited"},
+	})
+	if ack := readEnvelopeOfType(t, author, TypeAck); ack.ID != "e1" {
+		t.Errorf("expected ack for e1, got %s", ack.ID)
+	}
+	updated := readEnvelopeOfType(t, watcher, TypeMessageUpdated)
+	var msg ChatMessage
+	json.Unmarshal(updated.Payload, &msg)
+	if msg.ID != 1 || msg.Content != "edited" || msg.EditedAt == nil {
+		t.Errorf("expected edited message 1, got %+v", msg)
+	}
+
+	author.WriteJSON(map[string]interface{}{
+		"v": 1, "type": TypeMessageDelete, "id": "d1",
+		"payload": map[string]interface{}{"messageId": 1},
+	})
+	readEnvelopeOfType(t, author, TypeAck)
+	deleted := readEnvelopeOfType(t, watcher, TypeMessageDeleted)
+	var payload MessageDeletedPayload
+	json.Unmarshal(deleted.Payload, &payload)
+	if payload.MessageID != 1 || payload.DeletedBy != 1 {
+		t.Errorf("expected message 1 deleted by user 1, got %+v", payload)
+	}
+}
+
+func TestClient_ReactionsBroadcastOnlyOnChange(t *testing.T) {
+	messageRepo = &mockMessageRepo{}
+	reactionRepo = &mockReactionRepo{}
+	seedMessages(t, "1", 1)
+
+	config := heartbeatConfig()
+	config.PingInterval = time.Minute
+	config.PongWait = time.Minute
+	config.MaxMessageSize = 4096
+	hub := NewHubWithConfig(NewMemoryBroker(), config)
+	go hub.Run()
+	url := startTestServer(t, hub)
+
+	reactor, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer reactor.Close()
+	watcher, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer watcher.Close()
+	readEnvelopeOfType(t, watcher, TypePresence)
+
+	react := func(id, msgType string) {
+		reactor.WriteJSON(map[string]interface{}{
+			"v": 1, "type": msgType, "id": id,
+			"payload": map[string]interface{}{"messageId": 1, "emoji": "👍"},
+		})
+		if ack := readEnvelopeOfType(t, reactor, TypeAck); ack.ID != id {
+			t.Fatalf("expected ack for %s, got %s", id, ack.ID)
+		}
+	}
+
+	react("r1", TypeReactionAdd)
+	react("r2", TypeReactionAdd) // Repetido: confirmado, mas não transmitido
+	react("r3", TypeReactionRemove)
+
+	added := readEnvelopeOfType(t, watcher, TypeReactionAdded)
+	var payload ReactionPayload
+	json.Unmarshal(added.Payload, &payload)
+	if payload.MessageID != 1 || payload.Emoji != "👍" || payload.UserID != 1 {
+		t.Errorf("expected 👍 on message 1 by user 1, got %+v", payload)
+	}
+
+	// O próximo evento deve ser a remoção, sem um segundo reaction_added
+	watcher.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := watcher.ReadMessage()
+	if err != nil {
+		t.Fatalf("expected reaction_removed, got %v", err)
+	}
+	var next Envelope
+	json.Unmarshal(data, &next)
+	if next.Type != TypeReactionRemoved {
+		t.Errorf("expected reaction_removed after the duplicate add, got %s", next.Type)
+	}
+}
+
+func TestClient_ReadReceiptsBroadcastWhenMarkerAdvances(t *testing.T) {
+	messages := &mockMessageRepo{}
+	messageRepo = messages
+	readRepo = newMockReadRepo(messages)
+	seedMessages(t, "1", 2)
+
+	config := heartbeatConfig()
+	config.PingInterval = time.Minute
+	config.PongWait = time.Minute
+	config.MaxMessageSize = 4096
+	hub := NewHubWithConfig(NewMemoryBroker(), config)
+	go hub.Run()
+	url := startTestServer(t, hub)
+
+	reader, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer reader.Close()
+	watcher, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer watcher.Close()
+	readEnvelopeOfType(t, watcher, TypePresence)
+
+	read := func(id string, messageID uint) {
+		reader.WriteJSON(map[string]interface{}{
+			"v": 1, "type": TypeRead, "id": id,
+			"payload": map[string]interface{}{"messageId": messageID},
+		})
+		if ack := readEnvelopeOfType(t, reader, TypeAck); ack.ID != id {
+			t.Fatalf("expected ack for %s, got %s", id, ack.ID)
+		}
+	}
+
+	read("r1", 1)
+	read("r2", 1) // Marcador já está na mensagem 1: confirmado, mas não transmitido
+	read("r3", 2)
+
+	for _, want := range []uint{1, 2} {
+		receipt := readEnvelopeOfType(t, watcher, TypeReadReceipt)
+		var payload ReadReceiptPayload
+		json.Unmarshal(receipt.Payload, &payload)
+		if payload.MessageID != want || payload.UserID != 1 {
+			t.Errorf("expected receipt of message %d by user 1, got %+v", want, payload)
+		}
+	}
+}
+
+func TestClient_FloodIsRateLimitedThenDisconnected(t *testing.T) {
+	messageRepo = &mockMessageRepo{}
+	reactionRepo = &mockReactionRepo{}
+
+	config := heartbeatConfig()
+	config.PingInterval = time.Minute
+	config.PongWait = time.Minute
+	config.MaxMessageSize = 4096
+	config.MessageRate = 1
+	config.MessageBurst = 2
+	config.MaxRateViolations = 3
+	hub := NewHubWithConfig(NewMemoryBroker(), config)
+	go hub.Run()
+
+	conn, _, err := websocket.DefaultDialer.Dial(startTestServer(t, hub), nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	readEnvelopeOfType(t, conn, TypePresence)
+
+	send := func(id string) {
+		conn.WriteJSON(map[string]interface{}{
+			"v": 1, "type": TypeMessage, "id": id,
+			"payload": map[string]interface{}{"content": "spam"},
+		})
+	}
+
+	send("m1")
+	readEnvelopeOfType(t, conn, TypeAck)
+	send("m2")
+	readEnvelopeOfType(t, conn, TypeAck)
+
+	send("m3")
+	limited := readEnvelopeOfType(t, conn, TypeError)
+	var payload ErrorPayload
+	json.Unmarshal(limited.Payload, &payload)
+	if limited.ID != "m3" || payload.Code != ErrCodeRateLimited || payload.RetryAfterMs <= 0 {
+		t.Errorf("expected rate_limited error with retry delay for m3, got %s %+v", limited.ID, payload)
+	}
+
+	send("m4")
+	readEnvelopeOfType(t, conn, TypeError)
+	send("m5")
+	if closeErr := readCloseError(t, conn); closeErr.Code != websocket.ClosePolicyViolation {
+		t.Errorf("expected close code %d after repeated abuse, got %d", websocket.ClosePolicyViolation, closeErr.Code)
+	}
+	if len(messageRepo.(*mockMessageRepo).messages) != 2 {
+		t.Errorf("expected only the 2 allowed messages to be saved, got %d", len(messageRepo.(*mockMessageRepo).messages))
+	}
+}
+
+func TestClient_ReactionsAndReadsShareTheRateLimit(t *testing.T) {
+	messages := &mockMessageRepo{}
+	messageRepo = messages
+	reactionRepo = &mockReactionRepo{}
+	readRepo = newMockReadRepo(messages)
+	seedMessages(t, "1", 1)
+
+	config := heartbeatConfig()
+	config.PingInterval = time.Minute
+	config.PongWait = time.Minute
+	config.MaxMessageSize = 4096
+	config.MessageRate = 1
+	config.MessageBurst = 2
+	hub := NewHubWithConfig(NewMemoryBroker(), config)
+	go hub.Run()
+
+	conn, _, err := websocket.DefaultDialer.Dial(startTestServer(t, hub), nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	readEnvelopeOfType(t, conn, TypePresence)
+
+	conn.WriteJSON(map[string]interface{}{
+		"v": 1, "type": TypeReactionAdd, "id": "x1",
+		"payload": map[string]interface{}{"messageId": 1, "emoji": "👍"},
+	})
+	readEnvelopeOfType(t, conn, TypeAck)
+	conn.WriteJSON(map[string]interface{}{
+		"v": 1, "type": TypeRead, "id": "r1",
+		"payload": map[string]interface{}{"messageId": 1},
+	})
+	readEnvelopeOfType(t, conn, TypeAck)
+
+	// O balde está vazio: reações, leituras e resume são recusados como mensagens
+	for _, frame := range []struct{ id, msgType string }{{"x2", TypeReactionRemove}, {"r2", TypeRead}, {"s1", TypeResume}} {
+		conn.WriteJSON(map[string]interface{}{
+			"v": 1, "type": frame.msgType, "id": frame.id,
+			"payload": map[string]interface{}{"messageId": 1, "emoji": "👍", "since": 1},
+		})
+		limited := readEnvelopeOfType(t, conn, TypeError)
+		var payload ErrorPayload
+		json.Unmarshal(limited.Payload, &payload)
+		if limited.ID != frame.id || payload.Code != ErrCodeRateLimited {
+			t.Errorf("expected rate_limited error for %s, got %s %+v", frame.id, limited.ID, payload)
+		}
+	}
+}
+
+func TestClient_RetriedMessageIsStoredAndBroadcastOnce(t *testing.T) {
+	messageRepo = &mockMessageRepo{}
+	reactionRepo = &mockReactionRepo{}
+
+	config := heartbeatConfig()
+	config.PingInterval = time.Minute
+	config.PongWait = time.Minute
+	config.MaxMessageSize = 4096
+	hub := NewHubWithConfig(NewMemoryBroker(), config)
+	go hub.Run()
+	url := startTestServer(t, hub)
+
+	sender, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer sender.Close()
+	watcher, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer watcher.Close()
+	readEnvelopeOfType(t, watcher, TypePresence)
+
+	send := func(id string, payload map[string]interface{}) Envelope {
+		sender.WriteJSON(map[string]interface{}{"v": 1, "type": TypeMessage, "id": id, "payload": payload})
+		for {
+			sender.SetReadDeadline(time.Now().Add(2 * time.Second))
+			_, data, err := sender.ReadMessage()
+			if err != nil {
+				t.Fatalf("expected ack or nack for %s, got %v", id, err)
+			}
+			var env Envelope
+			json.Unmarshal(data, &env)
+			if env.Type == TypeAck || env.Type == TypeNack {
+				return env
+			}
+		}
+	}
+
+	var first, retry AckPayload
+	ack := send("m1", map[string]interface{}{"content": "hello", "clientMessageId": "phone-1"})
+	json.Unmarshal(ack.Payload, &first)
+	if ack.Type != TypeAck || first.MessageID == 0 || first.ClientMessageID != "phone-1" || first.CreatedAt == nil || first.Duplicate {
+		t.Fatalf("expected ack with server ID and timestamp, got %s %+v", ack.Type, first)
+	}
+
+	// Reenvio após perder o ack: confirmado de novo, sem salvar nem transmitir
+	ack = send("m2", map[string]interface{}{"content": "hello", "clientMessageId": "phone-1"})
+	json.Unmarshal(ack.Payload, &retry)
+	if ack.Type != TypeAck || retry.MessageID != first.MessageID || !retry.Duplicate || !retry.CreatedAt.Equal(*first.CreatedAt) {
+		t.Errorf("expected duplicate ack of message %d, got %s %+v", first.MessageID, ack.Type, retry)
+	}
+
+	nack := send("m3", map[string]interface{}{"content": "re", "replyToId": 99, "clientMessageId": "phone-2"})
+	var failed NackPayload
+	json.Unmarshal(nack.Payload, &failed)
+	if nack.Type != TypeNack || nack.ID != "m3" || failed.ClientMessageID != "phone-2" || failed.Code != ErrCodeNotFound || failed.Retryable {
+		t.Errorf("expected non-retryable not_found nack for phone-2, got %s %+v", nack.Type, failed)
+	}
+
+	if saved := len(messageRepo.(*mockMessageRepo).messages); saved != 1 {
+		t.Errorf("expected the message to be saved once, got %d", saved)
+	}
+	delivered := readEnvelopeOfType(t, watcher, TypeMessage)
+	var msg ChatMessage
+	json.Unmarshal(delivered.Payload, &msg)
+	if msg.ID != first.MessageID || msg.ClientMessageID != "phone-1" {
+		t.Errorf("expected message %d from phone-1, got %+v", first.MessageID, msg)
+	}
+	watcher.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	for {
+		_, data, err := watcher.ReadMessage()
+		if err != nil {
+			break
+		}
+		var env Envelope
+		json.Unmarshal(data, &env)
+		if env.Type == TypeMessage {
+			t.Fatalf("expected the retry not to be broadcast, got %s", data)
+		}
+	}
+}
+
+func TestClient_ReconnectReplaysMissedMessagesBeforeLive(t *testing.T) {
+	messageRepo = &mockMessageRepo{}
+	reactionRepo = &mockReactionRepo{}
+	seedMessages(t, "1", 3)
+
+	config := heartbeatConfig()
+	config.PingInterval = time.Minute
+	config.PongWait = time.Minute
+	config.MaxMessageSize = 4096
+	config.ReplayLimit = 5
+	hub := NewHubWithConfig(NewMemoryBroker(), config)
+	go hub.Run()
+
+	var since uint
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		client := hub.NewClient(conn, "1")
+		client.UserID = 1
+		client.resumeSince = since
+		hub.serveClient(client)
+	}))
+	t.Cleanup(server.Close)
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	since = 1
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	for _, want := range []uint{2, 3} {
+		env := readEnvelopeOfType(t, conn, TypeMessage)
+		var msg ChatMessage
+		json.Unmarshal(env.Payload, &msg)
+		if msg.ID != want {
+			t.Fatalf("expected replayed message %d, got %d", want, msg.ID)
+		}
+	}
+
+	// The connection is live once the replay is done
+	conn.WriteJSON(map[string]interface{}{"v": 1, "type": TypeMessage, "id": "m1", "payload": map[string]interface{}{"content": "live"}})
+	if ack := readEnvelopeOfType(t, conn, TypeAck); ack.ID != "m1" {
+		t.Errorf("expected ack for m1, got %s", ack.ID)
+	}
+
+	// A gap larger than the window asks the client to reload the history
+	seedMessages(t, "1", 5)
+	conn.WriteJSON(map[string]interface{}{"v": 1, "type": TypeResume, "id": "r1", "payload": map[string]interface{}{"since": 1}})
+	resync := readEnvelopeOfType(t, conn, TypeResyncRequired)
+	var payload ResyncPayload
+	json.Unmarshal(resync.Payload, &payload)
+	if payload.Reason != ResyncTooManyMissed || payload.Since != 1 || payload.ReplayLimit != 5 {
+		t.Errorf("expected too_many_missed resync since 1 with limit 5, got %+v", payload)
+	}
+}
+
+func TestClient_ReleaseLiveSkipsReplayedMessages(t *testing.T) {
+	client := &Client{RoomID: "1", Send: make(chan []byte, 8)}
+	client.holdLive()
+
+	for _, id := range []uint{2, 3} {
+		env, _ := NewEnvelope(TypeMessage, "", "1", ChatMessage{ID: id})
+		data, _ := json.Marshal(env)
+		if !client.trySend(data) {
+			t.Fatal("expected live frame to be held")
+		}
+	}
+	if len(client.Send) != 0 {
+		t.Fatal("expected no live frame to be queued during the replay")
+	}
+
+	client.releaseLive(2)
+
+	if len(client.Send) != 1 {
+		t.Fatalf("expected only the message newer than the replay, got %d frames", len(client.Send))
+	}
+	var env Envelope
+	json.Unmarshal(<-client.Send, &env)
+	var msg ChatMessage
+	json.Unmarshal(env.Payload, &msg)
+	if msg.ID != 3 {
+		t.Errorf("expected message 3, got %d", msg.ID)
+	}
+}
+
+// Mock do repository de mensagens que falha ao buscar as mensagens perdidas
+type failingFindSinceRepo struct {
+	*mockMessageRepo
+}
+
+func (m *failingFindSinceRepo) FindSince(roomID string, since uint, limit int) ([]StoredMessage, error) {
+	return nil, errors.New("database down")
+}
+
+func TestHub_ReplayReportsUnavailableWhenMessagesCannotBeLoaded(t *testing.T) {
+	messageRepo = &failingFindSinceRepo{mockMessageRepo: &mockMessageRepo{}}
+	reactionRepo = &mockReactionRepo{}
+	hub := NewHubWithConfig(NewMemoryBroker(), HubConfig{SendBufferSize: 16, ReplayLimit: 5})
+	client := newTestClient(hub, "1", 1)
+
+	hub.replay(client, 1)
+
+	resync := nextEnvelope(t, client)
+	var payload ResyncPayload
+	json.Unmarshal(resync.Payload, &payload)
+	if resync.Type != TypeResyncRequired || payload.Reason != ResyncUnavailable {
+		t.Errorf("expected resync_required with reason %s, got %s %+v", ResyncUnavailable, resync.Type, payload)
+	}
+}
@@ -2,11 +2,14 @@ package database
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 
+	"go-chat-live/internal/logging"
+
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
 )
 
 // DB é a instância global do banco de dados PostgreSQL
@@ -51,12 +54,31 @@ func ConnectDB() {
 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s",
 		host, user, password, dbname, port, sslmode)
 
-	log.Printf("Connecting to PostgreSQL: %s:%s/%s", host, port, dbname)
+	slog.Info("connecting to PostgreSQL", "host", host, "port", port, "database", dbname)
+
+	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: slogLogger{level: gormlogger.Warn}})
+	if err != nil {
+		logging.Fatal("database connection error", logging.Err(err))
+	}
+
+	if err := registerMetricsCallbacks(DB); err != nil {
+		logging.Fatal("could not register database metrics", logging.Err(err))
+	}
+
+	slog.Info("PostgreSQL connection established")
+}
+
+// CloseDB fecha o pool de conexões com o banco de dados.
+func CloseDB() error {
+	if DB == nil {
+		return nil
+	}
 
-	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	sqlDB, err := DB.DB()
 	if err != nil {
-		log.Fatal("database connection error:", err)
+		return err
 	}
 
-	log.Println("PostgreSQL connection established successfully!")
+	slog.Info("closing PostgreSQL connection pool")
+	return sqlDB.Close()
 }
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go-chat-live/internal/logging"
+
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// slowQueryThreshold is the duration above which statements are logged as warnings
+const slowQueryThreshold = 200 * time.Millisecond
+
+// slogLogger writes GORM logs through slog, so they are JSON lines at the
+// configured level with emails and tokens in the SQL redacted. Failed
+// statements are errors, slow ones warnings and the others debug lines.
+type slogLogger struct {
+	level gormlogger.LogLevel
+}
+
+func (l slogLogger) LogMode(level gormlogger.LogLevel) gormlogger.Interface {
+	return slogLogger{level: level}
+}
+
+func (l slogLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= gormlogger.Info {
+		logging.FromContext(ctx).InfoContext(ctx, fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l slogLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= gormlogger.Warn {
+		logging.FromContext(ctx).WarnContext(ctx, fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l slogLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= gormlogger.Error {
+		logging.FromContext(ctx).ErrorContext(ctx, fmt.Sprintf(msg, args...))
+	}
+}
+
+// Trace logs a statement once it ran. Not finding a record is an expected result, not a failure.
+func (l slogLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.level <= gormlogger.Silent {
+		return
+	}
+	logger := logging.FromContext(ctx)
+	elapsed := time.Since(begin)
+
+	level := slog.LevelDebug
+	msg := "query"
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && l.level >= gormlogger.Error:
+		level, msg = slog.LevelError, "query failed"
+	case elapsed > slowQueryThreshold && l.level >= gormlogger.Warn:
+		level, msg = slog.LevelWarn, "slow query"
+	}
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+
+	sql, rows := fc()
+	attrs := []slog.Attr{
+		slog.String("sql", sql),
+		slog.Int64("rows", rows),
+		slog.Float64("duration_ms", float64(elapsed.Microseconds())/1000),
+	}
+	if level == slog.LevelError {
+		attrs = append(attrs, logging.Err(err))
+	}
+	logger.LogAttrs(ctx, level, msg, attrs...)
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"go-chat-live/internal/metrics"
+
+	"gorm.io/gorm"
+)
+
+// startTimeKey stores when a statement started in the instance settings of a *gorm.DB
+const startTimeKey = "metrics:start_time"
+
+var (
+	queryDuration = metrics.NewHistogram("db_query_duration_seconds",
+		"Database statement latency in seconds, by operation and table.", nil, "operation", "table")
+	queryErrors = metrics.NewCounter("db_query_errors_total",
+		"Database statements that failed, by operation and table.", "operation", "table")
+)
+
+// registerMetricsCallbacks times every statement GORM runs with callbacks
+// around each of its operations.
+func registerMetricsCallbacks(db *gorm.DB) error {
+	callbacks := db.Callback()
+	register := []struct {
+		operation string
+		before    func(string, func(*gorm.DB)) error
+		after     func(string, func(*gorm.DB)) error
+	}{
+		{"create", callbacks.Create().Before("gorm:create").Register, callbacks.Create().After("gorm:create").Register},
+		{"query", callbacks.Query().Before("gorm:query").Register, callbacks.Query().After("gorm:query").Register},
+		{"update", callbacks.Update().Before("gorm:update").Register, callbacks.Update().After("gorm:update").Register},
+		{"delete", callbacks.Delete().Before("gorm:delete").Register, callbacks.Delete().After("gorm:delete").Register},
+		{"row", callbacks.Row().Before("gorm:row").Register, callbacks.Row().After("gorm:row").Register},
+		{"raw", callbacks.Raw().Before("gorm:raw").Register, callbacks.Raw().After("gorm:raw").Register},
+	}
+	for _, r := range register {
+		if err := r.before("metrics:before_"+r.operation, startTimer); err != nil {
+			return err
+		}
+		if err := r.after("metrics:after_"+r.operation, observeQuery(r.operation)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startTimer records when the statement started
+func startTimer(db *gorm.DB) {
+	db.InstanceSet(startTimeKey, time.Now())
+}
+
+// observeQuery records the latency of the statement and whether it failed.
+// Not finding a record is an expected result, not a failure.
+func observeQuery(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(startTimeKey)
+		if !ok {
+			return
+		}
+		start, ok := value.(time.Time)
+		if !ok {
+			return
+		}
+
+		table := db.Statement.Table
+		if table == "" {
+			table = "unknown"
+		}
+		queryDuration.Observe(time.Since(start).Seconds(), operation, table)
+		if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+			queryErrors.Inc(operation, table)
+		}
+	}
+}
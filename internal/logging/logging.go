@@ -0,0 +1,80 @@
+// Package logging configures the JSON structured logging of both servers and
+// carries the request and connection IDs that correlate log lines.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Setup makes a JSON logger writing to stdout the default slog logger, at the
+// level read from LOG_LEVEL (debug, info, warn or error; info by default).
+// Lines written through the standard log package go through it as well.
+func Setup() {
+	level, ok := ParseLevel(os.Getenv("LOG_LEVEL"))
+	logger := slog.New(NewHandler(os.Stdout, level))
+	slog.SetDefault(logger)
+	if !ok {
+		logger.Warn("invalid LOG_LEVEL, using info", "value", os.Getenv("LOG_LEVEL"))
+	}
+}
+
+// NewHandler creates a JSON handler that redacts emails, tokens and passwords.
+func NewHandler(w io.Writer, level slog.Leveler) slog.Handler {
+	return slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr})
+}
+
+// ParseLevel parses a LOG_LEVEL value. Empty and invalid values give info,
+// reporting false for invalid ones.
+func ParseLevel(value string) (slog.Level, bool) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "debug":
+		return slog.LevelDebug, true
+	case "", "info":
+		return slog.LevelInfo, true
+	case "warn", "warning":
+		return slog.LevelWarn, true
+	case "error":
+		return slog.LevelError, true
+	}
+	return slog.LevelInfo, false
+}
+
+// Fatal logs an error and exits, for failures that prevent a server from starting.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// Err is the attribute logged for an error.
+func Err(err error) slog.Attr {
+	return slog.Any("error", err)
+}
+
+// loggerKey is the context key of the request logger
+type loggerKey struct{}
+
+// WithLogger returns a context carrying the logger, so handlers log with its attributes.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger carried by the context, or the default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// NewID generates a random ID for a request or a connection.
+func NewID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,128 @@
+package logging
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the ID that correlates the log lines of a request.
+// An ID sent by the client or a proxy is kept; otherwise one is generated.
+// Either way it is returned in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength caps the length of a propagated request ID
+const maxRequestIDLength = 128
+
+// requestID returns the propagated request ID when it is safe to log, or a new one
+func requestID(header string) string {
+	if header == "" || len(header) > maxRequestIDLength {
+		return NewID()
+	}
+	for _, r := range header {
+		if r < 0x21 || r > 0x7e {
+			return NewID()
+		}
+	}
+	return header
+}
+
+// startRequest assigns the request ID, returns it in the response header and
+// returns a context carrying a logger with it
+func startRequest(ctx context.Context, w http.ResponseWriter, header string) (context.Context, *slog.Logger) {
+	id := requestID(header)
+	w.Header().Set(RequestIDHeader, id)
+	logger := FromContext(ctx).With("request_id", id)
+	return WithLogger(ctx, logger), logger
+}
+
+// logRequest writes the access log line of a request: server errors at error
+// level, everything else at info. Only the path is logged, never the query,
+// which may carry tokens or URL signatures.
+func logRequest(ctx context.Context, logger *slog.Logger, r *http.Request, route string, status int, start time.Time) {
+	level := slog.LevelInfo
+	if status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	logger.LogAttrs(ctx, level, "request",
+		slog.String("method", r.Method),
+		slog.String("route", route),
+		slog.String("path", r.URL.Path),
+		slog.Int("status", status),
+		slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+	)
+}
+
+// GinMiddleware assigns each request an ID, makes a logger carrying it
+// available through FromContext(c.Request.Context()) and logs the request
+// with its route template and status once handled.
+func GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		ctx, logger := startRequest(c.Request.Context(), c.Writer, c.GetHeader(RequestIDHeader))
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		if len(c.Errors) > 0 {
+			logger = logger.With("errors", c.Errors.String())
+		}
+		logRequest(ctx, logger, c.Request, route, c.Writer.Status(), start)
+	}
+}
+
+// HTTPMiddleware is GinMiddleware for net/http handlers such as the WebSocket
+// server. The route is the ServeMux pattern that matched the request.
+func HTTPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx, logger := startRequest(r.Context(), w, r.Header.Get(RequestIDHeader))
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		r = r.WithContext(ctx)
+		next.ServeHTTP(recorder, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		logRequest(ctx, logger, r, route, recorder.status, start)
+	})
+}
+
+// statusRecorder keeps the status written by a handler. It supports hijacking
+// so WebSocket upgrades still work, recording them as 101 Switching Protocols.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,71 @@
+package logging
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// redacted replaces sensitive values in log lines
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute keys whose values are never logged, compared
+// in lower case without separators
+var sensitiveKeys = map[string]bool{
+	"password":      true,
+	"newpassword":   true,
+	"token":         true,
+	"accesstoken":   true,
+	"refreshtoken":  true,
+	"authorization": true,
+	"secret":        true,
+	"email":         true,
+	"to":            true, // Recipient of an email
+}
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	jwtPattern   = regexp.MustCompile(`eyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]*`)
+	// Secrets passed as query parameters or key=value pairs, e.g. links in emails
+	paramPattern = regexp.MustCompile(`(?i)\b(token|access_token|refresh_token|password|signature)=[^&\s"']+`)
+	// Bearer credentials in an Authorization header value
+	bearerPattern = regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/\-]+=*`)
+)
+
+// Redact masks emails, JWTs, bearer credentials and secret parameters in free text.
+func Redact(s string) string {
+	if s == "" {
+		return s
+	}
+	s = jwtPattern.ReplaceAllString(s, redacted)
+	s = bearerPattern.ReplaceAllString(s, "Bearer "+redacted)
+	s = paramPattern.ReplaceAllString(s, "${1}="+redacted)
+	return emailPattern.ReplaceAllString(s, redacted)
+}
+
+// isSensitiveKey reports whether values logged under the key are always secret
+func isSensitiveKey(key string) bool {
+	key = strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(key))
+	return sensitiveKeys[key]
+}
+
+// redactAttr is the ReplaceAttr of the handler: it drops the values of sensitive
+// keys and masks secrets found in messages, strings and errors.
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindGroup {
+		return a
+	}
+	if isSensitiveKey(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Redact(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, Redact(err.Error()))
+		}
+	}
+	return a
+}
@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	httpRequests = NewCounter("http_requests_total",
+		"HTTP requests handled, by method, route and status.", "method", "route", "status")
+	httpDuration = NewHistogram("http_request_duration_seconds",
+		"HTTP request latency in seconds, by method, route and status.", nil, "method", "route", "status")
+)
+
+// GinMiddleware records the count and latency of requests by route template
+// (e.g. /rooms/:id) and status. Requests matching no route are recorded under
+// "unmatched" and non-standard methods under "OTHER" so arbitrary requests
+// cannot create new series.
+func GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := methodLabel(c.Request.Method)
+		status := strconv.Itoa(c.Writer.Status())
+		httpRequests.Inc(method, route, status)
+		httpDuration.Observe(time.Since(start).Seconds(), method, route, status)
+	}
+}
+
+// methodLabel returns the method for the standard HTTP methods and "OTHER" for
+// any other, so clients sending made-up methods cannot create new series.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
@@ -0,0 +1,390 @@
+// Package metrics keeps counters, gauges and histograms for both servers and
+// exposes them in the Prometheus text format on /metrics.
+//
+// It implements the small subset of prometheus/client_golang the servers use
+// (labelled counters, gauges, histograms and gauge or counter functions read at scrape
+// time) instead of importing it. client_golang would add its protobuf model,
+// common and procfs modules to both binaries for features we do not use, and
+// the text format written here is a stable, versioned spec covered by the
+// tests. Should the servers need summaries, exemplars or the Go runtime
+// collectors, the exported NewCounter/NewGauge/NewHistogram API maps one to one
+// onto client_golang vectors, so the switch stays local to this package.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metric types, as written in the TYPE line of the exposition format
+const (
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+)
+
+// DefBuckets are the default histogram buckets, in seconds, suited to request latencies.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metric is a family of samples sharing a name, written at scrape time
+type metric interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// Registry holds metric families and writes them in the Prometheus text format.
+// It is safe for concurrent use.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// Default is the registry served by Handler. The package level constructors register in it.
+var Default = NewRegistry()
+
+// register adds a metric, panicking on duplicate names since that is a programming error
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[m.name()] {
+		panic("metrics: duplicate metric " + m.name())
+	}
+	r.names[m.name()] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteTo writes every metric family in the text format, sorted by name.
+func (r *Registry) WriteTo(w *bufio.Writer) error {
+	r.mu.Lock()
+	metrics := slices.Clone(r.metrics)
+	r.mu.Unlock()
+
+	slices.SortFunc(metrics, func(a, b metric) int { return strings.Compare(a.name(), b.name()) })
+	for _, m := range metrics {
+		m.write(w)
+	}
+	return w.Flush()
+}
+
+// Handler serves the metrics of the registry in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(bufio.NewWriter(w))
+	})
+}
+
+// Handler serves the metrics of the Default registry.
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+// NewServer creates an HTTP server exposing the Default registry on /metrics at
+// addr. It is meant for an internal address, apart from the public listener,
+// since the metrics reveal rooms and traffic and are served without authentication.
+func NewServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	return &http.Server{Addr: addr, Handler: mux}
+}
+
+// family holds what every metric type shares: its name, help text and label names
+type family struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+}
+
+func (f *family) name() string {
+	return f.metricName
+}
+
+// writeHeader writes the HELP and TYPE lines of the family
+func (f *family) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.metricName, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.metricName, f.kind)
+}
+
+// writeSample writes one sample line with the family's labels plus any extra label pair
+func (f *family) writeSample(w *bufio.Writer, suffix string, labelValues []string, extraName, extraValue string, value float64) {
+	w.WriteString(f.metricName + suffix)
+	if len(f.labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, label := range f.labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			writeLabel(w, label, labelValues[i])
+		}
+		if extraName != "" {
+			if len(f.labels) > 0 {
+				w.WriteByte(',')
+			}
+			writeLabel(w, extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+// checkLabels panics when a sample does not provide a value for each label
+func (f *family) checkLabels(labelValues []string) {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.metricName, len(f.labels), len(labelValues)))
+	}
+}
+
+// labelEscaper escapes label values as required by the text format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeLabel(w *bufio.Writer, name, value string) {
+	w.WriteString(name)
+	w.WriteString(`="`)
+	w.WriteString(labelEscaper.Replace(value))
+	w.WriteByte('"')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// seriesKey identifies the series of a set of label values
+func seriesKey(labelValues []string) string {
+	return strings.Join(labelValues, "\xff")
+}
+
+// valueSeries is a counter or gauge value with its label values
+type valueSeries struct {
+	labelValues []string
+	value       float64
+}
+
+// valueVec stores the series of a counter or gauge family
+type valueVec struct {
+	family
+	mu     sync.Mutex
+	series map[string]*valueSeries
+}
+
+func newValueVec(name, help, kind string, labels []string) *valueVec {
+	return &valueVec{
+		family: family{metricName: name, help: help, kind: kind, labels: labels},
+		series: make(map[string]*valueSeries),
+	}
+}
+
+// update applies fn to the value of the series, creating it at zero
+func (v *valueVec) update(labelValues []string, fn func(float64) float64) {
+	v.checkLabels(labelValues)
+	key := seriesKey(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = &valueSeries{labelValues: slices.Clone(labelValues)}
+		v.series[key] = s
+	}
+	s.value = fn(s.value)
+}
+
+func (v *valueVec) write(w *bufio.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.writeHeader(w)
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		s := v.series[key]
+		v.writeSample(w, "", s.labelValues, "", "", s.value)
+	}
+}
+
+// Counter is a value that only goes up, optionally split by labels.
+type Counter struct {
+	vec *valueVec
+}
+
+// NewCounter creates a counter registered in r. Samples must pass one value per label.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{vec: newValueVec(name, help, kindCounter, labels)}
+	r.register(c.vec)
+	return c
+}
+
+// NewCounter creates a counter registered in the Default registry.
+func NewCounter(name, help string, labels ...string) *Counter {
+	return Default.NewCounter(name, help, labels...)
+}
+
+// Inc adds one to the series of the label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds a non-negative delta to the series of the label values.
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+	c.vec.update(labelValues, func(v float64) float64 { return v + delta })
+}
+
+// Gauge is a value that can go up and down, optionally split by labels.
+type Gauge struct {
+	vec *valueVec
+}
+
+// NewGauge creates a gauge registered in r. Samples must pass one value per label.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{vec: newValueVec(name, help, kindGauge, labels)}
+	r.register(g.vec)
+	return g
+}
+
+// NewGauge creates a gauge registered in the Default registry.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return Default.NewGauge(name, help, labels...)
+}
+
+// Set sets the series of the label values.
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.vec.update(labelValues, func(float64) float64 { return value })
+}
+
+// Add adds delta, which may be negative, to the series of the label values.
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.vec.update(labelValues, func(v float64) float64 { return v + delta })
+}
+
+// histogramSeries holds the observations of one histogram series
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64 // Observations per bucket, not cumulative; the last one is +Inf
+	sum         float64
+	count       uint64
+}
+
+// Histogram counts observations (e.g. latencies) in buckets, optionally split by labels.
+type Histogram struct {
+	family
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+// NewHistogram creates a histogram with the given upper bounds, registered in r.
+// Nil buckets use DefBuckets.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	h := &Histogram{
+		family:  family{metricName: name, help: help, kind: kindHistogram, labels: labels},
+		buckets: slices.Sorted(slices.Values(buckets)),
+		series:  make(map[string]*histogramSeries),
+	}
+	r.register(h)
+	return h
+}
+
+// NewHistogram creates a histogram registered in the Default registry.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labels...)
+}
+
+// Observe records a value in the series of the label values.
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.checkLabels(labelValues)
+	key := seriesKey(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labelValues: slices.Clone(labelValues), counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	i, _ := slices.BinarySearch(h.buckets, value)
+	s.counts[i]++
+	s.sum += value
+	s.count++
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		var cumulative uint64
+		for i, count := range s.counts {
+			cumulative += count
+			le := "+Inf"
+			if i < len(h.buckets) {
+				le = formatFloat(h.buckets[i])
+			}
+			h.writeSample(w, "_bucket", s.labelValues, "le", le, float64(cumulative))
+		}
+		h.writeSample(w, "_sum", s.labelValues, "", "", s.sum)
+		h.writeSample(w, "_count", s.labelValues, "", "", float64(s.count))
+	}
+}
+
+// Emit reports one sample of a function metric with its label values.
+type Emit func(value float64, labelValues ...string)
+
+// funcMetric is a counter or gauge whose samples are read at scrape time
+type funcMetric struct {
+	family
+	collect func(emit Emit)
+}
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	f.writeHeader(w)
+	f.collect(func(value float64, labelValues ...string) {
+		f.checkLabels(labelValues)
+		f.writeSample(w, "", labelValues, "", "", value)
+	})
+}
+
+// NewGaugeFunc registers a gauge whose samples collect reports at scrape time,
+// for values already tracked elsewhere such as open connections.
+func (r *Registry) NewGaugeFunc(name, help string, collect func(emit Emit), labels ...string) {
+	r.register(&funcMetric{family: family{metricName: name, help: help, kind: kindGauge, labels: labels}, collect: collect})
+}
+
+// NewCounterFunc registers a counter whose samples collect reports at scrape time,
+// for counters already tracked elsewhere.
+func (r *Registry) NewCounterFunc(name, help string, collect func(emit Emit), labels ...string) {
+	r.register(&funcMetric{family: family{metricName: name, help: help, kind: kindCounter, labels: labels}, collect: collect})
+}
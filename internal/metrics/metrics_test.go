@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// scrape returns the exposition of a registry
+func scrape(t *testing.T, r *Registry) string {
+	t.Helper()
+	var out strings.Builder
+	if err := r.WriteTo(bufio.NewWriter(&out)); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func expectLines(t *testing.T, output string, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if !strings.Contains(output, line+"\n") {
+			t.Errorf("expected line %q in output:\n%s", line, output)
+		}
+	}
+}
+
+func TestRegistry_WritesTextFormat(t *testing.T) {
+	r := NewRegistry()
+	logins := r.NewCounter("logins_total", "Logins by result.", "result")
+	queue := r.NewGauge("queue_depth", "Queued frames.")
+	latency := r.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+	r.NewGaugeFunc("connections", "Open connections.", func(emit Emit) {
+		emit(3, `room "a"`)
+	}, "room")
+
+	logins.Inc("success")
+	logins.Add(2, "failure")
+	queue.Set(5)
+	queue.Add(-2)
+	latency.Observe(0.05, "/rooms/:id")
+	latency.Observe(0.5, "/rooms/:id")
+	latency.Observe(3, "/rooms/:id")
+
+	expectLines(t, scrape(t, r),
+		"# HELP logins_total Logins by result.",
+		"# TYPE logins_total counter",
+		`logins_total{result="failure"} 2`,
+		`logins_total{result="success"} 1`,
+		"# TYPE queue_depth gauge",
+		"queue_depth 3",
+		"# TYPE latency_seconds histogram",
+		`latency_seconds_bucket{route="/rooms/:id",le="0.1"} 1`,
+		`latency_seconds_bucket{route="/rooms/:id",le="1"} 2`,
+		`latency_seconds_bucket{route="/rooms/:id",le="+Inf"} 3`,
+		`latency_seconds_sum{route="/rooms/:id"} 3.55`,
+		`latency_seconds_count{route="/rooms/:id"} 3`,
+		`connections{room="room \"a\""} 3`,
+	)
+}
+
+func TestRegistry_RejectsDuplicateNames(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("requests_total", "Requests.")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering the same name twice to panic")
+		}
+	}()
+	r.NewGauge("requests_total", "Requests.")
+}
+
+func TestGinMiddleware_RecordsRouteTemplate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(GinMiddleware())
+	router.GET("/rooms/:id", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	for _, path := range []string{"/rooms/1", "/rooms/2", "/missing"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	// As métricas ficam só no servidor interno, não no router público
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected /metrics to be absent from the public router, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	NewServer("").Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("expected the text exposition content type, got %q", ct)
+	}
+	expectLines(t, w.Body.String(),
+		`http_requests_total{method="GET",route="/rooms/:id",status="204"} 2`,
+		`http_requests_total{method="GET",route="unmatched",status="404"} 2`,
+		`http_request_duration_seconds_count{method="GET",route="/rooms/:id",status="204"} 2`,
+	)
+}
+
+func TestGinMiddleware_GroupsNonStandardMethods(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(GinMiddleware())
+	router.Handle("PURGE", "/cache", func(c *gin.Context) { c.Status(http.StatusAccepted) })
+
+	for _, method := range []string{"PURGE", "FOO", "BAR"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/cache", nil))
+	}
+
+	w := httptest.NewRecorder()
+	NewServer("").Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	expectLines(t, w.Body.String(),
+		`http_requests_total{method="OTHER",route="/cache",status="202"} 1`,
+		`http_requests_total{method="OTHER",route="unmatched",status="404"} 2`,
+	)
+	if strings.Contains(w.Body.String(), `method="FOO"`) {
+		t.Error("expected non-standard methods to be recorded as OTHER")
+	}
+}
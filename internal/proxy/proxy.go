@@ -0,0 +1,97 @@
+// Package proxy reads the TRUSTED_PROXIES setting shared by the servers and
+// resolves the address of clients connecting through those proxies.
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// FromEnv reads the comma separated addresses or CIDRs of TRUSTED_PROXIES.
+// When unset no proxy is trusted and the client IP is the peer address.
+func FromEnv() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// Trusted is a set of trusted proxy networks. The nil value trusts no proxy.
+type Trusted struct {
+	prefixes []netip.Prefix
+}
+
+// Parse builds the set from IP addresses and CIDRs, the format gin accepts in
+// SetTrustedProxies.
+func Parse(proxies []string) (*Trusted, error) {
+	t := &Trusted{}
+	for _, proxy := range proxies {
+		if strings.Contains(proxy, "/") {
+			prefix, err := netip.ParsePrefix(proxy)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+			}
+			t.prefixes = append(t.prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		addr = addr.Unmap()
+		t.prefixes = append(t.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return t, nil
+}
+
+// contains reports whether the address belongs to a trusted proxy
+func (t *Trusted) contains(ip string) bool {
+	if t == nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range t.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client. X-Forwarded-For is honored only
+// when the connection comes from a trusted proxy; its entries are walked from
+// the right, skipping trusted proxies, like gin does, so a client cannot spoof
+// its address by sending the header itself.
+func (t *Trusted) ClientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !t.contains(ip) {
+		return ip
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		ip = hop
+		if !t.contains(hop) {
+			break
+		}
+	}
+	return ip
+}
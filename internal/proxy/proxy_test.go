@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrusted_ClientIP(t *testing.T) {
+	trusted, err := Parse([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		trusted   *Trusted
+		remote    string
+		forwarded string
+		want      string
+	}{
+		{"sem proxy confiável ignora o cabeçalho", nil, "203.0.113.7:5000", "1.2.3.4", "203.0.113.7"},
+		{"peer não confiável ignora o cabeçalho", trusted, "203.0.113.7:5000", "1.2.3.4", "203.0.113.7"},
+		{"proxy confiável repassa o cliente", trusted, "10.1.2.3:5000", "1.2.3.4", "1.2.3.4"},
+		{"pula proxies confiáveis da cadeia", trusted, "10.1.2.3:5000", "1.2.3.4, 192.168.1.1", "1.2.3.4"},
+		{"entrada forjada à esquerda é ignorada", trusted, "10.1.2.3:5000", "6.6.6.6, 1.2.3.4", "1.2.3.4"},
+		{"proxy sem cabeçalho usa o peer", trusted, "10.1.2.3:5000", "", "10.1.2.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/ws", nil)
+			r.RemoteAddr = tt.remote
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if got := tt.trusted.ClientIP(r); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParse_RejectsInvalidEntries(t *testing.T) {
+	if _, err := Parse([]string{"not-an-ip"}); err == nil {
+		t.Error("expected an error for an invalid address")
+	}
+	if _, err := Parse([]string{"10.0.0.0/99"}); err == nil {
+		t.Error("expected an error for an invalid CIDR")
+	}
+}
@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Lockout locks a key (e.g. an account email) after too many consecutive
+// failures. Each failure once the key reached the limit doubles the lock, up
+// to a maximum; a success resets the key. It is safe for concurrent use.
+type Lockout struct {
+	maxFailures int
+	base        time.Duration
+	max         time.Duration
+	now         func() time.Time // Clock; replaced in tests
+
+	mu        sync.Mutex
+	entries   map[string]*lockoutEntry
+	lastSweep time.Time
+}
+
+// lockoutEntry is the failure state of a key
+type lockoutEntry struct {
+	failures    int       // Consecutive failures
+	lastFailure time.Time // Time of the latest failure
+	lockedUntil time.Time // Attempts are refused until this time
+}
+
+// NewLockout creates a Lockout that locks a key for base after maxFailures
+// consecutive failures, doubling on every further failure up to max.
+func NewLockout(maxFailures int, base, max time.Duration) *Lockout {
+	return &Lockout{
+		maxFailures: maxFailures,
+		base:        base,
+		max:         max,
+		now:         time.Now,
+		entries:     make(map[string]*lockoutEntry),
+	}
+}
+
+// Locked returns how long the key stays locked, zero when attempts are allowed.
+func (l *Lockout) Locked(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.entries[key]
+	if !ok {
+		return 0
+	}
+	return max(0, entry.lockedUntil.Sub(l.now()))
+}
+
+// Fail records a failed attempt and returns how long the key is now locked,
+// zero when it is still below the limit.
+func (l *Lockout) Fail(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	entry, ok := l.entries[key]
+	if !ok {
+		entry = &lockoutEntry{}
+		l.entries[key] = entry
+	}
+	entry.failures++
+	entry.lastFailure = now
+	if entry.failures < l.maxFailures {
+		return 0
+	}
+
+	lock := l.base
+	for i := l.maxFailures; i < entry.failures && lock < l.max; i++ {
+		lock *= 2
+	}
+	lock = min(lock, l.max)
+	entry.lockedUntil = now.Add(lock)
+	return lock
+}
+
+// Reset clears the failures of a key after a successful attempt.
+func (l *Lockout) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key)
+}
+
+// sweep drops keys that are not locked and have not failed within the maximum
+// lock duration, so old failures are eventually forgotten. Callers must hold l.mu.
+func (l *Lockout) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, entry := range l.entries {
+		if now.After(entry.lockedUntil) && now.Sub(entry.lastFailure) > l.max {
+			delete(l.entries, key)
+		}
+	}
+}
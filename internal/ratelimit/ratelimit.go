@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often keyed limiters drop the state of idle keys
+const sweepInterval = time.Minute
+
+// Bucket is a token bucket: it holds up to Burst tokens, refills at Rate tokens
+// per second and each allowed event takes one token. It is not safe for
+// concurrent use; Limiter wraps buckets with a lock.
+type Bucket struct {
+	rate   float64   // Tokens added per second
+	burst  float64   // Bucket capacity
+	tokens float64   // Tokens available at last
+	last   time.Time // Time tokens was last updated
+}
+
+// NewBucket creates a full bucket refilling at rate tokens per second with room for burst tokens.
+func NewBucket(rate float64, burst int) *Bucket {
+	return &Bucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// Allow takes a token at time now. When the bucket is empty it reports false
+// and how long to wait until a token is available.
+func (b *Bucket) Allow(now time.Time) (bool, time.Duration) {
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	return false, wait
+}
+
+// refill adds the tokens earned since the last update
+func (b *Bucket) refill(now time.Time) {
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+}
+
+// full reports whether the bucket would be full at time now, meaning its key has been idle
+func (b *Bucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst
+}
+
+// Limiter applies a token bucket per key (user ID, IP address, ...). It is safe
+// for concurrent use. Buckets of idle keys are dropped periodically.
+type Limiter struct {
+	rate  float64
+	burst int
+	now   func() time.Time // Clock; replaced in tests
+
+	mu        sync.Mutex
+	buckets   map[string]*Bucket
+	lastSweep time.Time
+}
+
+// NewLimiter creates a Limiter allowing rate events per second per key, with bursts of up to burst events.
+func NewLimiter(rate float64, burst int) *Limiter {
+	return &Limiter{rate: rate, burst: burst, now: time.Now, buckets: make(map[string]*Bucket)}
+}
+
+// Allow takes a token for key. When the key is over its limit it reports false
+// and how long to wait before retrying.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = NewBucket(l.rate, l.burst)
+		l.buckets[key] = bucket
+	}
+	return bucket.Allow(now)
+}
+
+// Forget drops the state of a key, e.g. when its last connection closes.
+func (l *Limiter) Forget(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.buckets, key)
+}
+
+// sweep drops full buckets, which behave the same as new ones. Callers must hold l.mu.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, bucket := range l.buckets {
+		if bucket.full(now) {
+			delete(l.buckets, key)
+		}
+	}
+}
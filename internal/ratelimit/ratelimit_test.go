@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced clock
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestLimiter_AllowsBurstThenRefills(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	limiter := NewLimiter(2, 3)
+	limiter.now = clock.Now
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.Allow("a"); !ok {
+			t.Fatalf("expected event %d of the burst to be allowed", i+1)
+		}
+	}
+	ok, wait := limiter.Allow("a")
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("expected the 4th event to wait 500ms, got %v %s", ok, wait)
+	}
+	if ok, _ := limiter.Allow("b"); !ok {
+		t.Error("expected keys to be limited independently")
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	if ok, _ := limiter.Allow("a"); !ok {
+		t.Error("expected a token after waiting")
+	}
+	if ok, _ := limiter.Allow("a"); ok {
+		t.Error("expected only one token to be refilled")
+	}
+}
+
+func TestLimiter_SweepsIdleKeys(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	limiter := NewLimiter(1, 1)
+	limiter.now = clock.Now
+
+	limiter.Allow("idle")
+	clock.Advance(2 * sweepInterval)
+	limiter.Allow("active")
+
+	if _, ok := limiter.buckets["idle"]; ok || len(limiter.buckets) != 1 {
+		t.Errorf("expected the idle key to be dropped, got %d buckets", len(limiter.buckets))
+	}
+}
+
+func TestLockout_BacksOffAndResets(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	lockout := NewLockout(3, time.Minute, 3*time.Minute)
+	lockout.now = clock.Now
+
+	if lockout.Fail("a") != 0 || lockout.Fail("a") != 0 {
+		t.Fatal("expected no lock below the failure limit")
+	}
+	if lock := lockout.Fail("a"); lock != time.Minute {
+		t.Fatalf("expected a 1m lock at the limit, got %s", lock)
+	}
+	if wait := lockout.Locked("a"); wait != time.Minute {
+		t.Errorf("expected the key to stay locked for 1m, got %s", wait)
+	}
+	if lock := lockout.Fail("a"); lock != 2*time.Minute {
+		t.Errorf("expected the lock to double, got %s", lock)
+	}
+	if lock := lockout.Fail("a"); lock != 3*time.Minute {
+		t.Errorf("expected the lock capped at 3m, got %s", lock)
+	}
+
+	clock.Advance(3 * time.Minute)
+	if wait := lockout.Locked("a"); wait != 0 {
+		t.Errorf("expected the lock to expire, got %s", wait)
+	}
+
+	lockout.Reset("a")
+	if lockout.Fail("a") != 0 {
+		t.Error("expected a success to reset the failure count")
+	}
+}
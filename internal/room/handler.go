@@ -0,0 +1,191 @@
+package room
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"go-chat-live/internal/user"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AddMemberRequest represents the payload to add a user to a room
+type AddMemberRequest struct {
+	UserID uint `json:"userId"` // ID of the user to add
+}
+
+// CreateRoom handles POST requests to create a new room owned by the authenticated user.
+func CreateRoom(c *gin.Context) {
+	userID, ok := user.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthenticated"})
+		return
+	}
+
+	var room Room
+	if err := c.ShouldBindJSON(&room); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	if err := Create(&room, userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, room)
+}
+
+// ListRooms handles GET requests to return the rooms visible to the authenticated user.
+func ListRooms(c *gin.Context) {
+	userID, ok := user.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthenticated"})
+		return
+	}
+
+	rooms, err := List(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rooms)
+}
+
+// GetRoom handles GET requests to find a specific room by ID.
+func GetRoom(c *gin.Context) {
+	id, userID, ok := parseRequest(c)
+	if !ok {
+		return
+	}
+
+	room, err := FindById(id, userID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, room)
+}
+
+// UpdateRoom handles PUT requests to update a room's data.
+func UpdateRoom(c *gin.Context) {
+	id, userID, ok := parseRequest(c)
+	if !ok {
+		return
+	}
+
+	var updatedData Room
+	if err := c.ShouldBindJSON(&updatedData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	room, err := Update(id, userID, &updatedData)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, room)
+}
+
+// DeleteRoom handles DELETE requests to remove a room.
+func DeleteRoom(c *gin.Context) {
+	id, userID, ok := parseRequest(c)
+	if !ok {
+		return
+	}
+
+	if err := Delete(id, userID); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// JoinRoom handles POST requests for the authenticated user to join a public room.
+func JoinRoom(c *gin.Context) {
+	id, userID, ok := parseRequest(c)
+	if !ok {
+		return
+	}
+
+	member, err := Join(id, userID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, member)
+}
+
+// LeaveRoom handles POST requests for the authenticated user to leave a room.
+func LeaveRoom(c *gin.Context) {
+	id, userID, ok := parseRequest(c)
+	if !ok {
+		return
+	}
+
+	if err := Leave(id, userID); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// AddRoomMember handles POST requests from the room owner to add another user to the room.
+func AddRoomMember(c *gin.Context) {
+	id, userID, ok := parseRequest(c)
+	if !ok {
+		return
+	}
+
+	var req AddMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.UserID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	if _, err := user.FindById(int(req.UserID)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	member, err := AddMember(id, userID, req.UserID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, member)
+}
+
+// parseRequest extracts the room ID path parameter and the authenticated user ID,
+// writing the error response when either is missing or invalid.
+func parseRequest(c *gin.Context) (int, uint, bool) {
+	userID, ok := user.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthenticated"})
+		return 0, 0, false
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return 0, 0, false
+	}
+
+	return id, userID, true
+}
+
+// respondError maps service errors to HTTP status codes
+func respondError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrAlreadyMember), errors.Is(err, ErrNotMember):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
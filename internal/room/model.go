@@ -0,0 +1,39 @@
+// Package room contains domain models and business logic for chat rooms and their members.
+package room
+
+import "time"
+
+// Room visibility values
+const (
+	VisibilityPublic  = "public"  // Anyone can find and join the room
+	VisibilityPrivate = "private" // Only members can see the room; members are added by the owner
+)
+
+// Member role values
+const (
+	RoleOwner  = "owner"  // Creator of the room, can update, delete and add members
+	RoleMember = "member" // Regular participant
+)
+
+// Room represents a chat room that users can join to exchange messages.
+type Room struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`          // Primary key for database
+	Name        string    `gorm:"not null" json:"name"`          // Room display name
+	Description string    `json:"description"`                   // Optional room description
+	OwnerID     uint      `gorm:"index;not null" json:"ownerId"` // ID of the user who created the room
+	Visibility  string    `gorm:"not null" json:"visibility"`    // public or private
+	CreatedAt   time.Time `json:"createdAt"`                     // Creation timestamp
+}
+
+// Member represents the membership of a user in a room.
+type Member struct {
+	RoomID   uint      `gorm:"primaryKey" json:"roomId"` // Room the user belongs to
+	UserID   uint      `gorm:"primaryKey" json:"userId"` // Member user ID
+	Role     string    `gorm:"not null" json:"role"`     // owner or member
+	JoinedAt time.Time `json:"joinedAt"`                 // When the user joined the room
+}
+
+// TableName overrides the default GORM table name.
+func (Member) TableName() string {
+	return "room_members"
+}
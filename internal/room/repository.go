@@ -0,0 +1,107 @@
+package room
+
+import (
+	"go-chat-live/internal/database"
+
+	"gorm.io/gorm"
+)
+
+// RoomRepository defines the interface for room data access operations.
+// This interface follows the Repository pattern to abstract database operations.
+type RoomRepository interface {
+	Create(room *Room, owner *Member) error          // Creates a room and its owner membership
+	FindVisibleTo(userID uint) ([]Room, error)       // Retrieves public rooms and rooms the user belongs to
+	FindByMember(userID uint) ([]Room, error)        // Retrieves the rooms the user is a member of
+	FindById(id int) (*Room, error)                  // Finds room by ID
+	Update(room *Room) error                         // Updates existing room
+	Delete(id int) error                             // Deletes room and its memberships
+	AddMember(member *Member) error                  // Adds a user to a room
+	RemoveMember(roomID, userID uint) error          // Removes a user from a room
+	FindMember(roomID, userID uint) (*Member, error) // Finds the membership of a user in a room
+}
+
+// roomRepositoryImpl implements RoomRepository using GORM ORM.
+type roomRepositoryImpl struct{}
+
+// NewRoomRepository creates a new instance of RoomRepository.
+func NewRoomRepository() RoomRepository {
+	return &roomRepositoryImpl{}
+}
+
+// Create inserts a new room and the membership of its owner in a single
+// transaction, so a room is never left without an owner.
+func (r *roomRepositoryImpl) Create(room *Room, owner *Member) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(room).Error; err != nil {
+			return err
+		}
+		owner.RoomID = room.ID
+		return tx.Create(owner).Error
+	})
+}
+
+// FindVisibleTo retrieves public rooms plus private rooms the user is a member of.
+func (r *roomRepositoryImpl) FindVisibleTo(userID uint) ([]Room, error) {
+	var rooms []Room
+	err := database.DB.
+		Where("visibility = ?", VisibilityPublic).
+		Or("id IN (?)", database.DB.Model(&Member{}).Select("room_id").Where("user_id = ?", userID)).
+		Order("id").
+		Find(&rooms).Error
+	return rooms, err
+}
+
+// FindByMember retrieves the rooms the user is a member of.
+func (r *roomRepositoryImpl) FindByMember(userID uint) ([]Room, error) {
+	var rooms []Room
+	err := database.DB.
+		Where("id IN (?)", database.DB.Model(&Member{}).Select("room_id").Where("user_id = ?", userID)).
+		Order("id").
+		Find(&rooms).Error
+	return rooms, err
+}
+
+// FindById retrieves a room by its ID.
+func (r *roomRepositoryImpl) FindById(id int) (*Room, error) {
+	var room Room
+	err := database.DB.First(&room, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+// Update saves changes to an existing room record.
+func (r *roomRepositoryImpl) Update(room *Room) error {
+	return database.DB.Save(room).Error
+}
+
+// Delete removes a room record and all of its memberships by room ID.
+func (r *roomRepositoryImpl) Delete(id int) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("room_id = ?", id).Delete(&Member{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Room{}, id).Error
+	})
+}
+
+// AddMember inserts a new membership record.
+func (r *roomRepositoryImpl) AddMember(member *Member) error {
+	return database.DB.Create(member).Error
+}
+
+// RemoveMember deletes the membership of a user in a room.
+func (r *roomRepositoryImpl) RemoveMember(roomID, userID uint) error {
+	return database.DB.Where("room_id = ? AND user_id = ?", roomID, userID).Delete(&Member{}).Error
+}
+
+// FindMember retrieves the membership of a user in a room.
+func (r *roomRepositoryImpl) FindMember(roomID, userID uint) (*Member, error) {
+	var member Member
+	err := database.DB.Where("room_id = ? AND user_id = ?", roomID, userID).First(&member).Error
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
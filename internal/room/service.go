@@ -0,0 +1,187 @@
+package room
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Errors returned by the room service, mapped to HTTP status codes by the handlers
+var (
+	ErrNotFound      = errors.New("room not found")
+	ErrForbidden     = errors.New("not allowed to perform this action on the room")
+	ErrAlreadyMember = errors.New("user is already a member of the room")
+	ErrNotMember     = errors.New("user is not a member of the room")
+)
+
+// repo is the global repository instance used by service functions
+var repo = NewRoomRepository()
+
+// Create validates and creates a new room owned by ownerID.
+// The owner is added as the first member of the room in the same transaction.
+func Create(room *Room, ownerID uint) error {
+	if room.Name == "" {
+		return errors.New("name is required")
+	}
+	if room.Visibility == "" {
+		room.Visibility = VisibilityPublic
+	}
+	if room.Visibility != VisibilityPublic && room.Visibility != VisibilityPrivate {
+		return fmt.Errorf("visibility must be %q or %q", VisibilityPublic, VisibilityPrivate)
+	}
+
+	room.ID = 0
+	room.OwnerID = ownerID
+	return repo.Create(room, &Member{
+		UserID:   ownerID,
+		Role:     RoleOwner,
+		JoinedAt: time.Now(),
+	})
+}
+
+// List retrieves the rooms visible to a user
+func List(userID uint) ([]Room, error) {
+	return repo.FindVisibleTo(userID)
+}
+
+// Joined retrieves the rooms the user is a member of
+func Joined(userID uint) ([]Room, error) {
+	return repo.FindByMember(userID)
+}
+
+// FindById retrieves a room by ID. Private rooms are only visible to their members.
+func FindById(id int, userID uint) (*Room, error) {
+	room, err := repo.FindById(id)
+	if err != nil || room == nil {
+		return nil, ErrNotFound
+	}
+	if room.Visibility == VisibilityPrivate && !IsMember(room.ID, userID) {
+		return nil, ErrNotFound
+	}
+	return room, nil
+}
+
+// Update modifies a room's information. Only the owner can update a room.
+func Update(id int, userID uint, newData *Room) (*Room, error) {
+	room, err := FindById(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if room.OwnerID != userID {
+		return nil, ErrForbidden
+	}
+
+	if newData.Name != "" {
+		room.Name = newData.Name
+	}
+	room.Description = newData.Description
+	if newData.Visibility != "" {
+		if newData.Visibility != VisibilityPublic && newData.Visibility != VisibilityPrivate {
+			return nil, fmt.Errorf("visibility must be %q or %q", VisibilityPublic, VisibilityPrivate)
+		}
+		room.Visibility = newData.Visibility
+	}
+
+	if err := repo.Update(room); err != nil {
+		return nil, err
+	}
+	return room, nil
+}
+
+// Delete removes a room and its memberships. Only the owner can delete a room.
+func Delete(id int, userID uint) error {
+	room, err := FindById(id, userID)
+	if err != nil {
+		return err
+	}
+	if room.OwnerID != userID {
+		return ErrForbidden
+	}
+	return repo.Delete(id)
+}
+
+// Join adds the user to a public room.
+// Private rooms can only be joined by being added by the owner.
+func Join(id int, userID uint) (*Member, error) {
+	room, err := FindById(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if IsMember(room.ID, userID) {
+		return nil, ErrAlreadyMember
+	}
+	if room.Visibility == VisibilityPrivate {
+		return nil, ErrForbidden
+	}
+	return addMember(room.ID, userID)
+}
+
+// AddMember adds a user to a room on behalf of the room owner.
+func AddMember(id int, ownerID, userID uint) (*Member, error) {
+	room, err := FindById(id, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if room.OwnerID != ownerID {
+		return nil, ErrForbidden
+	}
+	if IsMember(room.ID, userID) {
+		return nil, ErrAlreadyMember
+	}
+	return addMember(room.ID, userID)
+}
+
+// Leave removes the user from a room. The owner cannot leave its own room.
+func Leave(id int, userID uint) error {
+	room, err := FindById(id, userID)
+	if err != nil {
+		return err
+	}
+	if !IsMember(room.ID, userID) {
+		return ErrNotMember
+	}
+	if room.OwnerID == userID {
+		return ErrForbidden
+	}
+	return repo.RemoveMember(room.ID, userID)
+}
+
+// FindForMember retrieves a room only when the user is one of its members.
+// Used to authorize access to the room's live chat and history.
+func FindForMember(id int, userID uint) (*Room, error) {
+	room, err := repo.FindById(id)
+	if err != nil || room == nil {
+		return nil, ErrNotFound
+	}
+	if !IsMember(room.ID, userID) {
+		return nil, ErrNotMember
+	}
+	return room, nil
+}
+
+// IsMember reports whether the user belongs to the room
+func IsMember(roomID, userID uint) bool {
+	member, err := repo.FindMember(roomID, userID)
+	return err == nil && member != nil
+}
+
+// IsModerator reports whether the user moderates the room's chat, which is
+// the case for the room owner
+func IsModerator(roomID, userID uint) bool {
+	member, err := repo.FindMember(roomID, userID)
+	return err == nil && member != nil && member.Role == RoleOwner
+}
+
+// addMember persists a regular membership for the user
+func addMember(roomID, userID uint) (*Member, error) {
+	member := &Member{
+		RoomID:   roomID,
+		UserID:   userID,
+		Role:     RoleMember,
+		JoinedAt: time.Now(),
+	}
+	if err := repo.AddMember(member); err != nil {
+		return nil, err
+	}
+	return member, nil
+}
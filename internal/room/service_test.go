@@ -0,0 +1,131 @@
+package room
+
+import (
+	"errors"
+	"testing"
+)
+
+// Mock do repository de salas
+type mockRoomRepo struct {
+	rooms   map[uint]*Room
+	members map[[2]uint]*Member
+}
+
+func newMockRoomRepo() *mockRoomRepo {
+	return &mockRoomRepo{
+		rooms:   make(map[uint]*Room),
+		members: make(map[[2]uint]*Member),
+	}
+}
+
+func (m *mockRoomRepo) Create(room *Room, owner *Member) error {
+	room.ID = uint(len(m.rooms) + 1)
+	m.rooms[room.ID] = room
+	owner.RoomID = room.ID
+	return m.AddMember(owner)
+}
+func (m *mockRoomRepo) FindVisibleTo(userID uint) ([]Room, error) { return nil, nil }
+func (m *mockRoomRepo) FindByMember(userID uint) ([]Room, error)  { return nil, nil }
+func (m *mockRoomRepo) FindById(id int) (*Room, error) {
+	room, ok := m.rooms[uint(id)]
+	if !ok {
+		return nil, errors.New("record not found")
+	}
+	copied := *room
+	return &copied, nil
+}
+func (m *mockRoomRepo) Update(room *Room) error { m.rooms[room.ID] = room; return nil }
+func (m *mockRoomRepo) Delete(id int) error     { delete(m.rooms, uint(id)); return nil }
+func (m *mockRoomRepo) AddMember(member *Member) error {
+	m.members[[2]uint{member.RoomID, member.UserID}] = member
+	return nil
+}
+func (m *mockRoomRepo) RemoveMember(roomID, userID uint) error {
+	delete(m.members, [2]uint{roomID, userID})
+	return nil
+}
+func (m *mockRoomRepo) FindMember(roomID, userID uint) (*Member, error) {
+	member, ok := m.members[[2]uint{roomID, userID}]
+	if !ok {
+		return nil, errors.New("record not found")
+	}
+	return member, nil
+}
+
+func TestCreateRoom_AddsOwnerAsMember(t *testing.T) {
+	repo = newMockRoomRepo()
+
+	room := &Room{Name: "General"}
+	if err := Create(room, 7); err != nil {
+		t.Fatalf("expected nil, but got error: %v", err)
+	}
+
+	if room.Visibility != VisibilityPublic {
+		t.Errorf("expected default visibility public, got %q", room.Visibility)
+	}
+
+	if !IsMember(room.ID, 7) {
+		t.Error("expected owner to be a member of the new room")
+	}
+}
+
+func TestCreateRoom_InvalidVisibility(t *testing.T) {
+	repo = newMockRoomRepo()
+
+	err := Create(&Room{Name: "General", Visibility: "secret"}, 1)
+
+	if err == nil {
+		t.Error("expected error for invalid visibility, but got nil")
+	}
+}
+
+func TestJoinRoom_PrivateRoomIsForbidden(t *testing.T) {
+	repo = newMockRoomRepo()
+
+	room := &Room{Name: "Staff", Visibility: VisibilityPrivate}
+	if err := Create(room, 1); err != nil {
+		t.Fatalf("unexpected error creating room: %v", err)
+	}
+
+	if _, err := Join(int(room.ID), 2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for non-member joining private room, got %v", err)
+	}
+
+	if _, err := AddMember(int(room.ID), 1, 2); err != nil {
+		t.Fatalf("expected owner to add member, got error: %v", err)
+	}
+
+	if _, err := FindForMember(int(room.ID), 2); err != nil {
+		t.Errorf("expected added member to access room, got error: %v", err)
+	}
+}
+
+func TestUpdateRoom_OnlyOwner(t *testing.T) {
+	repo = newMockRoomRepo()
+
+	room := &Room{Name: "General"}
+	if err := Create(room, 1); err != nil {
+		t.Fatalf("unexpected error creating room: %v", err)
+	}
+
+	if _, err := Update(int(room.ID), 2, &Room{Name: "Hijacked"}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected ErrForbidden, got %v", err)
+	}
+}
+
+func TestLeaveRoom_OwnerCannotLeave(t *testing.T) {
+	repo = newMockRoomRepo()
+
+	room := &Room{Name: "General"}
+	if err := Create(room, 1); err != nil {
+		t.Fatalf("unexpected error creating room: %v", err)
+	}
+
+	if err := Leave(int(room.ID), 1); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected ErrForbidden, got %v", err)
+	}
+
+	if _, err := FindForMember(int(room.ID), 3); !errors.Is(err, ErrNotMember) {
+		t.Errorf("expected ErrNotMember for outsider, got %v", err)
+	}
+}
@@ -0,0 +1,268 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Errors returned by the account flows
+var (
+	ErrInvalidPassword = errors.New("current password is incorrect")
+	ErrWeakPassword    = errors.New("password must have at least 6 characters")
+	ErrInvalidToken    = errors.New("invalid or expired token")
+)
+
+// Purposes of an ActionToken
+const (
+	PurposeVerifyEmail   = "verify_email"
+	PurposeResetPassword = "reset_password"
+)
+
+// ActionToken is a single-use, expiring token sent by email to verify an address
+// or reset a password. Only the SHA-256 hash of the token is stored.
+type ActionToken struct {
+	ID        uint       `gorm:"primaryKey"`
+	UserID    uint       `gorm:"index;not null"`
+	Purpose   string     `gorm:"not null"`
+	TokenHash string     `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time  `gorm:"not null"`
+	UsedAt    *time.Time // Set when the token is consumed or superseded
+	CreatedAt time.Time
+}
+
+// TableName sets the table name for ActionToken
+func (ActionToken) TableName() string {
+	return "user_action_tokens"
+}
+
+// Lifetimes of the emailed tokens
+const (
+	verifyEmailTTL   = 24 * time.Hour
+	resetPasswordTTL = time.Hour
+)
+
+// appURL returns the base URL used in emailed links
+func appURL() string {
+	if url := os.Getenv("APP_URL"); url != "" {
+		return url
+	}
+	return "http://localhost:8080"
+}
+
+// hashPassword validates and hashes a new password
+func hashPassword(password string) (string, error) {
+	if len(password) < 6 {
+		return "", ErrWeakPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", errors.New("error generating password hash")
+	}
+	return string(hash), nil
+}
+
+// ChangePassword replaces the password of a user after checking the current one.
+// Every login session of the user other than keepSession, the one making the
+// change, is signed out.
+func ChangePassword(userID uint, keepSession, currentPassword, newPassword string) error {
+	user, err := repo.FindById(int(userID))
+	if err != nil {
+		return fmt.Errorf("user not found")
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(currentPassword)) != nil {
+		return ErrInvalidPassword
+	}
+
+	return setPassword(user, newPassword, keepSession)
+}
+
+// setPassword stores a new password hash and signs out every login session of
+// the user except keepSession: their refresh tokens are revoked and their sids
+// are added to the revocation list, so their access tokens and WebSocket
+// connections stop working too. An empty keepSession signs out every session.
+func setPassword(user *User, password, keepSession string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	user.Password = hash
+	if err := repo.Update(user); err != nil {
+		return err
+	}
+
+	sessions, err := tokenRepo.FindSessions(user.ID)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.ID == keepSession {
+			continue
+		}
+		if err := revokeSessionTokens(user.ID, session.ID); err != nil {
+			return err
+		}
+	}
+	if keepSession == "" {
+		return tokenRepo.RevokeUserRefreshTokens(user.ID)
+	}
+	// Refresh tokens issued before login sessions have no session ID
+	return tokenRepo.RevokeSessionRefreshTokens(user.ID, "")
+}
+
+// issueActionToken invalidates previous tokens with the same purpose and creates a new one
+func issueActionToken(userID uint, purpose string, ttl time.Duration) (string, error) {
+	if err := tokenRepo.InvalidateActionTokens(userID, purpose); err != nil {
+		return "", err
+	}
+
+	raw, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	err = tokenRepo.CreateActionToken(&ActionToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hashToken(raw),
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// consumeActionToken validates a token for the given purpose and marks it as used
+func consumeActionToken(raw, purpose string) (*User, error) {
+	stored, err := tokenRepo.FindActionToken(hashToken(raw), purpose)
+	if err != nil || stored == nil || stored.UsedAt != nil || time.Now().After(stored.ExpiresAt) {
+		return nil, ErrInvalidToken
+	}
+
+	// Conditional update: only one concurrent request with the same token wins
+	used, err := tokenRepo.ConsumeActionToken(stored.ID)
+	if err != nil || !used {
+		return nil, ErrInvalidToken
+	}
+
+	user, err := repo.FindById(int(stored.UserID))
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	return user, nil
+}
+
+// verificationSubject is the subject of the email confirming an address
+const verificationSubject = "Confirme seu email"
+
+// SendVerificationEmail emails a link that confirms the user's address
+func SendVerificationEmail(user *User) error {
+	if user.EmailVerified {
+		return nil
+	}
+
+	body, err := verificationBody(user)
+	if err != nil {
+		return err
+	}
+	return mailer.Send(user.Email, verificationSubject, body)
+}
+
+// verificationBody issues a verification token and returns the email carrying it
+func verificationBody(user *User) (string, error) {
+	raw, err := issueActionToken(user.ID, PurposeVerifyEmail, verifyEmailTTL)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Olá %s,\n\nConfirme seu email com o token abaixo (válido por 24 horas):\n\n%s\n\n%s/verify-email?token=%s",
+		user.Name, raw, appURL(), raw), nil
+}
+
+// sendVerificationAsync issues the verification token and sends the email in
+// the background, so the request that triggered it does not wait for the mail
+// server. Failures are logged instead of failing the request; SMTP delivery is
+// bounded by SMTP_TIMEOUT.
+func sendVerificationAsync(user *User) {
+	if user.EmailVerified {
+		return
+	}
+
+	body, err := verificationBody(user)
+	if err != nil {
+		slog.Error("could not issue verification token", "user_id", user.ID, "error", err)
+		return
+	}
+
+	userID, to, m := user.ID, user.Email, mailer
+	go func() {
+		if err := m.Send(to, verificationSubject, body); err != nil {
+			slog.Error("could not send verification email", "user_id", userID, "error", err)
+		}
+	}()
+}
+
+// VerifyEmail marks the email of the token's user as verified
+func VerifyEmail(raw string) error {
+	user, err := consumeActionToken(raw, PurposeVerifyEmail)
+	if err != nil {
+		return err
+	}
+
+	user.EmailVerified = true
+	return repo.Update(user)
+}
+
+// RequestPasswordReset emails a password reset token. Unknown emails are
+// ignored without error; requestPasswordResetAsync also hides the time taken,
+// so the endpoint does not reveal which accounts exist.
+func RequestPasswordReset(email string) error {
+	user, err := repo.FindByEmail(email)
+	if err != nil || user == nil {
+		return nil
+	}
+
+	raw, err := issueActionToken(user.ID, PurposeResetPassword, resetPasswordTTL)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Olá %s,\n\nUse o token abaixo para redefinir sua senha (válido por 1 hora):\n\n%s\n\n%s/reset-password?token=%s\n\nSe você não pediu a redefinição, ignore este email.",
+		user.Name, raw, appURL(), raw)
+	return mailer.Send(user.Email, "Redefinição de senha", body)
+}
+
+// requestPasswordResetAsync runs RequestPasswordReset in the background, so known
+// and unknown emails answer equally fast. Failures are logged; SMTP delivery is
+// bounded by SMTP_TIMEOUT.
+func requestPasswordResetAsync(email string) {
+	runAsync(func() {
+		if err := RequestPasswordReset(email); err != nil {
+			slog.Error("could not send password reset email", "error", err)
+		}
+	})
+}
+
+// runAsync runs background account work in a new goroutine; replaced in tests
+var runAsync = func(f func()) { go f() }
+
+// ResetPassword sets a new password using a reset token. The token can be used
+// only once and every login session of the user is signed out.
+func ResetPassword(raw, newPassword string) error {
+	if len(newPassword) < 6 {
+		return ErrWeakPassword
+	}
+
+	user, err := consumeActionToken(raw, PurposeResetPassword)
+	if err != nil {
+		return err
+	}
+
+	return setPassword(user, newPassword, "")
+}
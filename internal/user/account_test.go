@@ -0,0 +1,314 @@
+package user
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"go-chat-live/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var mailedToken = regexp.MustCompile(`token=([0-9a-f]+)`)
+
+// setupAccountTest injects a user with password "123456" and a LogMailer
+// writing to the returned buffer
+func setupAccountTest(t *testing.T) (*User, *mockTokenRepo, *bytes.Buffer) {
+	t.Helper()
+	hash, _ := bcrypt.GenerateFromPassword([]byte("123456"), bcrypt.MinCost)
+	stored := &User{ID: 1, Name: "Test User", Email: "test@email.com", Password: string(hash), Role: RoleUser}
+
+	repo = &mockUserRepo{
+		mockFindById: func(id int) (*User, error) {
+			if id != 1 {
+				return nil, gorm.ErrRecordNotFound
+			}
+			return stored, nil
+		},
+		mockFindByEmail: func(email string) (*User, error) {
+			if email != stored.Email {
+				return nil, gorm.ErrRecordNotFound
+			}
+			return stored, nil
+		},
+	}
+	tokens := newMockTokenRepo()
+	tokenRepo = tokens
+
+	var outbox bytes.Buffer
+	mailer = &LogMailer{Writer: &outbox}
+	return stored, tokens, &outbox
+}
+
+// lastMailedToken extracts the token from the most recent email in the outbox
+func lastMailedToken(t *testing.T, outbox *bytes.Buffer) string {
+	t.Helper()
+	matches := mailedToken.FindAllStringSubmatch(outbox.String(), -1)
+	if len(matches) == 0 {
+		t.Fatal("expected an email with a token")
+	}
+	return matches[len(matches)-1][1]
+}
+
+func TestChangePassword_RequiresCurrentPassword(t *testing.T) {
+	stored, _, _ := setupAccountTest(t)
+
+	if err := ChangePassword(1, "", "wrong", "654321"); !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("expected ErrInvalidPassword, but got %v", err)
+	}
+	if err := ChangePassword(1, "", "123456", "123"); !errors.Is(err, ErrWeakPassword) {
+		t.Errorf("expected ErrWeakPassword, but got %v", err)
+	}
+	if err := ChangePassword(1, "", "123456", "654321"); err != nil {
+		t.Fatalf("expected nil, but got error: %v", err)
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(stored.Password), []byte("654321")) != nil {
+		t.Error("expected password to be changed")
+	}
+}
+
+func TestChangePassword_SignsOutOtherSessions(t *testing.T) {
+	stored, _, _ := setupAccountTest(t)
+
+	current, _ := issueTokens(stored)
+	other, _ := issueTokens(stored)
+	claims, _ := ValidateJWT(current.Token)
+	if err := ChangePassword(1, claims["sid"].(string), "123456", "654321"); err != nil {
+		t.Fatalf("expected nil, but got error: %v", err)
+	}
+
+	// A sessão que trocou a senha continua; as outras perdem também o access token
+	if _, err := ValidateJWT(other.Token); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("expected the other session's access token to be revoked, got %v", err)
+	}
+	if _, err := Refresh(other.RefreshToken); err == nil {
+		t.Error("expected the other session's refresh token to be revoked")
+	}
+	if _, err := ValidateJWT(current.Token); err != nil {
+		t.Errorf("expected the current session to keep working, got %v", err)
+	}
+	if _, err := Refresh(current.RefreshToken); err != nil {
+		t.Errorf("expected the current session to keep refreshing, got %v", err)
+	}
+}
+
+func TestChangeUserPassword_Route(t *testing.T) {
+	tokens := setupRoleTest(t)
+	r := newTestRouter()
+
+	if code := doRequest(r, http.MethodPost, "/users/me/password", "", `{}`); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without token, got %d", code)
+	}
+	// Os usuários de setupRoleTest não têm senha, então a senha atual nunca confere
+	body := `{"currentPassword":"123456","newPassword":"654321"}`
+	if code := doRequest(r, http.MethodPost, "/users/me/password", tokens[1], body); code != http.StatusForbidden {
+		t.Errorf("expected 403 for a wrong current password, got %d", code)
+	}
+}
+
+func TestVerifyEmail_TokenIsSingleUse(t *testing.T) {
+	stored, _, outbox := setupAccountTest(t)
+
+	if err := SendVerificationEmail(stored); err != nil {
+		t.Fatalf("expected nil, but got error: %v", err)
+	}
+	token := lastMailedToken(t, outbox)
+
+	if err := VerifyEmail(token); err != nil {
+		t.Fatalf("expected nil, but got error: %v", err)
+	}
+	if !stored.EmailVerified {
+		t.Error("expected email to be verified")
+	}
+
+	if err := VerifyEmail(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken when reusing a token, but got %v", err)
+	}
+}
+
+func TestVerifyEmail_NewTokenSupersedesPrevious(t *testing.T) {
+	stored, _, outbox := setupAccountTest(t)
+
+	SendVerificationEmail(stored)
+	first := lastMailedToken(t, outbox)
+	SendVerificationEmail(stored)
+
+	if err := VerifyEmail(first); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected superseded token to be rejected, but got %v", err)
+	}
+}
+
+func TestResetPassword_Flow(t *testing.T) {
+	stored, _, outbox := setupAccountTest(t)
+
+	if err := RequestPasswordReset("test@email.com"); err != nil {
+		t.Fatalf("expected nil, but got error: %v", err)
+	}
+	token := lastMailedToken(t, outbox)
+
+	// Um token de redefinição não serve para verificar email
+	if err := VerifyEmail(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken for a token with another purpose, but got %v", err)
+	}
+
+	session, _ := issueTokens(stored)
+	if err := ResetPassword(token, "654321"); err != nil {
+		t.Fatalf("expected nil, but got error: %v", err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(stored.Password), []byte("654321")) != nil {
+		t.Error("expected password to be reset")
+	}
+	if _, err := ValidateJWT(session.Token); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("expected every session to be signed out, got %v", err)
+	}
+
+	if err := ResetPassword(token, "abcdef"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken when reusing a token, but got %v", err)
+	}
+}
+
+func TestResetPassword_ExpiredToken(t *testing.T) {
+	_, tokens, outbox := setupAccountTest(t)
+
+	RequestPasswordReset("test@email.com")
+	token := lastMailedToken(t, outbox)
+	tokens.actions[hashToken(token)].ExpiresAt = time.Now().Add(-time.Minute)
+
+	if err := ResetPassword(token, "654321"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken for an expired token, but got %v", err)
+	}
+}
+
+func TestRequestPasswordReset_UnknownEmail(t *testing.T) {
+	_, _, outbox := setupAccountTest(t)
+
+	if err := RequestPasswordReset("unknown@email.com"); err != nil {
+		t.Errorf("expected nil for an unknown email, but got %v", err)
+	}
+	if outbox.Len() != 0 {
+		t.Error("expected no email for an unknown address")
+	}
+}
+
+// blockingMailer holds every email until release is closed, like a stalled SMTP server
+type blockingMailer struct {
+	release chan struct{}
+	sent    chan string
+}
+
+func (m *blockingMailer) Send(to, subject, body string) error {
+	<-m.release
+	m.sent <- to
+	return nil
+}
+
+func TestCreate_DoesNotWaitForVerificationEmail(t *testing.T) {
+	repo = &mockUserRepo{mockCreate: func(u *User) error { u.ID = 2; return nil }}
+	tokenRepo = newMockTokenRepo()
+	blocking := &blockingMailer{release: make(chan struct{}), sent: make(chan string, 1)}
+	mailer = blocking
+
+	done := make(chan error, 1)
+	go func() { done <- Create(&User{Name: "Ana", Email: "ana@email.com"}) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected the user to be created, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Create to return while the mail server is stalled")
+	}
+
+	close(blocking.release)
+	select {
+	case to := <-blocking.sent:
+		if to != "ana@email.com" {
+			t.Errorf("expected the email to go to the new address, got %q", to)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the verification email to be sent in the background")
+	}
+}
+
+func TestForgotPassword_AnswersWithoutWaitingForTheMailServer(t *testing.T) {
+	setupAccountTest(t)
+	blocking := &blockingMailer{release: make(chan struct{}), sent: make(chan string, 1)}
+	mailer = blocking
+	resetIPLimiter = ratelimit.NewLimiter(100, 100)
+	resetEmailLimiter = ratelimit.NewLimiter(100, 100)
+	var background sync.WaitGroup
+	runAsync = func(f func()) {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			f()
+		}()
+	}
+	t.Cleanup(func() {
+		background.Wait()
+		runAsync = func(f func()) { go f() }
+	})
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/auth/forgot-password", ForgotPassword)
+
+	for _, email := range []string{"test@email.com", "unknown@email.com"} {
+		done := make(chan int, 1)
+		go func() { done <- doRequest(r, http.MethodPost, "/auth/forgot-password", "", `{"email":"`+email+`"}`) }()
+		select {
+		case code := <-done:
+			if code != http.StatusAccepted {
+				t.Errorf("expected 202 for %s, got %d", email, code)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %s to be answered while the mail server is stalled", email)
+		}
+	}
+
+	close(blocking.release)
+	select {
+	case to := <-blocking.sent:
+		if to != "test@email.com" {
+			t.Errorf("expected the reset email to go to the known address, got %q", to)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the reset email to be sent in the background")
+	}
+}
+
+func TestForgotPassword_LimitsRequestsPerIPAndEmail(t *testing.T) {
+	setupAccountTest(t)
+	runAsync = func(f func()) { f() }
+	t.Cleanup(func() { runAsync = func(f func()) { go f() } })
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/auth/forgot-password", ForgotPassword)
+
+	resetIPLimiter = ratelimit.NewLimiter(100, 100)
+	resetEmailLimiter = ratelimit.NewLimiter(1.0/3600, 2)
+	codes := make([]int, 3)
+	for i := range codes {
+		codes[i] = doRequest(r, http.MethodPost, "/auth/forgot-password", "", `{"email":" Unknown@email.com"}`)
+	}
+	if codes[0] != http.StatusAccepted || codes[1] != http.StatusAccepted || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("expected 202, 202, 429 for one email, got %v", codes)
+	}
+
+	resetIPLimiter = ratelimit.NewLimiter(1.0/60, 2)
+	resetEmailLimiter = ratelimit.NewLimiter(100, 100)
+	for i := range codes {
+		codes[i] = doRequest(r, http.MethodPost, "/auth/forgot-password", "", `{"email":"user`+strconv.Itoa(i)+`@email.com"}`)
+	}
+	if codes[0] != http.StatusAccepted || codes[1] != http.StatusAccepted || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("expected 202, 202, 429 from one IP, got %v", codes)
+	}
+}
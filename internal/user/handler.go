@@ -1,41 +1,52 @@
 package user
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
+
+	"go-chat-live/internal/logging"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// CreateUserRequest represents the payload for creating a user. The password
+// is only accepted here: User never serializes it.
+type CreateUserRequest struct {
+	Name     string `json:"name"`     // User's display name
+	Email    string `json:"email"`    // User's email address
+	Password string `json:"password"` // User's plain text password
+}
+
 // CreateUser handles POST requests to create a new user.
 // Validates input data, generates password hash and persists to database.
 func CreateUser(c *gin.Context) {
-	var user User
-	if err := c.ShouldBindJSON(&user); err != nil {
+	var req CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
 		return
 	}
 
-	if user.Password == "" {
+	if req.Password == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Password is required"})
 		return
 	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating password hash"})
 		return
 	}
-	user.Password = string(hash)
+	user := User{Name: req.Name, Email: req.Email, Password: string(hash)}
 
 	if err := Create(&user); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	user.Password = ""
 	c.JSON(http.StatusCreated, user)
 }
 
@@ -75,6 +86,10 @@ func UpdateUser(c *gin.Context) {
 		return
 	}
 
+	if !authorizeUserChange(c, id) {
+		return
+	}
+
 	var updatedData User
 	if err := c.ShouldBindJSON(&updatedData); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
@@ -90,6 +105,39 @@ func UpdateUser(c *gin.Context) {
 	c.JSON(http.StatusOK, updatedUser)
 }
 
+// RoleRequest represents the payload for changing a user's role
+type RoleRequest struct {
+	Role string `json:"role"` // New role: user, moderator or admin
+}
+
+// SetUserRole handles PUT requests to change a user's role. Restricted to admins by RequireRole.
+func SetUserRole(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if !ValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+		return
+	}
+
+	updatedUser, err := SetRole(id, req.Role)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, updatedUser)
+}
+
 // DeleteUser handles DELETE requests to remove a user from the system.
 func DeleteUser(c *gin.Context) {
 	idStr := c.Param("id")
@@ -99,6 +147,10 @@ func DeleteUser(c *gin.Context) {
 		return
 	}
 
+	if !authorizeUserChange(c, id) {
+		return
+	}
+
 	err = Delete(id)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
@@ -113,15 +165,65 @@ func DeleteUser(c *gin.Context) {
 }
 
 // LoginUser handles POST requests for user authentication.
-// Validates credentials and returns JWT token on success.
+// Validates credentials and returns JWT token on success. Clients sending too
+// many attempts, or trying an email locked after repeated failures, receive
+// 429 Too Many Requests with a Retry-After header.
 func LoginUser(c *gin.Context) {
+	if ok, wait := loginIPLimiter.Allow(c.ClientIP()); !ok {
+		loginAttempts.Inc(loginThrottled)
+		c.Header("Retry-After", retryAfterSeconds(wait))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many login attempts, try again later"})
+		return
+	}
+
 	var loginReq LoginRequest
 	if err := c.ShouldBindJSON(&loginReq); err != nil {
+		loginAttempts.Inc(loginFailure)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
 		return
 	}
 
+	key := lockoutKey(loginReq.Email)
+	if wait := loginLockout.Locked(key); wait > 0 {
+		loginAttempts.Inc(loginThrottled)
+		c.Header("Retry-After", retryAfterSeconds(wait))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Account temporarily locked after failed login attempts"})
+		return
+	}
+
 	response, err := Login(loginReq.Email, loginReq.Password)
+	if err != nil {
+		if errors.Is(err, ErrInvalidCredentials) {
+			if lock := loginLockout.Fail(key); lock > 0 {
+				logging.FromContext(c.Request.Context()).Warn("login locked after repeated failures",
+					"email", key, "ip", c.ClientIP(), "lockout", lock.String())
+			}
+		}
+		loginAttempts.Inc(loginFailure)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	loginLockout.Reset(key)
+	loginAttempts.Inc(loginSuccess)
+
+	c.JSON(http.StatusOK, response)
+}
+
+// RefreshRequest represents the payload carrying a refresh token
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"` // Opaque refresh token from login or a previous refresh
+}
+
+// RefreshUserToken handles POST requests to exchange a refresh token for a new token pair.
+// The presented refresh token is rotated and cannot be used again.
+func RefreshUserToken(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.RefreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	response, err := Refresh(req.RefreshToken)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
@@ -129,3 +231,191 @@ func LoginUser(c *gin.Context) {
 
 	c.JSON(http.StatusOK, response)
 }
+
+// LogoutUser handles POST requests to revoke the current access token and,
+// when sent in the body, the refresh token. Requires AuthMiddleware.
+func LogoutUser(c *gin.Context) {
+	userID, ok := CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthenticated"})
+		return
+	}
+
+	var req RefreshRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+			return
+		}
+	}
+
+	jti := c.GetString("jti")
+	exp, _ := c.Get("token_exp")
+	expUnix, _ := exp.(float64)
+
+	if err := Logout(userID, jti, time.Unix(int64(expUnix), 0), req.RefreshToken); err != nil {
+		if errors.Is(err, ErrInvalidRefreshToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// authorizeUserChange checks that the authenticated user may modify the target user,
+// writing the error response otherwise.
+func authorizeUserChange(c *gin.Context, targetID int) bool {
+	actorID, ok := CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthenticated"})
+		return false
+	}
+	if !CanModify(actorID, CurrentRole(c), targetID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": ErrForbidden.Error()})
+		return false
+	}
+	return true
+}
+
+// ChangePasswordRequest represents the payload for changing the authenticated user's password
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"` // Password in use
+	NewPassword     string `json:"newPassword"`     // Replacement password
+}
+
+// ChangeUserPassword handles POST requests to change the authenticated user's password.
+// The user's other login sessions are signed out; the one making the request stays.
+func ChangeUserPassword(c *gin.Context) {
+	userID, ok := CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthenticated"})
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	if err := ChangePassword(userID, c.GetString("sid"), req.CurrentPassword, req.NewPassword); err != nil {
+		respondAccountError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// TokenRequest represents a payload carrying an emailed token
+type TokenRequest struct {
+	Token string `json:"token"` // Token received by email
+}
+
+// VerifyUserEmail handles POST requests confirming an email address with the emailed token.
+func VerifyUserEmail(c *gin.Context) {
+	var req TokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	if err := VerifyEmail(req.Token); err != nil {
+		respondAccountError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ResendVerificationEmail handles POST requests to send a new verification email
+// to the authenticated user.
+func ResendVerificationEmail(c *gin.Context) {
+	userID, ok := CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthenticated"})
+		return
+	}
+
+	user, err := FindById(int(userID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := SendVerificationEmail(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not send verification email"})
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+// ForgotPasswordRequest represents the payload to request a password reset email
+type ForgotPasswordRequest struct {
+	Email string `json:"email"` // Email of the account to recover
+}
+
+// ForgotPassword handles POST requests to email a password reset token.
+// Always answers 202 without waiting for the lookup or the mail server, so the
+// response does not reveal whether the email is registered. Clients sending too
+// many requests, or asking too often for one email, receive 429 Too Many
+// Requests with a Retry-After header.
+func ForgotPassword(c *gin.Context) {
+	if ok, wait := resetIPLimiter.Allow(c.ClientIP()); !ok {
+		c.Header("Retry-After", retryAfterSeconds(wait))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many password reset requests, try again later"})
+		return
+	}
+
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	if ok, wait := resetEmailLimiter.Allow(lockoutKey(req.Email)); !ok {
+		c.Header("Retry-After", retryAfterSeconds(wait))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many password reset requests, try again later"})
+		return
+	}
+
+	requestPasswordResetAsync(req.Email)
+	c.Status(http.StatusAccepted)
+}
+
+// ResetPasswordRequest represents the payload to set a new password with a reset token
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`    // Token received by email
+	Password string `json:"password"` // New password
+}
+
+// ResetUserPassword handles POST requests to set a new password with an emailed reset token.
+func ResetUserPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	if err := ResetPassword(req.Token, req.Password); err != nil {
+		respondAccountError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// respondAccountError maps account flow errors to HTTP status codes
+func respondAccountError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidPassword):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrWeakPassword), errors.Is(err, ErrInvalidToken):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,232 @@
+package user
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go-chat-live/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// newTestRouter registers the user routes with the same policies as cmd/server
+func newTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	users := r.Group("/users", AuthMiddleware())
+	users.GET("", ListUsers)
+	users.GET("/:id", GetUserById)
+	users.PUT("/:id", UpdateUser)
+	users.DELETE("/:id", DeleteUser)
+	users.PUT("/:id/role", RequireRole(RoleAdmin), SetUserRole)
+	users.POST("/me/password", ChangeUserPassword)
+	return r
+}
+
+// setupRoleTest injects a regular user (1), another user (2) and an admin (3)
+// and returns an access token for each of them
+func setupRoleTest(t *testing.T) map[uint]string {
+	t.Helper()
+	users := map[int]User{
+		1: {ID: 1, Name: "User", Email: "user@email.com", Role: RoleUser},
+		2: {ID: 2, Name: "Other", Email: "other@email.com", Role: RoleUser},
+		3: {ID: 3, Name: "Admin", Email: "admin@email.com", Role: RoleAdmin},
+	}
+	repo = &mockUserRepo{
+		mockFindById: func(id int) (*User, error) {
+			u, ok := users[id]
+			if !ok {
+				return nil, gorm.ErrRecordNotFound
+			}
+			return &u, nil
+		},
+	}
+	tokenRepo = newMockTokenRepo()
+
+	tokens := make(map[uint]string)
+	for id, u := range users {
+		pair, err := issueTokens(&u)
+		if err != nil {
+			t.Fatalf("could not issue token: %v", err)
+		}
+		tokens[uint(id)] = pair.Token
+	}
+	return tokens
+}
+
+func doRequest(r *gin.Engine, method, path, token, body string) int {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestUserRoutes_RequireAuthentication(t *testing.T) {
+	setupRoleTest(t)
+	r := newTestRouter()
+
+	routes := []struct{ method, path string }{
+		{http.MethodGet, "/users"},
+		{http.MethodGet, "/users/1"},
+		{http.MethodPut, "/users/1"},
+		{http.MethodDelete, "/users/1"},
+		{http.MethodPut, "/users/1/role"},
+	}
+	for _, route := range routes {
+		if code := doRequest(r, route.method, route.path, "", ""); code != http.StatusUnauthorized {
+			t.Errorf("%s %s: expected 401 without token, got %d", route.method, route.path, code)
+		}
+	}
+}
+
+func TestUpdateUser_OnlySelfOrAdmin(t *testing.T) {
+	tokens := setupRoleTest(t)
+	r := newTestRouter()
+	body := `{"name":"New","email":"new@email.com"}`
+
+	if code := doRequest(r, http.MethodPut, "/users/1", tokens[1], body); code != http.StatusOK {
+		t.Errorf("expected user to update themselves, got %d", code)
+	}
+	if code := doRequest(r, http.MethodPut, "/users/2", tokens[1], body); code != http.StatusForbidden {
+		t.Errorf("expected 403 when updating another user, got %d", code)
+	}
+	if code := doRequest(r, http.MethodPut, "/users/2", tokens[3], body); code != http.StatusOK {
+		t.Errorf("expected admin to update any user, got %d", code)
+	}
+}
+
+func TestDeleteUser_OnlySelfOrAdmin(t *testing.T) {
+	tokens := setupRoleTest(t)
+	r := newTestRouter()
+
+	if code := doRequest(r, http.MethodDelete, "/users/2", tokens[1], ""); code != http.StatusForbidden {
+		t.Errorf("expected 403 when deleting another user, got %d", code)
+	}
+	if code := doRequest(r, http.MethodDelete, "/users/2", tokens[3], ""); code != http.StatusNoContent {
+		t.Errorf("expected admin to delete any user, got %d", code)
+	}
+	if code := doRequest(r, http.MethodDelete, "/users/1", tokens[1], ""); code != http.StatusNoContent {
+		t.Errorf("expected user to delete themselves, got %d", code)
+	}
+}
+
+func TestSetUserRole_AdminOnly(t *testing.T) {
+	tokens := setupRoleTest(t)
+	r := newTestRouter()
+
+	if code := doRequest(r, http.MethodPut, "/users/1/role", tokens[1], `{"role":"admin"}`); code != http.StatusForbidden {
+		t.Errorf("expected 403 when a user grants themselves a role, got %d", code)
+	}
+	if code := doRequest(r, http.MethodPut, "/users/1/role", tokens[3], `{"role":"moderator"}`); code != http.StatusOK {
+		t.Errorf("expected admin to change roles, got %d", code)
+	}
+	if code := doRequest(r, http.MethodPut, "/users/1/role", tokens[3], `{"role":"root"}`); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown role, got %d", code)
+	}
+}
+
+func TestUserRoutes_ReadAllowedForAuthenticatedUsers(t *testing.T) {
+	tokens := setupRoleTest(t)
+	r := newTestRouter()
+
+	if code := doRequest(r, http.MethodGet, "/users", tokens[1], ""); code != http.StatusOK {
+		t.Errorf("expected authenticated user to list users, got %d", code)
+	}
+	if code := doRequest(r, http.MethodGet, "/users/2", tokens[1], ""); code != http.StatusOK {
+		t.Errorf("expected authenticated user to read another user, got %d", code)
+	}
+}
+
+func TestUserRoutes_ResponsesOmitPasswordHash(t *testing.T) {
+	tokens := setupRoleTest(t)
+	hashed := &User{ID: 2, Name: "Other", Email: "other@email.com", Password: "$2a$10$hash", Role: RoleUser}
+	mock := repo.(*mockUserRepo)
+	findById := mock.mockFindById
+	mock.mockFindById = func(id int) (*User, error) {
+		if id == 2 {
+			u := *hashed
+			return &u, nil
+		}
+		return findById(id)
+	}
+	mock.mockFindAll = func() ([]User, error) { return []User{*hashed}, nil }
+	r := newTestRouter()
+
+	for _, path := range []string{"/users", "/users/2"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+tokens[1])
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200 for %s, got %d", path, w.Code)
+		}
+		if body := w.Body.String(); strings.Contains(body, "password") || strings.Contains(body, "$2a$") {
+			t.Errorf("expected %s to omit the password hash, got %s", path, body)
+		}
+	}
+}
+
+func TestLoginUser_LocksEmailAfterRepeatedFailures(t *testing.T) {
+	repo = &mockUserRepo{
+		mockFindByEmail: func(email string) (*User, error) {
+			return nil, gorm.ErrRecordNotFound
+		},
+	}
+	loginIPLimiter = ratelimit.NewLimiter(100, 100)
+	loginLockout = ratelimit.NewLockout(3, time.Minute, time.Hour)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/login", LoginUser)
+
+	login := func(email string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"email":"`+email+`","password":"wrong"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 3; i++ {
+		if w := login("victim@email.com"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d", i+1, w.Code)
+		}
+	}
+	w := login(" Victim@Email.com")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Errorf("expected 429 with Retry-After 60 for the locked email, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := login("other@email.com"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected other emails to stay unlocked, got %d", w.Code)
+	}
+}
+
+func TestLoginUser_LimitsAttemptsPerIP(t *testing.T) {
+	repo = &mockUserRepo{
+		mockFindByEmail: func(email string) (*User, error) {
+			return nil, gorm.ErrRecordNotFound
+		},
+	}
+	loginIPLimiter = ratelimit.NewLimiter(1.0/60, 2)
+	loginLockout = ratelimit.NewLockout(100, time.Minute, time.Hour)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/login", LoginUser)
+
+	codes := make([]int, 3)
+	for i := range codes {
+		codes[i] = doRequest(r, http.MethodPost, "/login", "", `{"email":"user`+strconv.Itoa(i)+`@email.com","password":"x"}`)
+	}
+
+	if codes[0] != http.StatusUnauthorized || codes[1] != http.StatusUnauthorized || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("expected 401, 401, 429 from one IP, got %v", codes)
+	}
+}
@@ -0,0 +1,148 @@
+package user
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mailer sends transactional emails such as verification and password reset links.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// mailer is the Mailer used by the account flows. It logs emails until
+// Configure selects the one set up in the environment.
+var mailer Mailer = &LogMailer{}
+
+// defaultSMTPTimeout bounds a whole SMTP delivery when SMTP_TIMEOUT is not set
+const defaultSMTPTimeout = 30 * time.Second
+
+// NewMailerFromEnv selects the Mailer from MAIL_DRIVER: "smtp" sends through
+// SMTP_HOST/SMTP_PORT, anything else writes emails to MAIL_LOG_FILE or logs
+// that they were not sent.
+func NewMailerFromEnv() Mailer {
+	if os.Getenv("MAIL_DRIVER") == "smtp" {
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+			Timeout:  envDuration("SMTP_TIMEOUT", defaultSMTPTimeout),
+		}
+	}
+
+	if path := os.Getenv("MAIL_LOG_FILE"); path != "" {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err == nil {
+			return &LogMailer{Writer: file}
+		}
+		slog.Warn("could not open MAIL_LOG_FILE, logging emails instead", "error", err)
+	}
+	return &LogMailer{}
+}
+
+// SMTPMailer sends emails through an SMTP server, upgrading to TLS when the
+// server supports STARTTLS and authenticating when a username is set.
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+	Timeout  time.Duration // Deadline for the whole delivery; defaultSMTPTimeout when zero
+}
+
+// Send delivers a plain text email, giving up once Timeout elapses.
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	timeout := m.Timeout
+	if timeout <= 0 {
+		timeout = defaultSMTPTimeout
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(m.Host, m.Port), timeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	client, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: m.Host}); err != nil {
+			return err
+		}
+	}
+	if m.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.Username, m.Password, m.Host)); err != nil {
+			return err
+		}
+	}
+
+	msg := strings.Join([]string{
+		"From: " + m.From,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	if err := client.Mail(m.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// LogMailer writes emails to Writer instead of sending them. Used for local
+// development and tests. Without a Writer only the subject is logged, since
+// bodies carry verification and reset tokens.
+type LogMailer struct {
+	Writer io.Writer
+
+	mu sync.Mutex
+}
+
+// Send writes the email to the configured output.
+func (m *LogMailer) Send(to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Writer == nil {
+		slog.Info("email not sent, set MAIL_LOG_FILE to keep it", "to", to, "subject", subject)
+		return nil
+	}
+	_, err := fmt.Fprintf(m.Writer, "To: %s\nSubject: %s\n\n%s\n\n", to, subject, body)
+	return err
+}
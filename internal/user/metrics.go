@@ -0,0 +1,14 @@
+package user
+
+import "go-chat-live/internal/metrics"
+
+// Results of a login attempt, as recorded by loginAttempts
+const (
+	loginSuccess   = "success"
+	loginFailure   = "failure"   // Wrong credentials or invalid request
+	loginThrottled = "throttled" // Refused by the IP rate limit or the email lockout
+)
+
+// loginAttempts counts login attempts by result
+var loginAttempts = metrics.NewCounter("user_login_attempts_total",
+	"Login attempts, by result: success, failure or throttled.", "result")
@@ -8,6 +8,8 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// AuthMiddleware validates the Bearer access token and stores its claims in the context.
+// Revoked tokens, tokens of signed out sessions and tokens of deleted users are rejected.
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
@@ -19,36 +21,49 @@ func AuthMiddleware() gin.HandlerFunc {
 
 		tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
-			}
-			return getJWTSecret(), nil
-		})
-
-		if err != nil || !token.Valid {
+		claims, user, err := authenticate(tokenString)
+		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token inválido"})
 			c.Abort()
 			return
 		}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token inválido"})
-			c.Abort()
-			return
-		}
-
-		userID := claims["user_id"]
-		email := claims["email"]
-
-		c.Set("user_id", userID)
-		c.Set("email", email)
+		c.Set("user_id", claims["user_id"])
+		c.Set("role", user.Role)
+		c.Set("email", claims["email"])
+		c.Set("jti", claims["jti"])
+		c.Set("sid", claims["sid"])
+		c.Set("token_exp", claims["exp"])
 		c.Next()
 	}
 }
 
+// RequireRole aborts with 403 unless the authenticated user has one of the given roles.
+// Must run after AuthMiddleware.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := CurrentRole(c)
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permissão insuficiente"})
+		c.Abort()
+	}
+}
+
+// ValidateJWT parses an access token and checks that it has not been revoked
+// and that its user still exists.
 func ValidateJWT(tokenString string) (jwt.MapClaims, error) {
+	claims, _, err := authenticate(tokenString)
+	return claims, err
+}
+
+// authenticate validates an access token and loads its user, so the current
+// role is used even if it changed after the token was issued.
+func authenticate(tokenString string) (jwt.MapClaims, *User, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, jwt.ErrSignatureInvalid
@@ -57,13 +72,61 @@ func ValidateJWT(tokenString string) (jwt.MapClaims, error) {
 	})
 
 	if err != nil || !token.Valid {
-		return nil, err
+		return nil, nil, jwt.ErrTokenInvalidClaims
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return nil, jwt.ErrInvalidKey
+		return nil, nil, jwt.ErrInvalidKey
 	}
 
-	return claims, nil
+	jti, _ := claims["jti"].(string)
+	userID, _ := claims["user_id"].(float64)
+	if jti == "" || userID <= 0 {
+		return nil, nil, jwt.ErrTokenInvalidClaims
+	}
+
+	revoked, err := tokenRepo.FindRevoked([]string{jti})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(revoked) > 0 {
+		return nil, nil, ErrTokenRevoked
+	}
+
+	// Sessions signed out remotely reject every access token they were issued
+	if sid, _ := claims["sid"].(string); sid != "" {
+		revoked, err := tokenRepo.FindRevokedSessions([]string{sid})
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(revoked) > 0 {
+			return nil, nil, ErrTokenRevoked
+		}
+	}
+
+	user, err := repo.FindById(int(userID))
+	if err != nil {
+		return nil, nil, jwt.ErrTokenInvalidClaims
+	}
+
+	return claims, user, nil
+}
+
+// CurrentUserID returns the authenticated user ID stored in the context by AuthMiddleware.
+func CurrentUserID(c *gin.Context) (uint, bool) {
+	value, exists := c.Get("user_id")
+	if !exists {
+		return 0, false
+	}
+	id, ok := value.(float64)
+	if !ok || id <= 0 {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// CurrentRole returns the role of the authenticated user stored in the context by AuthMiddleware.
+func CurrentRole(c *gin.Context) string {
+	return c.GetString("role")
 }
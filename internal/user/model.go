@@ -4,8 +4,23 @@ package user
 // User represents a user entity in the chat application.
 // It includes authentication credentials and basic profile information.
 type User struct {
-	ID       uint   `gorm:"primaryKey"`         // Primary key for database
-	Name     string `json:"name"`               // User's display name
-	Email    string `json:"email"`              // User's email address (unique)
-	Password string `json:"password,omitempty"` // Bcrypt hashed password (omitted in responses)
+	ID       uint   `gorm:"primaryKey"`                        // Primary key for database
+	Name     string `json:"name"`                              // User's display name
+	Email    string `json:"email"`                             // User's email address (unique)
+	Password string `json:"-"`                                 // Bcrypt hashed password, never serialized
+	Role     string `gorm:"not null;default:user" json:"role"` // Authorization role: user, moderator or admin
+
+	EmailVerified bool `gorm:"not null;default:false" json:"emailVerified"` // Whether the email was confirmed
+}
+
+// Supported user roles
+const (
+	RoleUser      = "user"      // Regular user, may only manage their own account
+	RoleModerator = "moderator" // May moderate chat content
+	RoleAdmin     = "admin"     // May manage every account
+)
+
+// ValidRole reports whether role is one of the supported roles.
+func ValidRole(role string) bool {
+	return role == RoleUser || role == RoleModerator || role == RoleAdmin
 }
@@ -0,0 +1,52 @@
+package user
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-chat-live/internal/ratelimit"
+)
+
+// Login flood protection. Attempts are limited per client IP with a token bucket,
+// and per email with a lockout that grows while failures keep coming. State is
+// kept in memory, so each REST server instance enforces its own limits. Both
+// are built by Configure.
+var (
+	loginIPLimiter *ratelimit.Limiter
+	loginLockout   *ratelimit.Lockout
+)
+
+// Password reset flood protection: requests are limited per client IP and per
+// email with token buckets, so the endpoint cannot be used to flood an inbox or
+// to probe many addresses. Built by Configure.
+var (
+	resetIPLimiter    *ratelimit.Limiter
+	resetEmailLimiter *ratelimit.Limiter
+)
+
+// lockoutKey normalizes an email so case and spacing variants share a lockout
+func lockoutKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// retryAfterSeconds formats a wait as the whole seconds of a Retry-After header
+func retryAfterSeconds(wait time.Duration) string {
+	return strconv.Itoa(int((wait + time.Second - 1) / time.Second))
+}
+
+// envInt reads a positive integer environment variable, falling back to def
+func envInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		slog.Warn("invalid environment variable, using default", "key", key, "value", value, "default", def)
+		return def
+	}
+	return n
+}
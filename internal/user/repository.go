@@ -1,6 +1,10 @@
 package user
 
-import "go-chat-live/internal/database"
+import (
+	"time"
+
+	"go-chat-live/internal/database"
+)
 
 // UserRepository defines the interface for user data access operations.
 // This interface follows the Repository pattern to abstract database operations.
@@ -63,3 +67,143 @@ func (r *userRepositoryImpl) Update(user *User) error {
 func (r *userRepositoryImpl) Delete(id int) error {
 	return database.DB.Delete(&User{}, id).Error
 }
+
+// TokenRepository defines the interface for refresh token and revocation data access.
+type TokenRepository interface {
+	CreateRefreshToken(token *RefreshToken) error                   // Stores a new refresh token
+	FindRefreshToken(hash string) (*RefreshToken, error)            // Finds a refresh token by its hash
+	RevokeRefreshToken(id uint) (bool, error)                       // Revokes a token, reporting whether it was still active
+	RevokeUserRefreshTokens(userID uint) error                      // Revokes every active refresh token of a user
+	RevokeSessionRefreshTokens(userID uint, sessionID string) error // Revokes the active refresh tokens of a login session
+	RevokeAccessToken(token *RevokedToken) error                    // Adds an access token to the revocation list
+	FindRevoked(jtis []string) ([]string, error)                    // Returns which of the given access tokens are revoked
+	FindSessions(userID uint) ([]Session, error)                    // Returns the login sessions of a user with an active refresh token
+	RevokeSession(session *RevokedSession) error                    // Adds a login session to the revocation list
+	FindRevokedSessions(sessionIDs []string) ([]string, error)      // Returns which of the given login sessions are revoked
+
+	CreateActionToken(token *ActionToken) error                 // Stores a new emailed token
+	FindActionToken(hash, purpose string) (*ActionToken, error) // Finds an emailed token by its hash and purpose
+	ConsumeActionToken(id uint) (bool, error)                   // Marks a token as used, reporting whether it was still unused
+	InvalidateActionTokens(userID uint, purpose string) error   // Marks every unused token of a user and purpose as used
+}
+
+// tokenRepositoryImpl implements TokenRepository using GORM ORM.
+type tokenRepositoryImpl struct{}
+
+// NewTokenRepository creates a new instance of TokenRepository.
+func NewTokenRepository() TokenRepository {
+	return &tokenRepositoryImpl{}
+}
+
+// CreateRefreshToken inserts a new refresh token.
+func (r *tokenRepositoryImpl) CreateRefreshToken(token *RefreshToken) error {
+	return database.DB.Create(token).Error
+}
+
+// FindRefreshToken retrieves a refresh token by the hash of its value.
+func (r *tokenRepositoryImpl) FindRefreshToken(hash string) (*RefreshToken, error) {
+	var token RefreshToken
+	err := database.DB.Where("token_hash = ?", hash).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// RevokeRefreshToken marks a refresh token as revoked if it was still active.
+func (r *tokenRepositoryImpl) RevokeRefreshToken(id uint) (bool, error) {
+	result := database.DB.Model(&RefreshToken{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+// RevokeUserRefreshTokens marks every active refresh token of a user as revoked.
+func (r *tokenRepositoryImpl) RevokeUserRefreshTokens(userID uint) error {
+	return database.DB.Model(&RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeSessionRefreshTokens marks the active refresh tokens of a user's login session as revoked.
+func (r *tokenRepositoryImpl) RevokeSessionRefreshTokens(userID uint, sessionID string) error {
+	return database.DB.Model(&RefreshToken{}).
+		Where("user_id = ? AND session_id = ? AND revoked_at IS NULL", userID, sessionID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeAccessToken adds an access token to the revocation list and purges
+// entries whose tokens have expired anyway.
+func (r *tokenRepositoryImpl) RevokeAccessToken(token *RevokedToken) error {
+	if err := database.DB.Where("expires_at < ?", time.Now()).Delete(&RevokedToken{}).Error; err != nil {
+		return err
+	}
+	return database.DB.Save(token).Error
+}
+
+// FindRevoked returns the subset of the given jtis present in the revocation list.
+func (r *tokenRepositoryImpl) FindRevoked(jtis []string) ([]string, error) {
+	var revoked []string
+	err := database.DB.Model(&RevokedToken{}).Where("jti IN ?", jtis).Pluck("jti", &revoked).Error
+	return revoked, err
+}
+
+// FindSessions groups the refresh tokens of a user by login session, keeping the
+// sessions with a token still active. Tokens issued before sessions are ignored.
+func (r *tokenRepositoryImpl) FindSessions(userID uint) ([]Session, error) {
+	var sessions []Session
+	err := database.DB.Model(&RefreshToken{}).
+		Select("session_id AS id, MIN(created_at) AS signed_in_at, MAX(created_at) AS last_used_at").
+		Where("user_id = ? AND session_id <> ''", userID).
+		Group("session_id").
+		Having("COUNT(*) FILTER (WHERE revoked_at IS NULL AND expires_at > ?) > 0", time.Now()).
+		Order("signed_in_at").
+		Scan(&sessions).Error
+	return sessions, err
+}
+
+// RevokeSession adds a login session to the revocation list and purges entries
+// whose access tokens have expired anyway.
+func (r *tokenRepositoryImpl) RevokeSession(session *RevokedSession) error {
+	if err := database.DB.Where("expires_at < ?", time.Now()).Delete(&RevokedSession{}).Error; err != nil {
+		return err
+	}
+	return database.DB.Save(session).Error
+}
+
+// FindRevokedSessions returns the subset of the given session IDs present in the revocation list.
+func (r *tokenRepositoryImpl) FindRevokedSessions(sessionIDs []string) ([]string, error) {
+	var revoked []string
+	err := database.DB.Model(&RevokedSession{}).Where("session_id IN ?", sessionIDs).Pluck("session_id", &revoked).Error
+	return revoked, err
+}
+
+// CreateActionToken inserts a new emailed token.
+func (r *tokenRepositoryImpl) CreateActionToken(token *ActionToken) error {
+	return database.DB.Create(token).Error
+}
+
+// FindActionToken retrieves an emailed token by the hash of its value and its purpose.
+func (r *tokenRepositoryImpl) FindActionToken(hash, purpose string) (*ActionToken, error) {
+	var token ActionToken
+	err := database.DB.Where("token_hash = ? AND purpose = ?", hash, purpose).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ConsumeActionToken marks an emailed token as used if it was still unused.
+func (r *tokenRepositoryImpl) ConsumeActionToken(id uint) (bool, error) {
+	result := database.DB.Model(&ActionToken{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+// InvalidateActionTokens marks every unused token of a user with the given purpose as used.
+func (r *tokenRepositoryImpl) InvalidateActionTokens(userID uint, purpose string) error {
+	return database.DB.Model(&ActionToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", time.Now()).Error
+}
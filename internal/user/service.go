@@ -6,13 +6,43 @@ import (
 	"os"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
+	"go-chat-live/internal/ratelimit"
+
 	"golang.org/x/crypto/bcrypt"
 )
 
+// ErrForbidden is returned when the caller may not act on the target user
+var ErrForbidden = errors.New("not allowed to modify this user")
+
+// ErrInvalidCredentials is returned by Login when the email or password is wrong
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
 // repo is the global repository instance used by service functions
 var repo = NewUsuarioRepository()
 
+// Configure builds the login and password reset limits and the mailer from the environment.
+// Call it once the .env file is loaded, before serving requests.
+func Configure() {
+	mailer = NewMailerFromEnv()
+	loginIPLimiter = ratelimit.NewLimiter(
+		float64(envInt("LOGIN_IP_RATE", 10))/60, // Attempts per minute
+		envInt("LOGIN_IP_BURST", 10),
+	)
+	loginLockout = ratelimit.NewLockout(
+		envInt("LOGIN_MAX_FAILURES", 5),
+		envDuration("LOGIN_LOCKOUT", time.Minute),
+		envDuration("LOGIN_MAX_LOCKOUT", time.Hour),
+	)
+	resetIPLimiter = ratelimit.NewLimiter(
+		float64(envInt("RESET_IP_RATE", 5))/60, // Requests per minute
+		envInt("RESET_IP_BURST", 5),
+	)
+	resetEmailLimiter = ratelimit.NewLimiter(
+		float64(envInt("RESET_EMAIL_RATE", 3))/3600, // Requests per hour
+		envInt("RESET_EMAIL_BURST", 3),
+	)
+}
+
 // getJWTSecret retrieves JWT secret from environment variables with fallback
 func getJWTSecret() []byte {
 	secret := os.Getenv("JWT_SECRET")
@@ -22,12 +52,44 @@ func getJWTSecret() []byte {
 	return []byte(secret)
 }
 
-// Create validates and creates a new user with required fields validation
+// Create validates and creates a new user with required fields validation.
+// New users always get the user role; roles are granted by admins through SetRole.
+// A verification email is sent to the new address.
 func Create(user *User) error {
 	if user.Name == "" || user.Email == "" {
 		return errors.New("name and email are required")
 	}
-	return repo.Create(user)
+	user.Role = RoleUser
+	user.EmailVerified = false
+	if err := repo.Create(user); err != nil {
+		return err
+	}
+	sendVerificationAsync(user)
+	return nil
+}
+
+// CanModify reports whether the actor may change or delete the target user:
+// users may only modify themselves, admins may modify anyone.
+func CanModify(actorID uint, actorRole string, targetID int) bool {
+	return actorRole == RoleAdmin || int(actorID) == targetID
+}
+
+// SetRole changes the role of a user
+func SetRole(id int, role string) (*User, error) {
+	if !ValidRole(role) {
+		return nil, fmt.Errorf("invalid role %q", role)
+	}
+
+	user, err := repo.FindById(id)
+	if err != nil {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	user.Role = role
+	if err := repo.Update(user); err != nil {
+		return nil, err
+	}
+	return user, nil
 }
 
 // List retrieves all users from the repository
@@ -44,30 +106,42 @@ func FindById(id int) (*User, error) {
 	return user, nil
 }
 
-// Update modifies an existing user's information
+// Update modifies an existing user's information. Changing the email
+// requires verifying the new address.
 func Update(id int, newData *User) (*User, error) {
 	user, err := repo.FindById(id)
 	if err != nil {
 		return nil, fmt.Errorf("user not found")
 	}
 
+	emailChanged := user.Email != newData.Email
 	user.Name = newData.Name
 	user.Email = newData.Email
+	if emailChanged {
+		user.EmailVerified = false
+	}
 
 	err = repo.Update(user)
 	if err != nil {
 		return nil, err
 	}
 
+	if emailChanged {
+		sendVerificationAsync(user)
+	}
 	return user, nil
 }
 
-// Delete removes a user by ID with validation
+// Delete removes a user by ID with validation and revokes its refresh tokens.
+// Access tokens of a deleted user are rejected by ValidateJWT.
 func Delete(id int) error {
 	user, err := FindById(id)
 	if err != nil || user == nil {
 		return fmt.Errorf("user not found")
 	}
+	if err := tokenRepo.RevokeUserRefreshTokens(user.ID); err != nil {
+		return err
+	}
 	return repo.Delete(id)
 }
 
@@ -77,41 +151,34 @@ type LoginRequest struct {
 	Password string `json:"password"` // User's plain text password
 }
 
-// LoginResponse contains authentication result with JWT token and user data
+// LoginResponse contains authentication result with the token pair and user data
 type LoginResponse struct {
-	Token string `json:"token"` // JWT access token
-	User  User   `json:"user"`  // User information (password omitted)
+	TokenPair      // Access and refresh tokens
+	User      User `json:"user"` // User information
 }
 
-// Login authenticates user credentials and returns JWT token
+// Login authenticates user credentials and returns an access/refresh token pair
 // Validates email/password combination using bcrypt and generates JWT
 func Login(email, password string) (*LoginResponse, error) {
 	user, err := repo.FindByEmail(email)
 	if err != nil {
-		return nil, errors.New("invalid credentials")
+		return nil, ErrInvalidCredentials
 	}
 
 	// Verify password hash using bcrypt
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
 	if err != nil {
-		return nil, errors.New("invalid credentials")
+		return nil, ErrInvalidCredentials
 	}
 
-	// Create JWT token with user claims and 24h expiration
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": user.ID,
-		"email":   user.Email,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(),
-	})
-
-	tokenString, err := token.SignedString(getJWTSecret())
+	// Issue short-lived access token and rotating refresh token
+	tokens, err := issueTokens(user)
 	if err != nil {
-		return nil, errors.New("error generating token")
+		return nil, err
 	}
 
-	user.Password = "" // Remove password from response for security
 	return &LoginResponse{
-		Token: tokenString,
-		User:  *user,
+		TokenPair: *tokens,
+		User:      *user,
 	}, nil
 }
@@ -8,6 +8,7 @@ import (
 // Mock do repository
 type mockUserRepo struct {
 	mockCreate      func(*User) error
+	mockFindAll     func() ([]User, error)
 	mockFindById    func(int) (*User, error)
 	mockFindByEmail func(string) (*User, error)
 }
@@ -15,7 +16,12 @@ type mockUserRepo struct {
 func (m *mockUserRepo) Create(u *User) error {
 	return m.mockCreate(u)
 }
-func (m *mockUserRepo) FindAll() ([]User, error) { return nil, nil }
+func (m *mockUserRepo) FindAll() ([]User, error) {
+	if m.mockFindAll == nil {
+		return nil, nil
+	}
+	return m.mockFindAll()
+}
 func (m *mockUserRepo) FindById(id int) (*User, error) {
 	return m.mockFindById(id)
 }
@@ -36,6 +42,7 @@ func TestCreateUser_WithValidData(t *testing.T) {
 	}
 
 	repo = mockRepo // injetando mock no service
+	tokenRepo = newMockTokenRepo()
 
 	u := &User{Name: "Guilherme", Email: "gui@email.com", Password: "123456"}
 	err := Create(u)
@@ -98,6 +105,7 @@ func TestLogin_WithValidCredentials(t *testing.T) {
 	}
 
 	repo = mockRepo
+	tokenRepo = newMockTokenRepo()
 
 	response, err := Login("test@email.com", "123456")
 
@@ -113,6 +121,10 @@ func TestLogin_WithValidCredentials(t *testing.T) {
 		t.Error("expected token in response, but got empty string")
 	}
 
+	if response != nil && response.RefreshToken == "" {
+		t.Error("expected refresh token in response, but got empty string")
+	}
+
 	if response != nil && response.User.Name != "Test User" {
 		t.Errorf("expected user name 'Test User', but got '%s'", response.User.Name)
 	}
@@ -165,3 +177,19 @@ func TestLogin_WithWrongPassword(t *testing.T) {
 		t.Error("expected nil response for wrong password, but got response")
 	}
 }
+
+func TestCreateUser_IgnoresRequestedRole(t *testing.T) {
+	repo = &mockUserRepo{
+		mockCreate: func(u *User) error { return nil },
+	}
+	tokenRepo = newMockTokenRepo()
+
+	u := &User{Name: "Guilherme", Email: "gui@email.com", Password: "123456", Role: RoleAdmin}
+	if err := Create(u); err != nil {
+		t.Fatalf("expected nil, but got error: %v", err)
+	}
+
+	if u.Role != RoleUser {
+		t.Errorf("expected role %q, but got %q", RoleUser, u.Role)
+	}
+}
@@ -0,0 +1,328 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// Errors returned by the token functions
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrTokenRevoked        = errors.New("token has been revoked")
+)
+
+// RefreshToken is a long-lived, single-use credential exchanged for a new token pair.
+// Only the SHA-256 hash of the token is stored.
+type RefreshToken struct {
+	ID        uint       `gorm:"primaryKey"`
+	UserID    uint       `gorm:"index;not null"`
+	TokenHash string     `gorm:"uniqueIndex;not null"`
+	SessionID string     `gorm:"index"` // Login session the token belongs to, kept across rotations
+	ExpiresAt time.Time  `gorm:"not null"`
+	RevokedAt *time.Time // Set when the token is used, logged out or its family is revoked
+	CreatedAt time.Time
+}
+
+// RevokedToken records an access token revoked before its expiration, by its jti claim.
+type RevokedToken struct {
+	JTI       string    `gorm:"primaryKey"`
+	UserID    uint      `gorm:"index;not null"`
+	ExpiresAt time.Time `gorm:"index;not null"` // After this the entry is useless and can be purged
+}
+
+// RevokedSession records a login session signed out remotely, by its sid claim.
+// Every access token carrying the sid is rejected, on any server.
+type RevokedSession struct {
+	SessionID string    `gorm:"primaryKey"`
+	UserID    uint      `gorm:"index;not null"`
+	ExpiresAt time.Time `gorm:"index;not null"` // After this every access token of the session has expired and the entry can be purged
+}
+
+// Session is an active login session of a user, built from its refresh tokens.
+type Session struct {
+	ID         string    // sid claim shared by the tokens of the session
+	SignedInAt time.Time // When the user logged in
+	LastUsedAt time.Time // When the session last got a new token pair
+}
+
+// TokenPair is returned by login and refresh.
+type TokenPair struct {
+	Token        string `json:"token"`        // JWT access token
+	RefreshToken string `json:"refreshToken"` // Opaque refresh token
+	ExpiresIn    int64  `json:"expiresIn"`    // Access token lifetime in seconds
+}
+
+// TokenRef identifies the access token used by a live session.
+type TokenRef struct {
+	ID        string // jti claim
+	UserID    uint   // user_id claim
+	SessionID string // sid claim, empty for tokens issued before login sessions
+}
+
+// tokenRepo is the global token repository instance used by token functions
+var tokenRepo = NewTokenRepository()
+
+// accessTokenTTL reads the access token lifetime from environment variables with fallback
+func accessTokenTTL() time.Duration {
+	return envDuration("ACCESS_TOKEN_TTL", 15*time.Minute)
+}
+
+// refreshTokenTTL reads the refresh token lifetime from environment variables with fallback
+func refreshTokenTTL() time.Duration {
+	return envDuration("REFRESH_TOKEN_TTL", 7*24*time.Hour)
+}
+
+// envDuration parses a duration environment variable, falling back to def
+func envDuration(key string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || d <= 0 {
+		return def
+	}
+	return d
+}
+
+// randomToken generates a random hex string of n bytes
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken returns the hex SHA-256 of a refresh token, as stored in the database
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueTokens starts a new login session with a short-lived access token and a refresh token
+func issueTokens(user *User) (*TokenPair, error) {
+	return issueSessionTokens(user, "")
+}
+
+// issueSessionTokens creates a short-lived access token with a unique jti and a new
+// refresh token for a login session. The session ID is sent as the "sid" claim so
+// every token of a device can be recognized; an empty ID starts a new session.
+func issueSessionTokens(user *User, sessionID string) (*TokenPair, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return nil, errors.New("error generating token")
+	}
+	if sessionID == "" {
+		sessionID, err = randomToken(16)
+		if err != nil {
+			return nil, errors.New("error generating token")
+		}
+	}
+
+	now := time.Now()
+	ttl := accessTokenTTL()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": user.ID,
+		"email":   user.Email,
+		"jti":     jti,
+		"sid":     sessionID,
+		"iat":     now.Unix(),
+		"exp":     now.Add(ttl).Unix(),
+	})
+
+	tokenString, err := token.SignedString(getJWTSecret())
+	if err != nil {
+		return nil, errors.New("error generating token")
+	}
+
+	rawRefresh, err := randomToken(32)
+	if err != nil {
+		return nil, errors.New("error generating token")
+	}
+
+	err = tokenRepo.CreateRefreshToken(&RefreshToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(rawRefresh),
+		SessionID: sessionID,
+		ExpiresAt: now.Add(refreshTokenTTL()),
+	})
+	if err != nil {
+		return nil, errors.New("error generating token")
+	}
+
+	return &TokenPair{
+		Token:        tokenString,
+		RefreshToken: rawRefresh,
+		ExpiresIn:    int64(ttl.Seconds()),
+	}, nil
+}
+
+// Refresh exchanges a refresh token for a new token pair, rotating the refresh token.
+// Presenting an already used refresh token revokes every refresh token of its login
+// session, since it means the token was stolen or replayed. Tokens issued before
+// sessions existed revoke every refresh token of the user.
+func Refresh(rawRefresh string) (*LoginResponse, error) {
+	stored, err := tokenRepo.FindRefreshToken(hashToken(rawRefresh))
+	if err != nil || stored == nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if stored.RevokedAt != nil {
+		if stored.SessionID != "" {
+			tokenRepo.RevokeSessionRefreshTokens(stored.UserID, stored.SessionID)
+		} else {
+			tokenRepo.RevokeUserRefreshTokens(stored.UserID)
+		}
+		return nil, ErrInvalidRefreshToken
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	user, err := repo.FindById(int(stored.UserID))
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	// Conditional revoke: only one concurrent refresh with the same token wins
+	revoked, err := tokenRepo.RevokeRefreshToken(stored.ID)
+	if err != nil || !revoked {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	tokens, err := issueSessionTokens(user, stored.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResponse{TokenPair: *tokens, User: *user}, nil
+}
+
+// Logout revokes the access token identified by jti and, when given, the refresh
+// token of the same user, so neither can be used again.
+func Logout(userID uint, jti string, accessExpiresAt time.Time, rawRefresh string) error {
+	if jti != "" {
+		err := tokenRepo.RevokeAccessToken(&RevokedToken{JTI: jti, UserID: userID, ExpiresAt: accessExpiresAt})
+		if err != nil {
+			return err
+		}
+	}
+
+	if rawRefresh != "" {
+		stored, err := tokenRepo.FindRefreshToken(hashToken(rawRefresh))
+		if err != nil || stored == nil || stored.UserID != userID {
+			return ErrInvalidRefreshToken
+		}
+		if _, err := tokenRepo.RevokeRefreshToken(stored.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ListSessions returns the active login sessions of a user, oldest first. They
+// come from the refresh tokens, so every device is listed wherever it is connected.
+func ListSessions(userID uint) ([]Session, error) {
+	return tokenRepo.FindSessions(userID)
+}
+
+// RevokeSession signs a login session out from another device: its refresh
+// tokens are revoked so it cannot get new access tokens, and its sid is added to
+// the revocation list so the access tokens it already holds are rejected too.
+// Reports false when the user has no active session with that ID.
+func RevokeSession(userID uint, sessionID string) (bool, error) {
+	sessions, err := tokenRepo.FindSessions(userID)
+	if err != nil {
+		return false, err
+	}
+	if !slices.ContainsFunc(sessions, func(s Session) bool { return s.ID == sessionID }) {
+		return false, nil
+	}
+
+	return true, revokeSessionTokens(userID, sessionID)
+}
+
+// revokeSessionTokens revokes the refresh tokens of a login session and adds its
+// sid to the revocation list
+func revokeSessionTokens(userID uint, sessionID string) error {
+	if err := tokenRepo.RevokeSessionRefreshTokens(userID, sessionID); err != nil {
+		return err
+	}
+	// Access tokens issued before the refresh tokens were revoked expire within one TTL
+	return tokenRepo.RevokeSession(&RevokedSession{
+		SessionID: sessionID,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(accessTokenTTL()),
+	})
+}
+
+// InvalidTokens returns the IDs of the given access tokens that can no longer be
+// used, because they or their session were revoked or because their user was deleted.
+// Used to disconnect live sessions authenticated with those tokens.
+func InvalidTokens(tokens []TokenRef) ([]string, error) {
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, 0, len(tokens))
+	var sessionIDs []string
+	for _, t := range tokens {
+		ids = append(ids, t.ID)
+		if t.SessionID != "" {
+			sessionIDs = append(sessionIDs, t.SessionID)
+		}
+	}
+	revokedIDs, err := tokenRepo.FindRevoked(ids)
+	if err != nil {
+		return nil, err
+	}
+	revokedSessions := make(map[string]bool)
+	if len(sessionIDs) > 0 {
+		found, err := tokenRepo.FindRevokedSessions(sessionIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, sid := range found {
+			revokedSessions[sid] = true
+		}
+	}
+
+	invalid := revokedIDs
+	revoked := make(map[string]bool)
+	for _, id := range revokedIDs {
+		revoked[id] = true
+	}
+	for _, t := range tokens {
+		if !revoked[t.ID] && revokedSessions[t.SessionID] {
+			revoked[t.ID] = true
+			invalid = append(invalid, t.ID)
+		}
+	}
+
+	existing := make(map[uint]bool)
+	for _, t := range tokens {
+		if revoked[t.ID] {
+			continue
+		}
+		exists, checked := existing[t.UserID]
+		if !checked {
+			_, err := repo.FindById(int(t.UserID))
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, err
+			}
+			exists = err == nil
+			existing[t.UserID] = exists
+		}
+		if !exists {
+			invalid = append(invalid, t.ID)
+		}
+	}
+
+	return invalid, nil
+}
@@ -0,0 +1,291 @@
+package user
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Mock do token repository em memória
+type mockTokenRepo struct {
+	refresh         map[string]*RefreshToken
+	revoked         map[string]bool
+	revokedSessions map[string]bool
+	actions         map[string]*ActionToken
+	nextID          uint
+}
+
+func newMockTokenRepo() *mockTokenRepo {
+	return &mockTokenRepo{
+		refresh:         map[string]*RefreshToken{},
+		revoked:         map[string]bool{},
+		revokedSessions: map[string]bool{},
+		actions:         map[string]*ActionToken{},
+	}
+}
+
+func (m *mockTokenRepo) CreateRefreshToken(token *RefreshToken) error {
+	m.nextID++
+	token.ID = m.nextID
+	m.refresh[token.TokenHash] = token
+	return nil
+}
+func (m *mockTokenRepo) FindRefreshToken(hash string) (*RefreshToken, error) {
+	token, ok := m.refresh[hash]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return token, nil
+}
+func (m *mockTokenRepo) RevokeRefreshToken(id uint) (bool, error) {
+	for _, token := range m.refresh {
+		if token.ID == id && token.RevokedAt == nil {
+			now := time.Now()
+			token.RevokedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+func (m *mockTokenRepo) RevokeUserRefreshTokens(userID uint) error {
+	now := time.Now()
+	for _, token := range m.refresh {
+		if token.UserID == userID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+	return nil
+}
+func (m *mockTokenRepo) RevokeSessionRefreshTokens(userID uint, sessionID string) error {
+	now := time.Now()
+	for _, token := range m.refresh {
+		if token.UserID == userID && token.SessionID == sessionID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+	return nil
+}
+func (m *mockTokenRepo) RevokeAccessToken(token *RevokedToken) error {
+	m.revoked[token.JTI] = true
+	return nil
+}
+func (m *mockTokenRepo) FindRevoked(jtis []string) ([]string, error) {
+	var found []string
+	for _, jti := range jtis {
+		if m.revoked[jti] {
+			found = append(found, jti)
+		}
+	}
+	return found, nil
+}
+func (m *mockTokenRepo) FindSessions(userID uint) ([]Session, error) {
+	var sessions []Session
+	for _, token := range m.refresh {
+		if token.UserID != userID || token.SessionID == "" || token.RevokedAt != nil {
+			continue
+		}
+		sessions = append(sessions, Session{ID: token.SessionID, SignedInAt: token.CreatedAt, LastUsedAt: token.CreatedAt})
+	}
+	return sessions, nil
+}
+func (m *mockTokenRepo) RevokeSession(session *RevokedSession) error {
+	m.revokedSessions[session.SessionID] = true
+	return nil
+}
+func (m *mockTokenRepo) FindRevokedSessions(sessionIDs []string) ([]string, error) {
+	var found []string
+	for _, sid := range sessionIDs {
+		if m.revokedSessions[sid] {
+			found = append(found, sid)
+		}
+	}
+	return found, nil
+}
+
+func (m *mockTokenRepo) CreateActionToken(token *ActionToken) error {
+	m.nextID++
+	token.ID = m.nextID
+	m.actions[token.TokenHash] = token
+	return nil
+}
+func (m *mockTokenRepo) FindActionToken(hash, purpose string) (*ActionToken, error) {
+	token, ok := m.actions[hash]
+	if !ok || token.Purpose != purpose {
+		return nil, errors.New("not found")
+	}
+	return token, nil
+}
+func (m *mockTokenRepo) ConsumeActionToken(id uint) (bool, error) {
+	for _, token := range m.actions {
+		if token.ID == id && token.UsedAt == nil {
+			now := time.Now()
+			token.UsedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+func (m *mockTokenRepo) InvalidateActionTokens(userID uint, purpose string) error {
+	now := time.Now()
+	for _, token := range m.actions {
+		if token.UserID == userID && token.Purpose == purpose && token.UsedAt == nil {
+			token.UsedAt = &now
+		}
+	}
+	return nil
+}
+
+func setupTokenTest() *mockTokenRepo {
+	repo = &mockUserRepo{
+		mockFindById: func(id int) (*User, error) {
+			if id != 1 {
+				return nil, gorm.ErrRecordNotFound
+			}
+			return &User{ID: 1, Name: "Test User", Email: "test@email.com"}, nil
+		},
+	}
+	tokens := newMockTokenRepo()
+	tokenRepo = tokens
+	return tokens
+}
+
+func TestRefresh_RotatesRefreshToken(t *testing.T) {
+	setupTokenTest()
+
+	pair, err := issueTokens(&User{ID: 1, Email: "test@email.com"})
+	if err != nil {
+		t.Fatalf("expected tokens, but got error: %v", err)
+	}
+
+	response, err := Refresh(pair.RefreshToken)
+	if err != nil {
+		t.Fatalf("expected successful refresh, but got error: %v", err)
+	}
+	if response.RefreshToken == "" || response.RefreshToken == pair.RefreshToken {
+		t.Error("expected a new refresh token after rotation")
+	}
+	if response.Token == "" {
+		t.Error("expected a new access token")
+	}
+}
+
+func TestRefresh_ReuseRevokesAllTokens(t *testing.T) {
+	setupTokenTest()
+
+	pair, _ := issueTokens(&User{ID: 1, Email: "test@email.com"})
+	rotated, err := Refresh(pair.RefreshToken)
+	if err != nil {
+		t.Fatalf("expected successful refresh, but got error: %v", err)
+	}
+
+	if _, err := Refresh(pair.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("expected ErrInvalidRefreshToken when reusing a token, but got %v", err)
+	}
+
+	// O token rotacionado também deve ser revogado após o reuso
+	if _, err := Refresh(rotated.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("expected rotated token to be revoked after reuse, but got %v", err)
+	}
+}
+
+func TestRefresh_ExpiredToken(t *testing.T) {
+	tokens := setupTokenTest()
+
+	pair, _ := issueTokens(&User{ID: 1, Email: "test@email.com"})
+	tokens.refresh[hashToken(pair.RefreshToken)].ExpiresAt = time.Now().Add(-time.Minute)
+
+	if _, err := Refresh(pair.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("expected ErrInvalidRefreshToken for an expired token, but got %v", err)
+	}
+}
+
+func TestLogout_RevokesAccessAndRefreshTokens(t *testing.T) {
+	setupTokenTest()
+
+	pair, _ := issueTokens(&User{ID: 1, Email: "test@email.com"})
+	claims, err := ValidateJWT(pair.Token)
+	if err != nil {
+		t.Fatalf("expected valid token, but got error: %v", err)
+	}
+	jti := claims["jti"].(string)
+
+	if err := Logout(1, jti, time.Now().Add(time.Minute), pair.RefreshToken); err != nil {
+		t.Fatalf("expected successful logout, but got error: %v", err)
+	}
+
+	if _, err := ValidateJWT(pair.Token); err == nil {
+		t.Error("expected revoked access token to be rejected")
+	}
+	if _, err := Refresh(pair.RefreshToken); err == nil {
+		t.Error("expected revoked refresh token to be rejected")
+	}
+}
+
+func TestRevokeSession_SignsOutOnlyThatDevice(t *testing.T) {
+	setupTokenTest()
+
+	laptop, _ := issueTokens(&User{ID: 1, Email: "test@email.com"})
+	phone, _ := issueTokens(&User{ID: 1, Email: "test@email.com"})
+	rotated, err := Refresh(phone.RefreshToken)
+	if err != nil {
+		t.Fatalf("expected successful refresh, but got error: %v", err)
+	}
+
+	claims, _ := ValidateJWT(rotated.Token)
+	first, _ := ValidateJWT(phone.Token)
+	if claims["sid"] == "" || claims["sid"] != first["sid"] {
+		t.Fatalf("expected the session ID to survive rotation, got %v and %v", first["sid"], claims["sid"])
+	}
+
+	sessions, _ := ListSessions(1)
+	if len(sessions) != 2 {
+		t.Fatalf("expected 2 active sessions, got %+v", sessions)
+	}
+
+	if found, err := RevokeSession(2, claims["sid"].(string)); found || err != nil {
+		t.Fatalf("expected another user's sign-out to find nothing, got %v %v", found, err)
+	}
+	found, err := RevokeSession(1, claims["sid"].(string))
+	if !found || err != nil {
+		t.Fatalf("expected successful sign-out, got %v %v", found, err)
+	}
+
+	// Todos os access tokens da sessão são recusados, não só os de conexões abertas
+	for _, token := range []string{phone.Token, rotated.Token} {
+		if _, err := ValidateJWT(token); !errors.Is(err, ErrTokenRevoked) {
+			t.Errorf("expected the signed out access token to be rejected, got %v", err)
+		}
+	}
+	if _, err := Refresh(rotated.RefreshToken); err == nil {
+		t.Error("expected the signed out session to be unable to refresh")
+	}
+	// A outra sessão (laptop) continua válida
+	if _, err := ValidateJWT(laptop.Token); err != nil {
+		t.Errorf("expected the other session's access token to keep working, but got %v", err)
+	}
+	if _, err := Refresh(laptop.RefreshToken); err != nil {
+		t.Errorf("expected the other session to keep working, but got %v", err)
+	}
+}
+
+func TestInvalidTokens_RevokedAndDeletedUsers(t *testing.T) {
+	tokens := setupTokenTest()
+	tokens.revoked["revoked"] = true
+	tokens.revokedSessions["phone"] = true
+
+	invalid, err := InvalidTokens([]TokenRef{
+		{ID: "revoked", UserID: 1},
+		{ID: "valid", UserID: 1, SessionID: "laptop"},
+		{ID: "signed-out", UserID: 1, SessionID: "phone"},
+		{ID: "deleted-user", UserID: 2},
+	})
+	if err != nil {
+		t.Fatalf("expected nil, but got error: %v", err)
+	}
+
+	if len(invalid) != 3 || invalid[0] != "revoked" || invalid[1] != "signed-out" || invalid[2] != "deleted-user" {
+		t.Errorf("expected [revoked signed-out deleted-user], but got %v", invalid)
+	}
+}